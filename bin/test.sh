@@ -7,6 +7,10 @@ set -x
 BASE_URL="http://localhost:8081"
 CONTENT_TYPE="Content-Type: application/json"
 
+# Client routes need an API key; webhooks are authenticated by provider signatures
+API_KEY="${API_KEY:-$(docker compose exec -T test-apiserver /messaging-svc apikey create --tenant test --name test.sh)}"
+AUTH="Authorization: Bearer $API_KEY"
+
 echo "=== Testing Messaging Service Endpoints ==="
 echo "Base URL: $BASE_URL"
 echo
@@ -14,6 +18,7 @@ echo
 # Test 1: Send SMS
 echo "1. Testing SMS send..."
 curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTH" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "+12016661234",
@@ -28,6 +33,7 @@ curl -X POST "$BASE_URL/api/messages/sms" \
 # Test 2: Send MMS
 echo "2. Testing MMS send..."
 curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTH" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "+12016661234",
@@ -40,9 +46,82 @@ curl -X POST "$BASE_URL/api/messages/sms" \
   -w "\nStatus: %{http_code}\n\n"
 
 
+# Test 2b: Retry an SMS with an Idempotency-Key (returns the same id)
+echo "2b. Testing idempotent SMS send..."
+IDEM_KEY="test-$(date +%s)"
+for attempt in 1 2; do
+curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTH" \
+  -H "$CONTENT_TYPE" \
+  -H "Idempotency-Key: $IDEM_KEY" \
+  -d '{
+    "from": "+12016661234",
+    "to": "+18045551234",
+    "type": "sms",
+    "body": "Hello! This SMS is sent once however often it is retried.",
+    "attachments": null,
+    "timestamp": "2024-11-01T14:00:00Z"
+  }' \
+  -w "\nStatus: %{http_code}\n\n"
+done
+
+# Test 2c: Schedule an SMS for later
+echo "2c. Testing scheduled SMS send..."
+curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTH" \
+  -H "$CONTENT_TYPE" \
+  -d '{
+    "from": "+12016661234",
+    "to": "+18045551234",
+    "type": "sms",
+    "body": "Hello! This SMS waits until send_at.",
+    "attachments": null,
+    "timestamp": "2024-11-01T14:00:00Z",
+    "send_at": "2099-01-01T09:00:00Z"
+  }' \
+  -w "\nStatus: %{http_code}\n\n"
+
+# Test 2d: Batch send with per-recipient variables
+echo "2d. Testing batch SMS send..."
+curl -X POST "$BASE_URL/api/messages/batch" \
+  -H "$AUTH" \
+  -H "$CONTENT_TYPE" \
+  -d '{
+    "from": "+12016661234",
+    "type": "sms",
+    "body": "Hello {{name}}!",
+    "recipients": [
+      {"to": "+18045551234", "variables": {"name": "Ann"}},
+      {"to": "+18045555678", "variables": {"name": "Bob"}},
+      {"to": "not-a-number", "variables": {"name": "Eve"}}
+    ]
+  }' \
+  -w "\nStatus: %{http_code}\n\n"
+
+# Test 2e: Create a template and send from it
+echo "2e. Testing template send..."
+TEMPLATE_ID=$(curl -s -X POST "$BASE_URL/api/templates" \
+  -H "$AUTH" \
+  -H "$CONTENT_TYPE" \
+  -d "{\"name\": \"welcome-$$\", \"channel\": \"sms\", \"body\": \"Welcome, {{name}}!\"}" \
+  | sed -n 's/.*"id":"\([0-9]*\)".*/\1/p')
+curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTH" \
+  -H "$CONTENT_TYPE" \
+  -d "{
+    \"from\": \"+12016661234\",
+    \"to\": \"+18045551234\",
+    \"type\": \"sms\",
+    \"timestamp\": \"2024-11-01T14:00:00Z\",
+    \"template_id\": \"$TEMPLATE_ID\",
+    \"variables\": {\"name\": \"Ann\"}
+  }" \
+  -w "\nStatus: %{http_code}\n\n"
+
 # Test 3: Send Email
 echo "3. Testing Email send..."
 curl -X POST "$BASE_URL/api/messages/email" \
+  -H "$AUTH" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "user@example.com",
@@ -100,14 +179,26 @@ curl -X POST "$BASE_URL/api/webhooks/email" \
 
 # Test 7: Get conversations
 echo "7. Testing get conversations..."
-curl -X GET "$BASE_URL/api/conversations" \
+curl -X GET "$BASE_URL/api/conversations?limit=10" \
+  -H "$AUTH" \
   -H "$CONTENT_TYPE" \
   -w "\nStatus: %{http_code}\n\n"
 
 # Test 8: Get messages for a conversation (example conversation ID)
 echo "8. Testing get messages for conversation..."
-curl -X GET "$BASE_URL/api/conversations/1/messages" \
+curl -X GET "$BASE_URL/api/conversations/1/messages?limit=2" \
+  -H "$AUTH" \
   -H "$CONTENT_TYPE" \
   -w "\nStatus: %{http_code}\n\n"
 
+# Test 9: Simulate a delivery status callback
+echo "9. Testing delivery status webhook..."
+curl -X POST "$BASE_URL/api/webhooks/status/messaging_provider" \
+  -H "$CONTENT_TYPE" \
+  -d '{
+    "message_id": "message-1",
+    "status": "delivered"
+  }' \
+  -w "\nStatus: %{http_code}\n\n"
+
 echo "=== Test script completed ===" 
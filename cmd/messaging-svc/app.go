@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/rdavison/messaging-service/internal/app"
@@ -28,8 +29,38 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:  "apikey",
+				Usage: "manages tenant API keys",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "creates an API key and prints it",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "tenant", Usage: "tenant name (created if missing)", Value: "default"},
+							&cli.StringFlag{Name: "name", Usage: "label for the key"},
+						},
+						Action: func(c *cli.Context) error {
+							return app.CreateAPIKey(c.Context, c.App.Writer, c.String("tenant"), c.String("name"))
+						},
+					},
+					{
+						Name:  "revoke",
+						Usage: "revokes an API key by its prefix (the first 16 characters)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "prefix", Usage: "key prefix, e.g. msk_0123456789ab", Required: true},
+						},
+						Action: func(c *cli.Context) error {
+							return app.RevokeAPIKey(c.Context, c.App.Writer, c.String("prefix"))
+						},
+					},
+				},
+			},
 		},
 	}
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
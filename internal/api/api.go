@@ -6,14 +6,27 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/logging"
+	"github.com/rdavison/messaging-service/internal/repo"
 )
 
+// statusCallbackRetryAfter is the Retry-After of a status callback answered
+// 503 because it names a message not stored yet.
+const statusCallbackRetryAfter = time.Minute
+
 func (h *handler) handleConversations(w http.ResponseWriter, r *http.Request, idStr *string) {
 	if r.Method != http.MethodGet {
 		respondBadRequest(w)
 		return
 	}
-	convs, err := h.getConversations(r.Context(), idStr)
+	q, err := parseConversationQuery(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	convs, next, err := h.getConversations(r.Context(), idStr, q)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrBadID):
@@ -21,11 +34,11 @@ func (h *handler) handleConversations(w http.ResponseWriter, r *http.Request, id
 		case errors.Is(err, ErrNotFound):
 			respondNotFound(w, r)
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
-	respondJSON(w, http.StatusOK, conversationsResponse{Conversations: convs})
+	respondJSON(w, http.StatusOK, conversationsResponse{Conversations: convs, NextCursor: next})
 }
 
 func (h *handler) handleConversationMessages(w http.ResponseWriter, r *http.Request, convIDStr string) {
@@ -33,7 +46,12 @@ func (h *handler) handleConversationMessages(w http.ResponseWriter, r *http.Requ
 		respondBadRequest(w)
 		return
 	}
-	msgs, err := h.getConversationMessages(r.Context(), convIDStr, 200, 0)
+	q, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	msgs, next, err := h.getConversationMessages(r.Context(), convIDStr, q)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrBadID):
@@ -41,11 +59,11 @@ func (h *handler) handleConversationMessages(w http.ResponseWriter, r *http.Requ
 		case errors.Is(err, ErrNotFound):
 			respondNotFound(w, r)
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
-	respondJSON(w, http.StatusOK, messagesResponse{Messages: msgs})
+	respondJSON(w, http.StatusOK, messagesResponse{Messages: msgs, NextCursor: next})
 }
 
 func (h *handler) handleMessagesRoot(w http.ResponseWriter, r *http.Request, idStr *string) {
@@ -53,7 +71,12 @@ func (h *handler) handleMessagesRoot(w http.ResponseWriter, r *http.Request, idS
 		respondBadRequest(w)
 		return
 	}
-	msgs, err := h.getMessages(r.Context(), idStr, 200, 0)
+	q, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	msgs, next, err := h.getMessages(r.Context(), idStr, q)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrBadID):
@@ -61,11 +84,350 @@ func (h *handler) handleMessagesRoot(w http.ResponseWriter, r *http.Request, idS
 		case errors.Is(err, ErrNotFound):
 			respondNotFound(w, r)
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, messagesResponse{Messages: msgs, NextCursor: next})
+}
+
+// handleMessageEvents returns the delivery timeline of a message.
+func (h *handler) handleMessageEvents(w http.ResponseWriter, r *http.Request, idStr string) {
+	events, err := h.getMessageEvents(r.Context(), idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, messageEventsResponse{Events: events})
+}
+
+func (h *handler) handleMessagePatch(w http.ResponseWriter, r *http.Request, idStr string) {
+	var req rescheduleRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	m, err := h.rescheduleMessage(ctx, idStr, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrBadTimestamp):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		case errors.Is(err, repo.ErrNotScheduled):
+			respondConflict(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, messagesResponse{Messages: []domain.Message{m}})
+}
+
+// handleMessageCancel serves both POST /messages/{id}/cancel, which returns
+// the canceled message, and DELETE /messages/{id}, which returns no body.
+func (h *handler) handleMessageCancel(w http.ResponseWriter, r *http.Request, idStr string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	m, err := h.cancelMessage(ctx, idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		case errors.Is(err, repo.ErrNotCancelable):
+			respondConflict(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondJSON(w, http.StatusOK, messagesResponse{Messages: []domain.Message{m}})
+}
+
+func (h *handler) handleMessagesBulkCancel(w http.ResponseWriter, r *http.Request) {
+	q, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ids, err := h.cancelMessages(ctx, r.URL.Query().Get("conversation_id"), q)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrNoFilter):
+			respondBadRequest(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, cancelResponse{Canceled: ids})
+}
+
+func (h *handler) handleMessagesBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	resp, err := h.createBatch(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadType), errors.Is(err, ErrBadTimestamp), errors.Is(err, ErrBadBatch),
+			errors.Is(err, ErrBodyAndTemplate):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrUnknownTemplate), errors.Is(err, ErrTemplateChannel):
+			respondUnprocessableEntity(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) handleBatchByID(w http.ResponseWriter, r *http.Request, idStr string) {
+	b, err := h.getBatch(r.Context(), idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, batchProgressResponse{Batch: b})
+}
+
+func (h *handler) handleTemplatesIndex(w http.ResponseWriter, r *http.Request) {
+	q, err := parseTemplateQuery(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	ts, next, err := h.getTemplates(r.Context(), q)
+	if err != nil {
+		respondInternalServerError(w, r, "db error", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, templatesResponse{Templates: ts, NextCursor: next})
+}
+
+func (h *handler) handleTemplatesCreate(w http.ResponseWriter, r *http.Request) {
+	var req templateCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	t, err := h.createTemplate(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadType), errors.Is(err, domain.ErrBadTemplate):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, repo.ErrTemplateExists):
+			respondConflict(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusCreated, templatesResponse{Templates: []domain.Template{t}})
+}
+
+// handleTemplateByID returns the latest version of a template, or the one
+// named by ?version=.
+func (h *handler) handleTemplateByID(w http.ResponseWriter, r *http.Request, idStr string) {
+	t, err := h.getTemplate(r.Context(), idStr, r.URL.Query().Get("version"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrBadFilter):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
-	respondJSON(w, http.StatusOK, messagesResponse{Messages: msgs})
+	respondJSON(w, http.StatusOK, templatesResponse{Templates: []domain.Template{t}})
+}
+
+func (h *handler) handleTemplateVersions(w http.ResponseWriter, r *http.Request, idStr string) {
+	ts, err := h.getTemplateVersions(r.Context(), idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, templatesResponse{Templates: ts})
+}
+
+// handleTemplateUpdate stores the body as a new version; earlier versions and
+// the messages rendered from them are unchanged.
+func (h *handler) handleTemplateUpdate(w http.ResponseWriter, r *http.Request, idStr string) {
+	var req templateUpdateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	t, err := h.updateTemplate(r.Context(), idStr, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, domain.ErrBadTemplate):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, templatesResponse{Templates: []domain.Template{t}})
+}
+
+func (h *handler) handleTemplateDelete(w http.ResponseWriter, r *http.Request, idStr string) {
+	if err := h.deleteTemplate(r.Context(), idStr); err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) handleSubscriptionsIndex(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.subs.List(r.Context(), tenantFrom(r.Context()))
+	if err != nil {
+		respondInternalServerError(w, r, "db error", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, subscriptionsResponse{Subscriptions: subs})
+}
+
+// handleSubscriptionsCreate returns the new subscription with its signing
+// secret, which is not shown again.
+func (h *handler) handleSubscriptionsCreate(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	s, err := h.createSubscription(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadSubscription):
+			respondBadRequest(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusCreated, subscriptionsResponse{Subscriptions: []domain.Subscription{s}})
+}
+
+func (h *handler) handleSubscriptionByID(w http.ResponseWriter, r *http.Request, idStr string) {
+	s, err := h.getSubscription(r.Context(), idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, subscriptionsResponse{Subscriptions: []domain.Subscription{s}})
+}
+
+func (h *handler) handleSubscriptionUpdate(w http.ResponseWriter, r *http.Request, idStr string) {
+	var req subscriptionUpdateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		respondBadRequest(w, "json decode: ", err)
+		return
+	}
+	s, err := h.updateSubscription(r.Context(), idStr, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrBadSubscription):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, subscriptionsResponse{Subscriptions: []domain.Subscription{s}})
+}
+
+func (h *handler) handleSubscriptionDelete(w http.ResponseWriter, r *http.Request, idStr string) {
+	if err := h.deleteSubscription(r.Context(), idStr); err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSubscriptionDeliveries returns the latest deliveries of a
+// subscription with every attempt, up to ?limit=.
+func (h *handler) handleSubscriptionDeliveries(w http.ResponseWriter, r *http.Request, idStr string) {
+	limit, err := parseLimit(r.URL.Query())
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	ds, err := h.getSubscriptionDeliveries(r.Context(), idStr, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, deliveriesResponse{Deliveries: ds})
 }
 
 func (h *handler) handleMessagesSMSOutbound(w http.ResponseWriter, r *http.Request) {
@@ -78,18 +440,33 @@ func (h *handler) handleMessagesSMSOutbound(w http.ResponseWriter, r *http.Reque
 		respondBadRequest(w, "json decode: ", err)
 		return
 	}
+	idem, err := idempotencyKeyFrom(r, req)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	id, err := h.createSMSOutbound(ctx, req)
+	id, replayed, err := h.createSMSOutbound(ctx, req, idem)
 	if err != nil {
 		switch {
-		case errors.Is(err, ErrBadType), errors.Is(err, ErrBadTimestamp):
+		case errors.Is(err, ErrBadType), errors.Is(err, ErrBadTimestamp), errors.Is(err, ErrBodyAndTemplate):
 			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrUnknownTemplate), errors.Is(err, ErrTemplateChannel), errors.Is(err, domain.ErrMissingVariable):
+			respondUnprocessableEntity(w, err.Error())
+		case errors.Is(err, repo.ErrIdempotencyMismatch):
+			respondUnprocessableEntity(w, err.Error())
+		case errors.Is(err, repo.ErrIdempotencyInFlight):
+			respondConflict(w, err.Error())
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
+	logging.Annotate(r.Context(), "message_id", id)
+	if replayed {
+		w.Header().Set(headerIdempotentReplayed, "true")
+	}
 	respondJSON(w, http.StatusOK, idResponse{ID: strconv.FormatInt(id, 10)})
 }
 
@@ -103,17 +480,35 @@ func (h *handler) handleMessagesEmailOutbound(w http.ResponseWriter, r *http.Req
 		respondBadRequest(w)
 		return
 	}
+	idem, err := idempotencyKeyFrom(r, req)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	id, err := h.createEmailOutbound(ctx, req)
+	id, replayed, err := h.createEmailOutbound(ctx, req, idem)
 	if err != nil {
-		if errors.Is(err, ErrBadTimestamp) {
+		switch {
+		case errors.Is(err, ErrBadTimestamp):
 			respondBadRequest(w)
-		} else {
-			respondInternalServerError(w, "db error")
+		case errors.Is(err, ErrBodyAndTemplate):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrUnknownTemplate), errors.Is(err, ErrTemplateChannel), errors.Is(err, domain.ErrMissingVariable):
+			respondUnprocessableEntity(w, err.Error())
+		case errors.Is(err, repo.ErrIdempotencyMismatch):
+			respondUnprocessableEntity(w, err.Error())
+		case errors.Is(err, repo.ErrIdempotencyInFlight):
+			respondConflict(w, err.Error())
+		default:
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
+	logging.Annotate(r.Context(), "message_id", id)
+	if replayed {
+		w.Header().Set(headerIdempotentReplayed, "true")
+	}
 	respondJSON(w, http.StatusOK, idResponse{ID: strconv.FormatInt(id, 10)})
 }
 
@@ -136,7 +531,7 @@ func (h *handler) handleWebhooksSMSInbound(w http.ResponseWriter, r *http.Reques
 		case errors.Is(err, ErrNoProvider), errors.Is(err, ErrBadType), errors.Is(err, ErrBadTimestamp):
 			respondBadRequest(w)
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
@@ -161,9 +556,82 @@ func (h *handler) handleWebhooksEmailInbound(w http.ResponseWriter, r *http.Requ
 		case errors.Is(err, ErrNoProvider), errors.Is(err, ErrBadTimestamp):
 			respondBadRequest(w)
 		default:
-			respondInternalServerError(w, "db error")
+			respondInternalServerError(w, r, "db error", err)
 		}
 		return
 	}
 	respondJSON(w, http.StatusOK, idResponse{ID: strconv.FormatInt(id, 10)})
 }
+
+func (h *handler) handleWebhooksStatus(w http.ResponseWriter, r *http.Request, providerStr string) {
+	if r.Method != http.MethodPost {
+		respondBadRequest(w)
+		return
+	}
+	p, ok := domain.ParseProvider(providerStr)
+	if !ok {
+		respondNotFound(w, r)
+		return
+	}
+	cbs, err := parseStatusCallbacks(p, r)
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	resp, err := h.applyStatusCallbacks(ctx, p, cbs)
+	if err != nil {
+		respondInternalServerError(w, r, "db error", err)
+		return
+	}
+	if resp.Unknown > 0 {
+		// the processor may not have stored the provider message id yet; the
+		// provider resends the callback, and the ones applied now come back stale
+		w.Header().Set("Retry-After", strconv.Itoa(int(statusCallbackRetryAfter.Seconds())))
+		respondJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) handleWebhooksProviderInbound(w http.ResponseWriter, r *http.Request, providerStr, kind string) {
+	if r.Method != http.MethodPost {
+		respondBadRequest(w)
+		return
+	}
+	p, ok := domain.ParseProvider(providerStr)
+	if !ok {
+		respondNotFound(w, r)
+		return
+	}
+	adapt, ok := inboundAdapters[p][kind]
+	if !ok {
+		respondNotFound(w, r)
+		return
+	}
+	in, err := adapt(r, p)
+	if errors.Is(err, ErrAttachmentTooLarge) {
+		respondJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		respondBadRequest(w, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	id, err := h.createInbound(ctx, in)
+	if err != nil {
+		respondInternalServerError(w, r, "db error", err)
+		return
+	}
+	if p == domain.ProviderTwilio {
+		// Twilio expects TwiML; an empty response sends no reply
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Response></Response>`))
+		return
+	}
+	respondJSON(w, http.StatusOK, idResponse{ID: strconv.FormatInt(id, 10)})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rdavison/messaging-service/internal/logging"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+type tenantKey struct{}
+
+// withTenant returns a copy of ctx carrying the authenticated tenant id.
+func withTenant(ctx context.Context, tenantID int64) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// tenantFrom returns the tenant set by authenticate. Handlers behind the
+// middleware can rely on it being present.
+func tenantFrom(ctx context.Context) int64 {
+	id, _ := ctx.Value(tenantKey{}).(int64)
+	return id
+}
+
+// apiKeyFrom reads the key from "Authorization: Bearer <key>" or "X-API-Key".
+func apiKeyFrom(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, key, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// authenticate rejects requests without a valid API key and scopes the rest
+// to the key's tenant.
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFrom(r)
+		if key == "" {
+			respondUnauthorized(w, "missing api key")
+			return
+		}
+		tenantID, err := h.keys.Authenticate(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				respondUnauthorized(w, "invalid api key")
+			} else {
+				respondInternalServerError(w, r, "db error", err)
+			}
+			return
+		}
+		logging.Annotate(r.Context(), "tenant_id", tenantID)
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenantID)))
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+type fakeKeys map[string]int64
+
+func (f fakeKeys) Authenticate(_ context.Context, key string) (int64, error) {
+	id, ok := f[key]
+	if !ok {
+		return 0, repo.ErrNotFound
+	}
+	return id, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := &handler{keys: fakeKeys{"msk_good": 7}}
+	var got int64
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tenantFrom(r.Context())
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		code   int
+		tenant int64
+	}{
+		{"bearer", "Authorization", "Bearer msk_good", http.StatusOK, 7},
+		{"x-api-key", "X-API-Key", "msk_good", http.StatusOK, 7},
+		{"unknown", "Authorization", "Bearer msk_bad", http.StatusUnauthorized, 0},
+		{"wrong scheme", "Authorization", "Basic msk_good", http.StatusUnauthorized, 0},
+		{"missing", "", "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = 0
+			r := httptest.NewRequest(http.MethodGet, "/api/messages", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			h.authenticate(next).ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d", w.Code, tt.code)
+			}
+			if got != tt.tenant {
+				t.Fatalf("tenant = %d, want %d", got, tt.tenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// statusCallback is one delivery update reported by a provider. Status is
+// empty for events that do not change a status we track (e.g. "queued").
+type statusCallback struct {
+	ProviderMessageID string
+	Status            domain.Status
+	Payload           string
+}
+
+// genericStatusRequest is the callback shape accepted from providers without a
+// native format of their own (messaging_provider, xillio).
+type genericStatusRequest struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// sendgridEvent is the subset of a SendGrid Event Webhook event we read.
+type sendgridEvent struct {
+	Event        string `json:"event"`
+	SGMessageID  string `json:"sg_message_id"`
+	Reason       string `json:"reason"`
+	Response     string `json:"response"`
+	BounceType   string `json:"type"`
+	BounceStatus string `json:"status"`
+}
+
+var errNoMessageID = errors.New("missing provider message id")
+
+// parseStatusCallbacks decodes the delivery callback body posted by p.
+func parseStatusCallbacks(p domain.Provider, r *http.Request) ([]statusCallback, error) {
+	switch p {
+	case domain.ProviderTwilio:
+		return parseTwilioStatusCallback(r)
+	case domain.ProviderSendgrid:
+		return parseSendgridEvents(r.Body)
+	default:
+		return parseGenericStatusCallback(r.Body)
+	}
+}
+
+// parseTwilioStatusCallback reads Twilio's form-encoded StatusCallback request.
+func parseTwilioStatusCallback(r *http.Request) ([]statusCallback, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	sid := r.PostForm.Get("MessageSid")
+	if sid == "" {
+		return nil, errNoMessageID
+	}
+	state := r.PostForm.Get("MessageStatus")
+	var status domain.Status
+	switch state {
+	case "sent":
+		status = domain.StatusSent
+	case "delivered", "read":
+		status = domain.StatusDelivered
+	case "undelivered":
+		status = domain.StatusUndelivered
+	case "failed":
+		status = domain.StatusFailed
+	}
+	payload := "twilio status: " + state
+	if code := r.PostForm.Get("ErrorCode"); code != "" {
+		payload += " (error " + code + ")"
+	}
+	return []statusCallback{{ProviderMessageID: sid, Status: status, Payload: payload}}, nil
+}
+
+// parseSendgridEvents reads a SendGrid Event Webhook batch.
+func parseSendgridEvents(body io.ReadCloser) ([]statusCallback, error) {
+	defer body.Close()
+	var events []sendgridEvent
+	if err := json.NewDecoder(body).Decode(&events); err != nil {
+		return nil, err
+	}
+	out := make([]statusCallback, 0, len(events))
+	for _, e := range events {
+		// sg_message_id is the X-Message-Id we stored, plus a ".filter..." suffix
+		id, _, _ := strings.Cut(e.SGMessageID, ".")
+		if id == "" {
+			continue
+		}
+		var status domain.Status
+		switch e.Event {
+		case "processed":
+			status = domain.StatusSent
+		case "delivered":
+			status = domain.StatusDelivered
+		case "bounce":
+			status = domain.StatusBounced
+		case "dropped":
+			status = domain.StatusUndelivered
+		}
+		payload := "sendgrid event: " + e.Event
+		for _, s := range []string{e.BounceType, e.BounceStatus, e.Reason, e.Response} {
+			if s != "" {
+				payload += "; " + s
+			}
+		}
+		out = append(out, statusCallback{ProviderMessageID: id, Status: status, Payload: payload})
+	}
+	return out, nil
+}
+
+func parseGenericStatusCallback(body io.ReadCloser) ([]statusCallback, error) {
+	var req genericStatusRequest
+	if err := decodeJSON(body, &req); err != nil {
+		return nil, err
+	}
+	if req.MessageID == "" {
+		return nil, errNoMessageID
+	}
+	status := domain.Status(strings.ToLower(req.Status))
+	switch status {
+	case domain.StatusSent, domain.StatusDelivered, domain.StatusUndelivered, domain.StatusBounced, domain.StatusFailed:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrBadStatus, req.Status)
+	}
+	payload := "status: " + string(status)
+	if req.Detail != "" {
+		payload += "; " + req.Detail
+	}
+	return []statusCallback{{ProviderMessageID: req.MessageID, Status: status, Payload: payload}}, nil
+}
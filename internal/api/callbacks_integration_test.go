@@ -0,0 +1,41 @@
+//go:build integration
+// +build integration
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+func TestStatusCallbackUnknownMessage(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	h := &handler{msgs: repo.NewMessageRepo(pool)}
+	body := `{"message_id": "unknown-` + uuid.NewString() + `", "status": "delivered"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/status/xillio", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.handleWebhooksStatus(w, r, "xillio")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("got %d %v %s; want 503 with Retry-After so the provider resends", w.Code, w.Header(), w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"unknown":1`) {
+		t.Fatalf("body %s", w.Body)
+	}
+}
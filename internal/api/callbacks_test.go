@@ -0,0 +1,67 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestParseTwilioStatusCallback(t *testing.T) {
+	form := "MessageSid=SM1&MessageStatus=undelivered&ErrorCode=30003"
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/status/twilio", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	cbs, err := parseStatusCallbacks(domain.ProviderTwilio, r)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cbs) != 1 || cbs[0].ProviderMessageID != "SM1" || cbs[0].Status != domain.StatusUndelivered {
+		t.Fatalf("unexpected callbacks %+v", cbs)
+	}
+	if !strings.Contains(cbs[0].Payload, "30003") {
+		t.Fatalf("payload = %q", cbs[0].Payload)
+	}
+}
+
+func TestParseSendgridEvents(t *testing.T) {
+	body := `[
+	  {"event":"processed","sg_message_id":"abc.filter0001"},
+	  {"event":"bounce","sg_message_id":"abc.filter0002","reason":"550 no such user","type":"bounce"},
+	  {"event":"open","sg_message_id":"abc.filter0003"},
+	  {"event":"delivered"}
+	]`
+	cbs, err := parseSendgridEvents(io.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cbs) != 3 {
+		t.Fatalf("want 3 callbacks (event without id dropped), got %+v", cbs)
+	}
+	want := []domain.Status{domain.StatusSent, domain.StatusBounced, ""}
+	for i, cb := range cbs {
+		if cb.ProviderMessageID != "abc" || cb.Status != want[i] {
+			t.Fatalf("callback %d = %+v, want status %q", i, cb, want[i])
+		}
+	}
+}
+
+func TestParseGenericStatusCallback(t *testing.T) {
+	cbs, err := parseGenericStatusCallback(io.NopCloser(strings.NewReader(`{"message_id":"m-1","status":"Delivered"}`)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cbs) != 1 || cbs[0].Status != domain.StatusDelivered {
+		t.Fatalf("unexpected callbacks %+v", cbs)
+	}
+
+	if _, err := parseGenericStatusCallback(io.NopCloser(strings.NewReader(`{"message_id":"m-1","status":"outbox"}`))); err == nil {
+		t.Fatal("expected error for non-delivery status")
+	}
+	if _, err := parseGenericStatusCallback(io.NopCloser(strings.NewReader(`{"status":"sent"}`))); err == nil {
+		t.Fatal("expected error without message id")
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+var ErrBadIdempotencyKey = errors.New("bad idempotency key")
+
+// idempotencyKeyFrom reads the request's Idempotency-Key header, if any, and
+// fingerprints the decoded request body together with the path. Decoded
+// rather than raw bytes are hashed, so a retry that only reorders fields or
+// changes whitespace still matches.
+func idempotencyKeyFrom(r *http.Request, req any) (*repo.IdempotencyKey, error) {
+	key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey))
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, ErrBadIdempotencyKey
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	sum.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	sum.Write(b)
+	return &repo.IdempotencyKey{Key: key, Fingerprint: hex.EncodeToString(sum.Sum(nil))}, nil
+}
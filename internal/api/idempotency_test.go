@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyKeyFrom(t *testing.T) {
+	req := func(path, key string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		if key != "" {
+			r.Header.Set(headerIdempotencyKey, key)
+		}
+		return r
+	}
+	body := smsOutboundRequest{From: "+1", To: "+2", Type: "sms", Body: "hi", Timestamp: "2024-11-01T14:00:00Z"}
+
+	if k, err := idempotencyKeyFrom(req("/api/messages/sms", ""), body); k != nil || err != nil {
+		t.Fatalf("no header: got %v, %v", k, err)
+	}
+
+	a, err := idempotencyKeyFrom(req("/api/messages/sms", "k1"), body)
+	if err != nil || a == nil || a.Key != "k1" {
+		t.Fatalf("got %+v, %v", a, err)
+	}
+	b, _ := idempotencyKeyFrom(req("/api/messages/sms", "k1"), body)
+	if a.Fingerprint != b.Fingerprint {
+		t.Fatalf("same request, different fingerprints")
+	}
+
+	changed := body
+	changed.Body = "hello"
+	c, _ := idempotencyKeyFrom(req("/api/messages/sms", "k1"), changed)
+	if c.Fingerprint == a.Fingerprint {
+		t.Fatalf("different body, same fingerprint")
+	}
+	d, _ := idempotencyKeyFrom(req("/api/messages/email", "k1"), body)
+	if d.Fingerprint == a.Fingerprint {
+		t.Fatalf("different path, same fingerprint")
+	}
+
+	if _, err := idempotencyKeyFrom(req("/api/messages/sms", strings.Repeat("x", 256)), body); err != ErrBadIdempotencyKey {
+		t.Fatalf("want ErrBadIdempotencyKey, got %v", err)
+	}
+}
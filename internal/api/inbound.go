@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// inboundMessage is an inbound message decoded from any provider format.
+type inboundMessage struct {
+	Provider          domain.Provider
+	ProviderMessageID string
+	Kind              domain.EndpointKind
+	PhoneChannel      domain.PhoneChannel // only set when Kind == phone
+	From              string
+	To                string
+	Body              string
+	Attachments       []string
+	Timestamp         time.Time
+}
+
+// inboundAdapter decodes a provider's native inbound webhook request.
+type inboundAdapter func(r *http.Request, p domain.Provider) (inboundMessage, error)
+
+// inboundAdapters maps a provider and channel kind ("sms" or "email") to the
+// adapter for that provider's native format. Providers without a native
+// format post our JSON shape.
+var inboundAdapters = map[domain.Provider]map[string]inboundAdapter{
+	domain.ProviderTwilio:            {"sms": parseTwilioInboundSMS},
+	domain.ProviderSendgrid:          {"email": parseSendgridInboundParse},
+	domain.ProviderMessagingProvider: {"sms": parseJSONInboundSMS, "email": parseJSONInboundEmail},
+	domain.ProviderXillio:            {"sms": parseJSONInboundSMS, "email": parseJSONInboundEmail},
+}
+
+// Inbound files are kept as data: URLs in the message row, so their size is
+// bounded per file and per message.
+const (
+	maxInlineAttachment  = 5 << 20
+	maxInlineAttachments = 10 << 20
+)
+
+// twilioMaxMedia is the most media Twilio attaches to one message.
+const twilioMaxMedia = 10
+
+var (
+	ErrBadNumMedia        = errors.New("bad NumMedia")
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+)
+
+// parseTwilioInboundSMS reads Twilio's form-encoded incoming message request
+// (From, To, Body, NumMedia, MediaUrl0..N, MessageSid).
+func parseTwilioInboundSMS(r *http.Request, p domain.Provider) (inboundMessage, error) {
+	if err := r.ParseForm(); err != nil {
+		return inboundMessage{}, err
+	}
+	f := r.PostForm
+	sid := f.Get("MessageSid")
+	if sid == "" {
+		sid = f.Get("SmsMessageSid")
+	}
+	if sid == "" {
+		return inboundMessage{}, ErrNoProvider
+	}
+	numMedia := 0
+	if v := f.Get("NumMedia"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return inboundMessage{}, ErrBadNumMedia
+		}
+		numMedia = min(n, twilioMaxMedia)
+	}
+	var media []string
+	for i := 0; i < numMedia; i++ {
+		if u := f.Get(fmt.Sprintf("MediaUrl%d", i)); u != "" {
+			media = append(media, u)
+		}
+	}
+	ch := domain.PhoneChannelSMS
+	if len(media) > 0 {
+		ch = domain.PhoneChannelMMS
+	}
+	return inboundMessage{
+		Provider:          p,
+		ProviderMessageID: sid,
+		Kind:              domain.EndpointKindPhone,
+		PhoneChannel:      ch,
+		From:              f.Get("From"),
+		To:                f.Get("To"),
+		Body:              f.Get("Body"),
+		Attachments:       media,
+		Timestamp:         time.Now().UTC(),
+	}, nil
+}
+
+// parseSendgridInboundParse reads a SendGrid Inbound Parse multipart post
+// (headers, envelope, from, to, text, html and attachmentN file parts).
+// Files are kept as data: URLs since there is no blob store to put them in;
+// a message with larger files than that allows is refused rather than
+// stored without them.
+func parseSendgridInboundParse(r *http.Request, p domain.Provider) (inboundMessage, error) {
+	if err := r.ParseMultipartForm(maxWebhookBody); err != nil {
+		return inboundMessage{}, err
+	}
+	f := r.MultipartForm.Value
+	get := func(k string) string {
+		if vs := f[k]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+
+	hdr := parseMailHeaders(get("headers"))
+
+	from, to := firstAddress(get("from")), firstAddress(get("to"))
+	var env struct {
+		From string   `json:"from"`
+		To   []string `json:"to"`
+	}
+	if err := json.Unmarshal([]byte(get("envelope")), &env); err == nil {
+		if from == "" {
+			from = env.From
+		}
+		if len(env.To) > 0 {
+			to = env.To[0]
+		}
+	}
+	if from == "" || to == "" {
+		return inboundMessage{}, fmt.Errorf("inbound parse: missing from/to")
+	}
+
+	body := get("html")
+	if body == "" {
+		body = get("text")
+	}
+
+	msgID := strings.Trim(hdr.Get("Message-Id"), "<> ")
+	if msgID == "" {
+		// no Message-ID header: derive a stable id so redelivery still dedupes
+		sum := sha256.Sum256([]byte(get("headers") + "\n" + body))
+		msgID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	ts := time.Now().UTC()
+	if d, err := mail.ParseDate(hdr.Get("Date")); err == nil {
+		ts = d.UTC()
+	}
+
+	var atts []string
+	var total int64
+	for _, files := range r.MultipartForm.File {
+		for _, fh := range files {
+			total += fh.Size
+			if fh.Size > maxInlineAttachment || total > maxInlineAttachments {
+				slog.WarnContext(r.Context(), "inbound email refused: attachments too large",
+					"provider_message_id", msgID, "filename", fh.Filename, "size", fh.Size, "total_size", total)
+				return inboundMessage{}, fmt.Errorf("%w: %q (%d bytes); files are limited to %d bytes each and %d in total",
+					ErrAttachmentTooLarge, fh.Filename, fh.Size, maxInlineAttachment, maxInlineAttachments)
+			}
+			u, err := dataURL(fh)
+			if err != nil {
+				return inboundMessage{}, err
+			}
+			atts = append(atts, u)
+		}
+	}
+
+	return inboundMessage{
+		Provider:          p,
+		ProviderMessageID: msgID,
+		Kind:              domain.EndpointKindEmail,
+		From:              from,
+		To:                to,
+		Body:              body,
+		Attachments:       atts,
+		Timestamp:         ts,
+	}, nil
+}
+
+// parseJSONInboundSMS accepts our JSON shape on a provider-specific route; the
+// "<provider>_id" key must name the provider in the path.
+func parseJSONInboundSMS(r *http.Request, p domain.Provider) (inboundMessage, error) {
+	raw, id, err := decodeProviderJSON(r, p)
+	if err != nil {
+		return inboundMessage{}, err
+	}
+	b, _ := json.Marshal(raw)
+	var req smsInboundRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return inboundMessage{}, err
+	}
+	ts, err := time.Parse(time.RFC3339, req.Timestamp)
+	if err != nil {
+		return inboundMessage{}, ErrBadTimestamp
+	}
+	ch := domain.PhoneChannel(strings.ToLower(req.Type))
+	if ch != domain.PhoneChannelSMS && ch != domain.PhoneChannelMMS {
+		return inboundMessage{}, ErrBadType
+	}
+	return inboundMessage{
+		Provider:          p,
+		ProviderMessageID: id,
+		Kind:              domain.EndpointKindPhone,
+		PhoneChannel:      ch,
+		From:              req.From,
+		To:                req.To,
+		Body:              req.Body,
+		Attachments:       req.Attachments,
+		Timestamp:         ts,
+	}, nil
+}
+
+func parseJSONInboundEmail(r *http.Request, p domain.Provider) (inboundMessage, error) {
+	raw, id, err := decodeProviderJSON(r, p)
+	if err != nil {
+		return inboundMessage{}, err
+	}
+	b, _ := json.Marshal(raw)
+	var req emailInboundRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return inboundMessage{}, err
+	}
+	ts, err := time.Parse(time.RFC3339, req.Timestamp)
+	if err != nil {
+		return inboundMessage{}, ErrBadTimestamp
+	}
+	return inboundMessage{
+		Provider:          p,
+		ProviderMessageID: id,
+		Kind:              domain.EndpointKindEmail,
+		From:              req.From,
+		To:                req.To,
+		Body:              req.Body,
+		Attachments:       req.Attachments,
+		Timestamp:         ts,
+	}, nil
+}
+
+func decodeProviderJSON(r *http.Request, p domain.Provider) (map[string]any, string, error) {
+	var raw map[string]any
+	if err := decodeJSON(r.Body, &raw); err != nil {
+		return nil, "", err
+	}
+	got, id, ok := extractProviderID(raw)
+	if !ok || got != p {
+		return nil, "", ErrNoProvider
+	}
+	return raw, id, nil
+}
+
+// parseMailHeaders parses a raw RFC 5322 header block, ignoring errors.
+func parseMailHeaders(raw string) textproto.MIMEHeader {
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimRight(raw, "\r\n") + "\r\n\r\n")))
+	h, _ := tp.ReadMIMEHeader()
+	return h
+}
+
+// firstAddress returns the bare address of the first entry in an address
+// list such as `"Jane" <jane@example.com>, bob@example.com`.
+func firstAddress(list string) string {
+	if list == "" {
+		return ""
+	}
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil || len(addrs) == 0 {
+		return strings.TrimSpace(list)
+	}
+	return addrs[0].Address
+}
+
+// dataURL encodes an uploaded file as a data: URL.
+func dataURL(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxInlineAttachment))
+	if err != nil {
+		return "", err
+	}
+	ctype := fh.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ctype); err == nil {
+		ctype = mt
+	} else {
+		ctype = http.DetectContentType(data)
+	}
+	return "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestParseTwilioInboundSMS(t *testing.T) {
+	form := "MessageSid=SM9&From=%2B18045551234&To=%2B12016661234&Body=hello&NumMedia=2" +
+		"&MediaUrl0=https%3A%2F%2Fapi.twilio.com%2Fm0&MediaUrl1=https%3A%2F%2Fapi.twilio.com%2Fm1"
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/twilio/sms", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	in, err := parseTwilioInboundSMS(r, domain.ProviderTwilio)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if in.ProviderMessageID != "SM9" || in.From != "+18045551234" || in.To != "+12016661234" || in.Body != "hello" {
+		t.Fatalf("unexpected message %+v", in)
+	}
+	if in.PhoneChannel != domain.PhoneChannelMMS || len(in.Attachments) != 2 || in.Attachments[1] != "https://api.twilio.com/m1" {
+		t.Fatalf("media: %+v", in)
+	}
+}
+
+func TestParseTwilioInboundNumMedia(t *testing.T) {
+	parse := func(numMedia string) (inboundMessage, error) {
+		form := "MessageSid=SM9&From=%2B18045551234&To=%2B12016661234&NumMedia=" + numMedia
+		for i := 0; i < 12; i++ {
+			form += fmt.Sprintf("&MediaUrl%d=https%%3A%%2F%%2Fapi.twilio.com%%2Fm%d", i, i)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks/twilio/sms", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return parseTwilioInboundSMS(r, domain.ProviderTwilio)
+	}
+
+	in, err := parse("1000000000")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(in.Attachments) != twilioMaxMedia {
+		t.Fatalf("attachments = %d, want %d", len(in.Attachments), twilioMaxMedia)
+	}
+	for _, v := range []string{"-1", "two", "1e3"} {
+		if _, err := parse(v); !errors.Is(err, ErrBadNumMedia) {
+			t.Fatalf("NumMedia=%s: err = %v, want ErrBadNumMedia", v, err)
+		}
+	}
+}
+
+func TestParseSendgridInboundParse(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("headers", "Message-ID: <abc@mail.example.com>\nDate: Fri, 01 Nov 2024 14:00:00 +0000\nSubject: hi")
+	_ = mw.WriteField("from", `"Jane" <jane@example.com>`)
+	_ = mw.WriteField("to", "support@example.com, other@example.com")
+	_ = mw.WriteField("envelope", `{"to":["support@example.com"],"from":"jane@example.com"}`)
+	_ = mw.WriteField("text", "plain")
+	_ = mw.WriteField("html", "<p>rich</p>")
+	fw, _ := mw.CreateFormFile("attachment1", "note.txt")
+	_, _ = fw.Write([]byte("attached"))
+	_ = mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/sendgrid/email", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	in, err := parseSendgridInboundParse(r, domain.ProviderSendgrid)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if in.ProviderMessageID != "abc@mail.example.com" {
+		t.Fatalf("message id = %q", in.ProviderMessageID)
+	}
+	if in.From != "jane@example.com" || in.To != "support@example.com" || in.Body != "<p>rich</p>" {
+		t.Fatalf("unexpected message %+v", in)
+	}
+	if in.Timestamp.Year() != 2024 {
+		t.Fatalf("timestamp = %v", in.Timestamp)
+	}
+	if len(in.Attachments) != 1 || !strings.HasPrefix(in.Attachments[0], "data:") {
+		t.Fatalf("attachments = %v", in.Attachments)
+	}
+}
+
+func TestParseJSONInboundRequiresMatchingProvider(t *testing.T) {
+	body := `{"from":"a","to":"b","type":"sms","body":"x","timestamp":"2024-11-01T14:00:00Z","xillio_id":"m-1"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/messaging_provider/sms", strings.NewReader(body))
+	if _, err := parseJSONInboundSMS(r, domain.ProviderMessagingProvider); err != ErrNoProvider {
+		t.Fatalf("want ErrNoProvider, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/webhooks/xillio/sms", strings.NewReader(body))
+	in, err := parseJSONInboundSMS(r, domain.ProviderXillio)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if in.ProviderMessageID != "m-1" || in.PhoneChannel != domain.PhoneChannelSMS {
+		t.Fatalf("unexpected message %+v", in)
+	}
+}
+
+func TestParseSendgridInboundRefusesLargeAttachment(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("headers", "Message-ID: <big@mail.example.com>")
+	_ = mw.WriteField("from", "jane@example.com")
+	_ = mw.WriteField("to", "support@example.com")
+	fw, _ := mw.CreateFormFile("attachment1", "big.bin")
+	_, _ = fw.Write(make([]byte, maxInlineAttachment+1))
+	_ = mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/sendgrid/email", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	if _, err := parseSendgridInboundParse(r, domain.ProviderSendgrid); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Fatalf("err = %v, want ErrAttachmentTooLarge", err)
+	}
+}
@@ -0,0 +1,12 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rdavison/messaging-service/internal/metrics"
+)
+
+var webhookReceipts = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+	Name: "messaging_webhook_receipts_total",
+	Help: "Provider webhooks received, by provider and response status code.",
+}, []string{"provider", "code"})
@@ -4,12 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/netguard"
 	"github.com/rdavison/messaging-service/internal/repo"
 )
 
@@ -19,115 +25,661 @@ var (
 	ErrBadTimestamp = errors.New("bad timestamp")
 	ErrNotFound     = errors.New("not found")
 	ErrNoProvider   = errors.New("missing provider id key")
+	ErrBadStatus    = errors.New("bad status")
+	ErrNoFilter     = errors.New("at least one filter is required")
+	ErrBadBatch     = errors.New("bad batch")
+
+	ErrUnknownTemplate = errors.New("unknown template")
+	ErrTemplateChannel = errors.New("template channel mismatch")
+	ErrBodyAndTemplate = errors.New("body and template_id are mutually exclusive")
+
+	ErrBadSubscription = errors.New("bad subscription")
 )
 
-// getConversations returns all conversations or a single one (wrapped in a slice).
-func (h *handler) getConversations(ctx context.Context, idStr *string) ([]domain.Conversation, error) {
+// getConversations returns a page of conversations matching q, or a single one
+// (wrapped in a slice), and the cursor of the next page if there is one.
+func (h *handler) getConversations(ctx context.Context, idStr *string, q repo.ConversationQuery) ([]domain.Conversation, *string, error) {
 	if idStr == nil {
-		return h.convs.ListAll(ctx)
+		convs, next, err := h.convs.List(ctx, tenantFrom(ctx), q)
+		if err != nil {
+			return nil, nil, err
+		}
+		return convs, idCursor(next), nil
 	}
 	id, err := strconv.ParseInt(*idStr, 10, 64)
 	if err != nil {
-		return nil, ErrBadID
+		return nil, nil, ErrBadID
 	}
-	c, err := h.convs.GetByID(ctx, id)
+	c, err := h.convs.GetByID(ctx, tenantFrom(ctx), id)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, nil, ErrNotFound
 		}
-		return nil, err
+		return nil, nil, err
 	}
-	return []domain.Conversation{c}, nil
+	return []domain.Conversation{c}, nil, nil
 }
 
-// getConversationMessages returns all valid messages for a given conversation id.
-func (h *handler) getConversationMessages(ctx context.Context, convIDStr string, limit, offset int) ([]domain.Message, error) {
+// getConversationMessages returns a page of a conversation's messages, oldest
+// first, and the cursor of the next page if there is one.
+func (h *handler) getConversationMessages(ctx context.Context, convIDStr string, q repo.MessageQuery) ([]domain.Message, *string, error) {
 	id, err := strconv.ParseInt(convIDStr, 10, 64)
 	if err != nil {
-		return nil, ErrBadID
+		return nil, nil, ErrBadID
 	}
 	// rely on FK or explicit existence check
-	ok, err := h.convs.Exists(ctx, id)
+	tenantID := tenantFrom(ctx)
+	ok, err := h.convs.Exists(ctx, tenantID, id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if !ok {
-		return nil, ErrNotFound
+		return nil, nil, ErrNotFound
 	}
-	return h.msgs.GetByConversation(ctx, id, limit, offset)
+	q.ConversationID = id
+	q.Ascending = true
+	msgs, next, err := h.msgs.List(ctx, tenantID, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msgs, messageCursor(next), nil
 }
 
-// getMessages returns all messages or a single one (wrapped in a slice).
-func (h *handler) getMessages(ctx context.Context, idStr *string, limit, offset int) ([]domain.Message, error) {
+// getMessages returns a page of messages matching q, newest first, or a single
+// one (wrapped in a slice), and the cursor of the next page if there is one.
+func (h *handler) getMessages(ctx context.Context, idStr *string, q repo.MessageQuery) ([]domain.Message, *string, error) {
 	if idStr == nil {
-		return h.msgs.All(ctx, limit, offset)
+		msgs, next, err := h.msgs.List(ctx, tenantFrom(ctx), q)
+		if err != nil {
+			return nil, nil, err
+		}
+		return msgs, messageCursor(next), nil
 	}
 	id, err := strconv.ParseInt(*idStr, 10, 64)
 	if err != nil {
-		return nil, ErrBadID
+		return nil, nil, ErrBadID
 	}
-	m, err := h.msgs.GetByID(ctx, id)
+	m, err := h.msgs.GetByIDForTenant(ctx, tenantFrom(ctx), id)
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) || errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
+			return nil, nil, ErrNotFound
 		}
-		return nil, err
+		return nil, nil, err
 	}
-	return []domain.Message{m}, nil
+	return []domain.Message{m}, nil, nil
 }
 
 // createSMSOutbound receives an outbound sms message and saves it to the outbox
-func (h *handler) createSMSOutbound(ctx context.Context, req smsOutboundRequest) (int64, error) {
+func (h *handler) createSMSOutbound(ctx context.Context, req smsOutboundRequest, idem *repo.IdempotencyKey) (int64, bool, error) {
+	if id, found, err := h.replayOutbound(ctx, idem); found || err != nil {
+		return id, found, err
+	}
 	ts, err := time.Parse(time.RFC3339, req.Timestamp)
 	if err != nil {
-		return 0, ErrBadTimestamp
+		return 0, false, ErrBadTimestamp
+	}
+	status, sendAt, err := outboundSchedule(req.SendAt, time.Now())
+	if err != nil {
+		return 0, false, err
 	}
 	ch := domain.PhoneChannel(strings.ToLower(req.Type))
 	if ch != domain.PhoneChannelSMS && ch != domain.PhoneChannelMMS {
-		return 0, ErrBadType
+		return 0, false, ErrBadType
+	}
+	body, tmpl, err := h.outboundBody(ctx, domain.Channel(ch), req.Body, req.templateRef)
+	if err != nil {
+		return 0, false, err
 	}
 	source := domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: req.From}
 	target := domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: req.To}
-	convID, err := h.convs.GetOrCreateByEndpoints(ctx, source, target)
+	tenantID := tenantFrom(ctx)
+	convID, err := h.convs.GetOrCreateByEndpoints(ctx, tenantID, source, target)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	msg := domain.Message{
+		TenantID:       tenantID,
 		ConversationID: convID,
 		Source:         source,
 		Target:         target,
 		Direction:      domain.Outbound,
 		SentAt:         ts,
-		Body:           req.Body,
+		Body:           body,
 		Attachments:    toAttachments(req.Attachments),
-		Status:         domain.StatusOutbox,
+		Status:         status,
+		SendAt:         sendAt,
+		TemplateRef:    tmpl,
 	}
-	return h.msgs.Insert(ctx, msg)
+	return h.insertOutbound(ctx, msg, idem)
 }
 
 // createEmailOutbound receives an outbound email message and saves it to the outbox
-func (h *handler) createEmailOutbound(ctx context.Context, req emailOutboundRequest) (int64, error) {
+func (h *handler) createEmailOutbound(ctx context.Context, req emailOutboundRequest, idem *repo.IdempotencyKey) (int64, bool, error) {
+	if id, found, err := h.replayOutbound(ctx, idem); found || err != nil {
+		return id, found, err
+	}
 	ts, err := time.Parse(time.RFC3339, req.Timestamp)
 	if err != nil {
-		return 0, ErrBadTimestamp
+		return 0, false, ErrBadTimestamp
+	}
+	status, sendAt, err := outboundSchedule(req.SendAt, time.Now())
+	if err != nil {
+		return 0, false, err
+	}
+	body, tmpl, err := h.outboundBody(ctx, domain.ChannelEmail, req.Body, req.templateRef)
+	if err != nil {
+		return 0, false, err
 	}
 	source := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: req.From}
 	target := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: req.To}
-	convID, err := h.convs.GetOrCreateByEndpoints(ctx, source, target)
+	tenantID := tenantFrom(ctx)
+	convID, err := h.convs.GetOrCreateByEndpoints(ctx, tenantID, source, target)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	msg := domain.Message{
+		TenantID:       tenantID,
 		ConversationID: convID,
 		Source:         source,
 		Target:         target,
 		Direction:      domain.Outbound,
 		SentAt:         ts,
-		Body:           req.Body,
+		Body:           body,
 		Attachments:    toAttachments(req.Attachments),
-		Status:         domain.StatusOutbox,
+		Status:         status,
+		SendAt:         sendAt,
+		TemplateRef:    tmpl,
+	}
+	return h.insertOutbound(ctx, msg, idem)
+}
+
+// outboundSchedule decides the initial status of an outbound message from its
+// optional send_at: scheduled when it lies in the future, outbox otherwise.
+func outboundSchedule(sendAtStr string, now time.Time) (domain.Status, *time.Time, error) {
+	if sendAtStr == "" {
+		return domain.StatusOutbox, nil, nil
+	}
+	sendAt, err := time.Parse(time.RFC3339, sendAtStr)
+	if err != nil {
+		return "", nil, ErrBadTimestamp
+	}
+	if !sendAt.After(now) {
+		return domain.StatusOutbox, &sendAt, nil
+	}
+	return domain.StatusScheduled, &sendAt, nil
+}
+
+// rescheduleMessage moves a scheduled message to a new send_at and optionally
+// replaces its body. A send_at in the past makes it due right away.
+func (h *handler) rescheduleMessage(ctx context.Context, idStr string, req rescheduleRequest) (domain.Message, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Message{}, ErrBadID
+	}
+	sendAt, err := time.Parse(time.RFC3339, req.SendAt)
+	if err != nil {
+		return domain.Message{}, ErrBadTimestamp
+	}
+	m, err := h.msgs.Reschedule(ctx, tenantFrom(ctx), id, sendAt, req.Body)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Message{}, ErrNotFound
+	}
+	return m, err
+}
+
+// cancelMessage withdraws a message that has not been dispatched yet.
+func (h *handler) cancelMessage(ctx context.Context, idStr string) (domain.Message, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Message{}, ErrBadID
+	}
+	m, err := h.msgs.Cancel(ctx, tenantFrom(ctx), id, "canceled by client")
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Message{}, ErrNotFound
+	}
+	return m, err
+}
+
+// cancelMessages withdraws every undispatched message matching q. At least one
+// filter is required so a stray request cannot cancel a tenant's whole outbox.
+func (h *handler) cancelMessages(ctx context.Context, convIDStr string, q repo.MessageQuery) ([]string, error) {
+	if convIDStr != "" {
+		id, err := strconv.ParseInt(convIDStr, 10, 64)
+		if err != nil {
+			return nil, ErrBadID
+		}
+		q.ConversationID = id
+	}
+	if !q.Filtered() {
+		return nil, ErrNoFilter
+	}
+	ids, err := h.msgs.CancelMatching(ctx, tenantFrom(ctx), q, "canceled by client (bulk)")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = strconv.FormatInt(id, 10)
+	}
+	return out, nil
+}
+
+// maxTemplateBody bounds a template body, well above any single message.
+const maxTemplateBody = 64 << 10
+
+// templateBody returns the text of the template version an outbound request
+// refers to, checking that it belongs to the tenant and to channel ch.
+func (h *handler) templateBody(ctx context.Context, ch domain.Channel, idStr string, version int) (string, *domain.TemplateRef, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w %q", ErrUnknownTemplate, idStr)
+	}
+	t, err := h.tmpls.Get(ctx, tenantFrom(ctx), id, version)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return "", nil, fmt.Errorf("%w %q", ErrUnknownTemplate, idStr)
+		}
+		return "", nil, err
+	}
+	if t.Channel != ch {
+		return "", nil, fmt.Errorf("%w: template is for %s", ErrTemplateChannel, t.Channel)
+	}
+	return t.Body, &domain.TemplateRef{ID: t.ID, Version: t.Version}, nil
+}
+
+// outboundBody resolves the body of a single outbound message: the literal
+// body, or the referenced template rendered with the request's variables.
+func (h *handler) outboundBody(ctx context.Context, ch domain.Channel, body string, ref templateRef) (string, *domain.TemplateRef, error) {
+	if ref.TemplateID == "" {
+		return body, nil, nil
+	}
+	if body != "" {
+		return "", nil, ErrBodyAndTemplate
+	}
+	text, tref, err := h.templateBody(ctx, ch, ref.TemplateID, ref.TemplateVersion)
+	if err != nil {
+		return "", nil, err
+	}
+	rendered, err := domain.Render(text, ref.Variables)
+	if err != nil {
+		return "", nil, err
+	}
+	return rendered, tref, nil
+}
+
+// validateTemplateBody checks a template body before it is stored.
+func validateTemplateBody(body string) error {
+	switch {
+	case body == "":
+		return fmt.Errorf("%w: body is required", domain.ErrBadTemplate)
+	case len(body) > maxTemplateBody:
+		return fmt.Errorf("%w: body is longer than %d bytes", domain.ErrBadTemplate, maxTemplateBody)
+	}
+	_, err := domain.ParsePlaceholders(body)
+	return err
+}
+
+// createTemplate stores version 1 of a new template.
+func (h *handler) createTemplate(ctx context.Context, req templateCreateRequest) (domain.Template, error) {
+	ch, err := parseChannel(strings.ToLower(req.Channel))
+	if err != nil || ch == "" {
+		return domain.Template{}, ErrBadType
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return domain.Template{}, fmt.Errorf("%w: name is required", domain.ErrBadTemplate)
+	}
+	if err := validateTemplateBody(req.Body); err != nil {
+		return domain.Template{}, err
+	}
+	return h.tmpls.Create(ctx, tenantFrom(ctx), req.Name, ch, req.Body)
+}
+
+// updateTemplate adds a new version of a template.
+func (h *handler) updateTemplate(ctx context.Context, idStr string, req templateUpdateRequest) (domain.Template, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Template{}, ErrBadID
+	}
+	if err := validateTemplateBody(req.Body); err != nil {
+		return domain.Template{}, err
+	}
+	t, err := h.tmpls.AddVersion(ctx, tenantFrom(ctx), id, req.Body)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Template{}, ErrNotFound
+	}
+	return t, err
+}
+
+// getTemplates returns a page of templates at their latest version matching
+// q, and the cursor of the next page if there is one.
+func (h *handler) getTemplates(ctx context.Context, q repo.TemplateQuery) ([]domain.Template, *string, error) {
+	ts, next, err := h.tmpls.List(ctx, tenantFrom(ctx), q)
+	if err != nil {
+		return nil, nil, err
 	}
-	return h.msgs.Insert(ctx, msg)
+	return ts, idCursor(next), nil
+}
+
+// getTemplate returns one version of a template; the latest when versionStr
+// is empty.
+func (h *handler) getTemplate(ctx context.Context, idStr, versionStr string) (domain.Template, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Template{}, ErrBadID
+	}
+	version := 0
+	if versionStr != "" {
+		if version, err = strconv.Atoi(versionStr); err != nil || version < 1 {
+			return domain.Template{}, fmt.Errorf("%w: bad version %q", ErrBadFilter, versionStr)
+		}
+	}
+	t, err := h.tmpls.Get(ctx, tenantFrom(ctx), id, version)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Template{}, ErrNotFound
+	}
+	return t, err
+}
+
+// getTemplateVersions returns every version of a template, oldest first.
+func (h *handler) getTemplateVersions(ctx context.Context, idStr string) ([]domain.Template, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, ErrBadID
+	}
+	ts, err := h.tmpls.Versions(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return ts, err
+}
+
+// deleteTemplate removes a template and all its versions.
+func (h *handler) deleteTemplate(ctx context.Context, idStr string) error {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return ErrBadID
+	}
+	err = h.tmpls.Delete(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// maxBatchRecipients bounds a single batch request.
+const maxBatchRecipients = 10000
+
+var phoneNumberRe = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
+// createBatch validates every recipient of a batch send, renders the body with
+// its variables and queues all valid ones in one transaction. Invalid
+// recipients are reported per item; only a malformed request as a whole is an
+// error.
+func (h *handler) createBatch(ctx context.Context, req batchRequest) (batchResponse, error) {
+	ts := time.Now().UTC()
+	if req.Timestamp != "" {
+		var err error
+		if ts, err = time.Parse(time.RFC3339, req.Timestamp); err != nil {
+			return batchResponse{}, ErrBadTimestamp
+		}
+	}
+	status, sendAt, err := outboundSchedule(req.SendAt, time.Now())
+	if err != nil {
+		return batchResponse{}, err
+	}
+	switch {
+	case req.From == "":
+		return batchResponse{}, fmt.Errorf("%w: from is required", ErrBadBatch)
+	case len(req.Recipients) == 0:
+		return batchResponse{}, fmt.Errorf("%w: recipients is empty", ErrBadBatch)
+	case len(req.Recipients) > maxBatchRecipients:
+		return batchResponse{}, fmt.Errorf("%w: more than %d recipients", ErrBadBatch, maxBatchRecipients)
+	}
+
+	var source domain.Endpoint
+	var kind domain.EndpointKind
+	ch := domain.Channel(strings.ToLower(req.Type))
+	switch ch {
+	case domain.ChannelSMS, domain.ChannelMMS:
+		pc := domain.PhoneChannel(ch)
+		kind = domain.EndpointKindPhone
+		source = domain.Endpoint{Kind: kind, Channel: &pc, Payload: req.From}
+	case domain.ChannelEmail:
+		kind = domain.EndpointKindEmail
+		source = domain.Endpoint{Kind: kind, Payload: req.From}
+	default:
+		return batchResponse{}, ErrBadType
+	}
+
+	text := req.Body
+	var tmpl *domain.TemplateRef
+	if req.TemplateID != "" {
+		if req.Body != "" {
+			return batchResponse{}, ErrBodyAndTemplate
+		}
+		if text, tmpl, err = h.templateBody(ctx, ch, req.TemplateID, req.TemplateVersion); err != nil {
+			return batchResponse{}, err
+		}
+	}
+
+	resp := batchResponse{Items: make([]batchItemResult, len(req.Recipients))}
+	var items []repo.BatchItem
+	var accepted []int // index into resp.Items of each entry of items
+	for i, rc := range req.Recipients {
+		resp.Items[i].To = rc.To
+		if err := validateRecipient(kind, rc.To); err != nil {
+			resp.Items[i].Error = err.Error()
+			continue
+		}
+		body, err := domain.Render(text, rc.Variables)
+		if err != nil {
+			resp.Items[i].Error = err.Error()
+			continue
+		}
+		items = append(items, repo.BatchItem{To: rc.To, Body: body})
+		accepted = append(accepted, i)
+	}
+
+	proto := domain.Message{
+		TenantID:    tenantFrom(ctx),
+		Source:      source,
+		Direction:   domain.Outbound,
+		SentAt:      ts,
+		Attachments: toAttachments(req.Attachments),
+		Status:      status,
+		SendAt:      sendAt,
+		TemplateRef: tmpl,
+	}
+	batchID, ids, err := h.batches.Create(ctx, proto, items, len(req.Recipients))
+	if err != nil {
+		return batchResponse{}, err
+	}
+	for n, i := range accepted {
+		resp.Items[i].ID = strconv.FormatInt(ids[n], 10)
+	}
+	resp.BatchID = strconv.FormatInt(batchID, 10)
+	resp.Accepted = len(items)
+	resp.Rejected = len(req.Recipients) - len(items)
+	return resp, nil
+}
+
+// validateRecipient checks that to is a plausible address for kind.
+func validateRecipient(kind domain.EndpointKind, to string) error {
+	if to == "" {
+		return errors.New("to is required")
+	}
+	if kind == domain.EndpointKindEmail {
+		if a, err := mail.ParseAddress(to); err != nil || a.Address != to {
+			return fmt.Errorf("invalid email address %q", to)
+		}
+		return nil
+	}
+	if !phoneNumberRe.MatchString(to) {
+		return fmt.Errorf("invalid phone number %q", to)
+	}
+	return nil
+}
+
+// getMessageEvents returns every status change and delivery attempt of a
+// message, oldest first.
+func (h *handler) getMessageEvents(ctx context.Context, idStr string) ([]domain.MessageEvent, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, ErrBadID
+	}
+	events, err := h.msgs.Events(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return events, err
+}
+
+// minSubscriptionSecret is the shortest signing secret a client may choose.
+const minSubscriptionSecret = 16
+
+// createSubscription registers a webhook endpoint for the tenant.
+func (h *handler) createSubscription(ctx context.Context, req subscriptionCreateRequest) (domain.Subscription, error) {
+	if err := validateSubscriptionURL(req.URL); err != nil {
+		return domain.Subscription{}, err
+	}
+	events, err := parseEventTypes(req.Events)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if req.Secret != "" && len(req.Secret) < minSubscriptionSecret {
+		return domain.Subscription{}, fmt.Errorf("%w: secret must be at least %d characters", ErrBadSubscription, minSubscriptionSecret)
+	}
+	return h.subs.Create(ctx, tenantFrom(ctx), req.URL, events, req.Secret)
+}
+
+func (h *handler) getSubscription(ctx context.Context, idStr string) (domain.Subscription, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Subscription{}, ErrBadID
+	}
+	s, err := h.subs.Get(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return s, err
+}
+
+// updateSubscription changes the URL or events of a subscription, or
+// disables or re-enables it.
+func (h *handler) updateSubscription(ctx context.Context, idStr string, req subscriptionUpdateRequest) (domain.Subscription, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Subscription{}, ErrBadID
+	}
+	u := repo.SubscriptionUpdate{URL: req.URL, Enabled: req.Enabled}
+	if req.URL != nil {
+		if err := validateSubscriptionURL(*req.URL); err != nil {
+			return domain.Subscription{}, err
+		}
+	}
+	if req.Events != nil {
+		if u.Events, err = parseEventTypes(req.Events); err != nil {
+			return domain.Subscription{}, err
+		}
+	}
+	s, err := h.subs.Update(ctx, tenantFrom(ctx), id, u)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return s, err
+}
+
+// deleteSubscription removes a subscription; its pending deliveries are
+// dropped.
+func (h *handler) deleteSubscription(ctx context.Context, idStr string) error {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return ErrBadID
+	}
+	err = h.subs.Delete(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (h *handler) getSubscriptionDeliveries(ctx context.Context, idStr string, limit int) ([]domain.Delivery, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, ErrBadID
+	}
+	ds, err := h.subs.Deliveries(ctx, tenantFrom(ctx), id, limit)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return ds, err
+}
+
+// validateSubscriptionURL accepts absolute http and https URLs, except to
+// local names and non-public IP addresses. Names are checked again when
+// deliveries connect, against the addresses they resolve to.
+func validateSubscriptionURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrBadSubscription)
+	}
+	if err := netguard.CheckHost(u.Hostname()); err != nil {
+		return fmt.Errorf("%w: url: %v", ErrBadSubscription, err)
+	}
+	return nil
+}
+
+// parseEventTypes requires at least one known event type and drops
+// duplicates.
+func parseEventTypes(names []string) ([]domain.EventType, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: events is required", ErrBadSubscription)
+	}
+	out := make([]domain.EventType, 0, len(names))
+	for _, n := range names {
+		t, ok := domain.ParseEventType(n)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrBadSubscription, n)
+		}
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// getBatch returns a batch with the progress of its messages.
+func (h *handler) getBatch(ctx context.Context, idStr string) (domain.Batch, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return domain.Batch{}, ErrBadID
+	}
+	b, err := h.batches.Get(ctx, tenantFrom(ctx), id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return domain.Batch{}, ErrNotFound
+	}
+	return b, err
+}
+
+// replayOutbound looks up an Idempotency-Key before the message is built, so
+// a retry gets the original message even if its template has since changed
+// or been deleted, or its send_at has passed. It reports found unset when
+// there is no key or the key is new.
+func (h *handler) replayOutbound(ctx context.Context, idem *repo.IdempotencyKey) (int64, bool, error) {
+	if idem == nil {
+		return 0, false, nil
+	}
+	return h.msgs.LookupIdempotent(ctx, tenantFrom(ctx), *idem, h.idempotencyRetention)
+}
+
+// insertOutbound saves msg to the outbox, once per idempotency key when the
+// client sent one.
+func (h *handler) insertOutbound(ctx context.Context, msg domain.Message, idem *repo.IdempotencyKey) (int64, bool, error) {
+	if idem == nil {
+		id, err := h.msgs.Insert(ctx, msg)
+		return id, false, err
+	}
+	return h.msgs.InsertIdempotent(ctx, msg, *idem, h.idempotencyRetention)
 }
 
 // createSMSInbound receives an inbound sms message from a provider and saves it
@@ -149,24 +701,17 @@ func (h *handler) createSMSInbound(ctx context.Context, raw map[string]any) (int
 	if ch != domain.PhoneChannelSMS && ch != domain.PhoneChannelMMS {
 		return 0, ErrBadType
 	}
-	source := domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: req.From}
-	target := domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: req.To}
-	convID, err := h.convs.GetOrCreateByEndpoints(ctx, source, target)
-	if err != nil {
-		return 0, err
-	}
-	msg := domain.Message{
-		ConversationID: convID,
-		Source:         source,
-		Target:         target,
-		Provider:       provRef(provider.String(), providerMsgID),
-		Direction:      domain.Inbound,
-		SentAt:         ts,
-		Body:           req.Body,
-		Attachments:    toAttachments(req.Attachments),
-		Status:         domain.StatusOK,
-	}
-	return h.msgs.InsertOrUpdateByProviderPair(ctx, msg)
+	return h.createInbound(ctx, inboundMessage{
+		Provider:          provider,
+		ProviderMessageID: providerMsgID,
+		Kind:              domain.EndpointKindPhone,
+		PhoneChannel:      ch,
+		From:              req.From,
+		To:                req.To,
+		Body:              req.Body,
+		Attachments:       req.Attachments,
+		Timestamp:         ts,
+	})
 }
 
 // createEmailInbound receives an inbound email message from a provider and saves it
@@ -184,26 +729,80 @@ func (h *handler) createEmailInbound(ctx context.Context, raw map[string]any) (i
 	if err != nil {
 		return 0, ErrBadTimestamp
 	}
-	source := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: req.From}
-	target := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: req.To}
-	convID, err := h.convs.GetOrCreateByEndpoints(ctx, source, target)
+	return h.createInbound(ctx, inboundMessage{
+		Provider:          provider,
+		ProviderMessageID: providerMsgID,
+		Kind:              domain.EndpointKindEmail,
+		From:              req.From,
+		To:                req.To,
+		Body:              req.Body,
+		Attachments:       req.Attachments,
+		Timestamp:         ts,
+	})
+}
+
+// createInbound saves an inbound message decoded from any provider format.
+// Redelivery of the same provider message updates the existing row.
+func (h *handler) createInbound(ctx context.Context, in inboundMessage) (int64, error) {
+	var source, target domain.Endpoint
+	if in.Kind == domain.EndpointKindPhone {
+		ch := in.PhoneChannel
+		source = domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: in.From}
+		target = domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: in.To}
+	} else {
+		source = domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: in.From}
+		target = domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: in.To}
+	}
+	// providers do not know about tenants: an inbound message belongs to the
+	// tenant that last talked to these endpoints
+	tenantID, err := h.convs.TenantForEndpoints(ctx, source, target)
+	if err != nil {
+		return 0, err
+	}
+	convID, err := h.convs.GetOrCreateByEndpoints(ctx, tenantID, source, target)
 	if err != nil {
 		return 0, err
 	}
 	msg := domain.Message{
+		TenantID:       tenantID,
 		ConversationID: convID,
 		Source:         source,
 		Target:         target,
-		Provider:       provRef(provider.String(), providerMsgID),
+		Provider:       provRef(in.Provider.String(), in.ProviderMessageID),
 		Direction:      domain.Inbound,
-		SentAt:         ts,
-		Body:           req.Body,
-		Attachments:    toAttachments(req.Attachments),
+		SentAt:         in.Timestamp,
+		Body:           in.Body,
+		Attachments:    toAttachments(in.Attachments),
 		Status:         domain.StatusOK,
 	}
 	return h.msgs.InsertOrUpdateByProviderPair(ctx, msg)
 }
 
+// applyStatusCallbacks advances outbound messages according to delivery
+// callbacks from a provider and tallies the outcome.
+func (h *handler) applyStatusCallbacks(ctx context.Context, p domain.Provider, cbs []statusCallback) (statusCallbackResponse, error) {
+	out := statusCallbackResponse{Updated: make([]string, 0)}
+	for _, cb := range cbs {
+		if cb.Status == "" {
+			out.Ignored++
+			continue
+		}
+		payload := cb.Payload
+		id, err := h.msgs.UpdateDeliveryStatus(ctx, p.String(), cb.ProviderMessageID, cb.Status, &payload)
+		switch {
+		case err == nil:
+			out.Updated = append(out.Updated, strconv.FormatInt(id, 10))
+		case errors.Is(err, repo.ErrStaleStatus):
+			out.Stale++
+		case errors.Is(err, repo.ErrNotFound):
+			out.Unknown++
+		default:
+			return out, err
+		}
+	}
+	return out, nil
+}
+
 // toAttachments converts []string into []domain.Attachment (alias string).
 func toAttachments(in []string) []domain.Attachment {
 	if len(in) == 0 {
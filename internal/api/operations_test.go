@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestOutboundSchedule(t *testing.T) {
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sendAt string
+		status domain.Status
+		hasAt  bool
+	}{
+		{"", domain.StatusOutbox, false},
+		{"2024-11-01T15:00:00Z", domain.StatusScheduled, true},
+		{"2024-11-01T14:00:00Z", domain.StatusOutbox, true},
+		{"2024-10-01T14:00:00Z", domain.StatusOutbox, true},
+	}
+	for _, tt := range tests {
+		status, at, err := outboundSchedule(tt.sendAt, now)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.sendAt, err)
+		}
+		if status != tt.status || (at != nil) != tt.hasAt {
+			t.Errorf("%q: got %s, %v", tt.sendAt, status, at)
+		}
+	}
+	if _, _, err := outboundSchedule("tomorrow", now); !errors.Is(err, ErrBadTimestamp) {
+		t.Fatalf("want ErrBadTimestamp, got %v", err)
+	}
+}
+
+func TestValidateRecipient(t *testing.T) {
+	tests := []struct {
+		kind domain.EndpointKind
+		to   string
+		ok   bool
+	}{
+		{domain.EndpointKindPhone, "+12016661234", true},
+		{domain.EndpointKindPhone, "12016661234", true},
+		{domain.EndpointKindPhone, "+1 201 666 1234", false},
+		{domain.EndpointKindPhone, "bob@example.com", false},
+		{domain.EndpointKindPhone, "", false},
+		{domain.EndpointKindEmail, "bob@example.com", true},
+		{domain.EndpointKindEmail, "Bob <bob@example.com>", false},
+		{domain.EndpointKindEmail, "+12016661234", false},
+	}
+	for _, tt := range tests {
+		if err := validateRecipient(tt.kind, tt.to); (err == nil) != tt.ok {
+			t.Errorf("%s %q: got %v, want ok=%v", tt.kind, tt.to, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateTemplateBody(t *testing.T) {
+	if err := validateTemplateBody("Hi {{name}}, see you at {{ time }}"); err != nil {
+		t.Fatalf("valid body: %v", err)
+	}
+	for _, body := range []string{"", "Hi {{name", "Hi {{first name}}"} {
+		if err := validateTemplateBody(body); !errors.Is(err, domain.ErrBadTemplate) {
+			t.Errorf("%q: want ErrBadTemplate, got %v", body, err)
+		}
+	}
+}
+
+func TestOutboundBodyWithoutTemplate(t *testing.T) {
+	h := &handler{}
+	body, ref, err := h.outboundBody(context.Background(), domain.ChannelSMS, "plain", templateRef{})
+	if err != nil || body != "plain" || ref != nil {
+		t.Fatalf("got %q, %v, %v", body, ref, err)
+	}
+	_, _, err = h.outboundBody(context.Background(), domain.ChannelSMS, "plain", templateRef{TemplateID: "1"})
+	if !errors.Is(err, ErrBodyAndTemplate) {
+		t.Fatalf("want ErrBodyAndTemplate, got %v", err)
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	got, err := parseEventTypes([]string{"message.sent", "message.failed", "message.sent"})
+	if err != nil || len(got) != 2 || got[0] != domain.EventMessageSent || got[1] != domain.EventMessageFailed {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	for _, names := range [][]string{nil, {}, {"message.deleted"}} {
+		if _, err := parseEventTypes(names); !errors.Is(err, ErrBadSubscription) {
+			t.Errorf("%v: want ErrBadSubscription, got %v", names, err)
+		}
+	}
+}
+
+func TestValidateSubscriptionURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/hooks", true},
+		{"http://hooks.example.com:8080/hooks?x=1", true},
+		{"http://localhost:8080/hooks", false},
+		{"http://10.0.0.5/hooks", false},
+		{"https://[::1]/hooks", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"ftp://example.com/hooks", false},
+		{"/hooks", false},
+		{"https://", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := validateSubscriptionURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("%q: got %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
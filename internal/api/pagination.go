@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+const (
+	defaultPageLimit = 200
+	maxPageLimit     = 1000
+)
+
+var (
+	ErrBadCursor = errors.New("bad cursor")
+	ErrBadLimit  = errors.New("bad limit")
+	ErrBadFilter = errors.New("bad filter")
+)
+
+// pageCursor is the decoded form of the opaque next_cursor handed to clients:
+// the (sent_at, id) of the last message of a page, or just the id for
+// conversations and templates.
+type pageCursor struct {
+	SentAt *time.Time `json:"t,omitempty"`
+	ID     int64      `json:"i"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
+
+// messageCursor converts a repo cursor into the string for next_cursor.
+func messageCursor(c *repo.MessageCursor) *string {
+	if c == nil {
+		return nil
+	}
+	s := encodeCursor(pageCursor{SentAt: &c.SentAt, ID: c.ID})
+	return &s
+}
+
+// idCursor is the next_cursor of listings paged by id alone.
+func idCursor(afterID int64) *string {
+	if afterID == 0 {
+		return nil
+	}
+	s := encodeCursor(pageCursor{ID: afterID})
+	return &s
+}
+
+func parseLimit(v url.Values) (int, error) {
+	s := v.Get("limit")
+	if s == "" {
+		return defaultPageLimit, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxPageLimit {
+		return 0, fmt.Errorf("%w: must be between 1 and %d", ErrBadLimit, maxPageLimit)
+	}
+	return n, nil
+}
+
+// parseMessageQuery reads limit, cursor and the filters of a message listing:
+// status (comma-separated), direction, endpoint_kind, channel, provider_id,
+// batch_id, from, to, sent_after and sent_before (RFC 3339).
+func parseMessageQuery(v url.Values) (repo.MessageQuery, error) {
+	var q repo.MessageQuery
+	var err error
+	if q.Limit, err = parseLimit(v); err != nil {
+		return q, err
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.SentAt == nil {
+			return q, ErrBadCursor
+		}
+		q.After = &repo.MessageCursor{SentAt: *c.SentAt, ID: c.ID}
+	}
+	if s := v.Get("status"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			st, ok := domain.ParseStatus(strings.TrimSpace(part))
+			if !ok {
+				return q, fmt.Errorf("%w: unknown status %q", ErrBadFilter, part)
+			}
+			q.Statuses = append(q.Statuses, st)
+		}
+	}
+	switch d := domain.InboundOrOutbound(v.Get("direction")); d {
+	case "", domain.Inbound, domain.Outbound:
+		q.Direction = d
+	default:
+		return q, fmt.Errorf("%w: unknown direction %q", ErrBadFilter, d)
+	}
+	if q.EndpointKind, err = parseEndpointKind(v.Get("endpoint_kind")); err != nil {
+		return q, err
+	}
+	if q.Channel, err = parseChannel(v.Get("channel")); err != nil {
+		return q, err
+	}
+	if s := v.Get("provider_id"); s != "" {
+		if _, ok := domain.ParseProvider(s); !ok {
+			return q, fmt.Errorf("%w: unknown provider_id %q", ErrBadFilter, s)
+		}
+		q.ProviderID = s
+	}
+	if s := v.Get("batch_id"); s != "" {
+		if q.BatchID, err = strconv.ParseInt(s, 10, 64); err != nil || q.BatchID <= 0 {
+			return q, fmt.Errorf("%w: bad batch_id %q", ErrBadFilter, s)
+		}
+	}
+	q.From = v.Get("from")
+	q.To = v.Get("to")
+	if q.SentFrom, err = parseTimeParam(v, "sent_after"); err != nil {
+		return q, err
+	}
+	if q.SentUntil, err = parseTimeParam(v, "sent_before"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// parseConversationQuery reads limit, cursor and the endpoint_kind, channel
+// and endpoint filters of a conversation listing.
+func parseConversationQuery(v url.Values) (repo.ConversationQuery, error) {
+	var q repo.ConversationQuery
+	var err error
+	if q.Limit, err = parseLimit(v); err != nil {
+		return q, err
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.AfterID = c.ID
+	}
+	if q.EndpointKind, err = parseEndpointKind(v.Get("endpoint_kind")); err != nil {
+		return q, err
+	}
+	if q.Channel, err = parseChannel(v.Get("channel")); err != nil {
+		return q, err
+	}
+	q.Endpoint = v.Get("endpoint")
+	return q, nil
+}
+
+// parseTemplateQuery reads limit, cursor and the name and channel filters of a
+// template listing.
+func parseTemplateQuery(v url.Values) (repo.TemplateQuery, error) {
+	var q repo.TemplateQuery
+	var err error
+	if q.Limit, err = parseLimit(v); err != nil {
+		return q, err
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.AfterID = c.ID
+	}
+	if q.Channel, err = parseChannel(v.Get("channel")); err != nil {
+		return q, err
+	}
+	q.Name = v.Get("name")
+	return q, nil
+}
+
+func parseEndpointKind(s string) (domain.EndpointKind, error) {
+	switch k := domain.EndpointKind(s); k {
+	case "", domain.EndpointKindPhone, domain.EndpointKindEmail:
+		return k, nil
+	default:
+		return "", fmt.Errorf("%w: unknown endpoint_kind %q", ErrBadFilter, s)
+	}
+}
+
+func parseChannel(s string) (domain.Channel, error) {
+	switch c := domain.Channel(s); c {
+	case "", domain.ChannelSMS, domain.ChannelMMS, domain.ChannelEmail:
+		return c, nil
+	default:
+		return "", fmt.Errorf("%w: unknown channel %q", ErrBadFilter, s)
+	}
+}
+
+func parseTimeParam(v url.Values, key string) (time.Time, error) {
+	s := v.Get(key)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be RFC 3339", ErrBadFilter, key)
+	}
+	return t, nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	at := time.Date(2024, 11, 1, 14, 0, 0, 123456000, time.UTC)
+	s := messageCursor(&repo.MessageCursor{SentAt: at, ID: 42})
+	if s == nil {
+		t.Fatal("nil cursor")
+	}
+	q, err := parseMessageQuery(url.Values{"cursor": {*s}})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if q.After == nil || !q.After.SentAt.Equal(at) || q.After.ID != 42 {
+		t.Fatalf("got %+v", q.After)
+	}
+	if messageCursor(nil) != nil {
+		t.Fatal("want nil for last page")
+	}
+}
+
+func TestParseMessageQuery(t *testing.T) {
+	q, err := parseMessageQuery(url.Values{
+		"limit":       {"10"},
+		"status":      {"ok,delivered"},
+		"direction":   {"outbound"},
+		"channel":     {"mms"},
+		"provider_id": {"twilio"},
+		"from":        {"+12016661234"},
+		"sent_after":  {"2024-11-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if q.Limit != 10 || len(q.Statuses) != 2 || q.Statuses[1] != domain.StatusDelivered ||
+		q.Direction != domain.Outbound || q.Channel != domain.ChannelMMS ||
+		q.ProviderID != "twilio" || q.From != "+12016661234" || q.SentFrom.IsZero() || !q.SentUntil.IsZero() {
+		t.Fatalf("got %+v", q)
+	}
+
+	if q, _ := parseMessageQuery(url.Values{}); q.Limit != defaultPageLimit {
+		t.Fatalf("default limit = %d", q.Limit)
+	}
+
+	bad := []struct {
+		v    url.Values
+		want error
+	}{
+		{url.Values{"limit": {"0"}}, ErrBadLimit},
+		{url.Values{"limit": {"1001"}}, ErrBadLimit},
+		{url.Values{"cursor": {"!!"}}, ErrBadCursor},
+		{url.Values{"cursor": {encodeCursor(pageCursor{ID: 3})}}, ErrBadCursor}, // a conversation cursor
+		{url.Values{"status": {"ok,nope"}}, ErrBadFilter},
+		{url.Values{"direction": {"sideways"}}, ErrBadFilter},
+		{url.Values{"channel": {"fax"}}, ErrBadFilter},
+		{url.Values{"provider_id": {"acme"}}, ErrBadFilter},
+		{url.Values{"sent_before": {"yesterday"}}, ErrBadFilter},
+	}
+	for _, tt := range bad {
+		if _, err := parseMessageQuery(tt.v); !errors.Is(err, tt.want) {
+			t.Errorf("%v: want %v, got %v", tt.v, tt.want, err)
+		}
+	}
+}
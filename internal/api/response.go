@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 )
 
@@ -28,7 +29,21 @@ func respondBadRequest(w http.ResponseWriter, msg ...any) {
 	})
 }
 
-func respondInternalServerError(w http.ResponseWriter, msg string) {
+func respondUnauthorized(w http.ResponseWriter, msg string) {
+	respondJSON(w, http.StatusUnauthorized, errorResponse{Error: msg})
+}
+
+func respondConflict(w http.ResponseWriter, msg string) {
+	respondJSON(w, http.StatusConflict, errorResponse{Error: msg})
+}
+
+func respondUnprocessableEntity(w http.ResponseWriter, msg string) {
+	respondJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: msg})
+}
+
+// respondInternalServerError logs err, which the client does not get to see.
+func respondInternalServerError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	slog.ErrorContext(r.Context(), msg, "error", err)
 	http.Error(w, msg, http.StatusInternalServerError)
 }
 
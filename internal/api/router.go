@@ -1,50 +1,126 @@
 package api
 
 import (
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/health"
+	"github.com/rdavison/messaging-service/internal/logging"
+	"github.com/rdavison/messaging-service/internal/metrics"
 	"github.com/rdavison/messaging-service/internal/repo"
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
-func NewRouter(pool *pgxpool.Pool) http.Handler {
+// requestTimeout bounds every request but conversation streams and batch
+// sends.
+const requestTimeout = 30 * time.Second
+
+// batchTimeout bounds a batch send, which inserts up to maxBatchRecipients
+// messages.
+const batchTimeout = 60 * time.Second
+
+func NewRouter(pool *pgxpool.Pool, opts Options) http.Handler {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	h := &handler{
-		convs: repo.NewConversationRepo(pool),
-		msgs:  repo.NewMessageRepo(pool),
+		convs:    repo.NewConversationRepo(pool),
+		msgs:     repo.NewMessageRepo(pool),
+		batches:  repo.NewBatchRepo(pool),
+		tmpls:    repo.NewTemplateRepo(pool),
+		subs:     repo.NewSubscriptionRepo(pool),
+		keys:     repo.NewAPIKeyRepo(pool),
+		webhooks: opts.Webhooks,
+		streams:  newStreamHub(repo.NewMessageRepo(pool), logger),
+
+		idempotencyRetention: opts.IdempotencyRetention,
+		webSocketOrigins:     opts.WebSocketOrigins,
+		shuttingDown:         opts.ShuttingDown,
 	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(logging.Middleware(logger))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(30 * time.Second))
-
-	r.Route("/api", func(r chi.Router) {
-		r.Route("/messages", func(r chi.Router) {
-			r.Get("/", h.handleMessagesIndex)
-			r.Get("/{id}", h.handleMessageByID)
-			r.Post("/sms", h.handleMessagesSMSOutbound)
-			r.Post("/email", h.handleMessagesEmailOutbound)
-		})
+	r.Use(metrics.Middleware)
+	timeout := middleware.Timeout(requestTimeout)
 
-		r.Route("/webhooks", func(r chi.Router) {
-			r.Post("/sms", h.handleWebhooksSMSInbound)
-			r.Post("/email", h.handleWebhooksEmailInbound)
+	// probes and metrics are left out of traces
+	r.With(tracing.Middleware).Route("/api", func(r chi.Router) {
+
+		r.With(timeout).Route("/webhooks", func(r chi.Router) {
+			r.With(h.verifyWebhook).Post("/sms", h.handleWebhooksSMSInbound)
+			r.With(h.verifyWebhook).Post("/email", h.handleWebhooksEmailInbound)
+			r.With(h.verifyWebhook).Post("/status/{provider}", h.handleWebhooksStatusChi)
+			r.With(h.verifyWebhook).Post("/{provider}/sms", h.handleWebhooksProviderSMSChi)
+			r.With(h.verifyWebhook).Post("/{provider}/email", h.handleWebhooksProviderEmailChi)
 		})
 
-		r.Route("/conversations", func(r chi.Router) {
-			r.Get("/", h.handleConversationsIndex)
-			r.Get("/{id}", h.handleConversationByID)
-			r.Get("/{id}/messages", h.handleConversationMessagesChi)
+		// client-facing routes need an API key and only see the key's tenant
+		r.Group(func(r chi.Router) {
+			r.Use(h.authenticate)
+
+			r.With(timeout).Route("/messages", func(r chi.Router) {
+				r.Get("/", h.handleMessagesIndex)
+				r.Get("/{id}", h.handleMessageByID)
+				r.Get("/{id}/events", h.handleMessageEventsChi)
+				r.Patch("/{id}", h.handleMessagePatchChi)
+				r.Delete("/{id}", h.handleMessageCancelChi)
+				r.Post("/{id}/cancel", h.handleMessageCancelChi)
+				r.Post("/cancel", h.handleMessagesBulkCancel)
+				r.Post("/sms", h.handleMessagesSMSOutbound)
+				r.Post("/email", h.handleMessagesEmailOutbound)
+			})
+
+			// outside the /messages group so requestTimeout does not cut it short
+			r.With(middleware.Timeout(batchTimeout)).Post("/messages/batch", h.handleMessagesBatch)
+
+			r.With(timeout).Get("/batches/{id}", h.handleBatchByIDChi)
+
+			r.With(timeout).Route("/templates", func(r chi.Router) {
+				r.Get("/", h.handleTemplatesIndex)
+				r.Post("/", h.handleTemplatesCreate)
+				r.Get("/{id}", h.handleTemplateByIDChi)
+				r.Put("/{id}", h.handleTemplateUpdateChi)
+				r.Delete("/{id}", h.handleTemplateDeleteChi)
+				r.Get("/{id}/versions", h.handleTemplateVersionsChi)
+			})
+
+			r.With(timeout).Route("/subscriptions", func(r chi.Router) {
+				r.Get("/", h.handleSubscriptionsIndex)
+				r.Post("/", h.handleSubscriptionsCreate)
+				r.Get("/{id}", h.handleSubscriptionByIDChi)
+				r.Patch("/{id}", h.handleSubscriptionUpdateChi)
+				r.Delete("/{id}", h.handleSubscriptionDeleteChi)
+				r.Get("/{id}/deliveries", h.handleSubscriptionDeliveriesChi)
+			})
+
+			r.Route("/conversations", func(r chi.Router) {
+				r.With(timeout).Get("/", h.handleConversationsIndex)
+				r.With(timeout).Get("/{id}", h.handleConversationByID)
+				r.With(timeout).Get("/{id}/messages", h.handleConversationMessagesChi)
+				r.Get("/{id}/stream", h.handleConversationStreamChi)
+				r.Get("/{id}/ws", h.handleConversationWebSocketChi)
+			})
 		})
 	})
 
-	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		// the API has no state of its own to go bad; it is live while it answers
+		r.Get("/livez", health.Handler())
+		r.Get("/readyz", health.Handler(health.Database(pool), health.Schema(pool)))
+		r.Method(http.MethodGet, "/metrics", metrics.Handler())
 	})
 
 	return r
@@ -59,6 +135,54 @@ func (h *handler) handleMessageByID(w http.ResponseWriter, r *http.Request) {
 	h.handleMessagesRoot(w, r, &id)
 }
 
+func (h *handler) handleMessageEventsChi(w http.ResponseWriter, r *http.Request) {
+	h.handleMessageEvents(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleMessagePatchChi(w http.ResponseWriter, r *http.Request) {
+	h.handleMessagePatch(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleMessageCancelChi(w http.ResponseWriter, r *http.Request) {
+	h.handleMessageCancel(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleBatchByIDChi(w http.ResponseWriter, r *http.Request) {
+	h.handleBatchByID(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleTemplateByIDChi(w http.ResponseWriter, r *http.Request) {
+	h.handleTemplateByID(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleTemplateUpdateChi(w http.ResponseWriter, r *http.Request) {
+	h.handleTemplateUpdate(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleTemplateDeleteChi(w http.ResponseWriter, r *http.Request) {
+	h.handleTemplateDelete(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleTemplateVersionsChi(w http.ResponseWriter, r *http.Request) {
+	h.handleTemplateVersions(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleSubscriptionByIDChi(w http.ResponseWriter, r *http.Request) {
+	h.handleSubscriptionByID(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleSubscriptionUpdateChi(w http.ResponseWriter, r *http.Request) {
+	h.handleSubscriptionUpdate(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleSubscriptionDeleteChi(w http.ResponseWriter, r *http.Request) {
+	h.handleSubscriptionDelete(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleSubscriptionDeliveriesChi(w http.ResponseWriter, r *http.Request) {
+	h.handleSubscriptionDeliveries(w, r, chi.URLParam(r, "id"))
+}
+
 func (h *handler) handleConversationsIndex(w http.ResponseWriter, r *http.Request) {
 	h.handleConversations(w, r, nil)
 }
@@ -72,3 +196,26 @@ func (h *handler) handleConversationMessagesChi(w http.ResponseWriter, r *http.R
 	id := chi.URLParam(r, "id")
 	h.handleConversationMessages(w, r, id)
 }
+
+func (h *handler) handleConversationStreamChi(w http.ResponseWriter, r *http.Request) {
+	h.handleConversationStream(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleConversationWebSocketChi(w http.ResponseWriter, r *http.Request) {
+	h.handleConversationWebSocket(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *handler) handleWebhooksStatusChi(w http.ResponseWriter, r *http.Request) {
+	p := chi.URLParam(r, "provider")
+	h.handleWebhooksStatus(w, r, p)
+}
+
+func (h *handler) handleWebhooksProviderSMSChi(w http.ResponseWriter, r *http.Request) {
+	p := chi.URLParam(r, "provider")
+	h.handleWebhooksProviderInbound(w, r, p, "sms")
+}
+
+func (h *handler) handleWebhooksProviderEmailChi(w http.ResponseWriter, r *http.Request) {
+	p := chi.URLParam(r, "provider")
+	h.handleWebhooksProviderInbound(w, r, p, "email")
+}
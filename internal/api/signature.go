@@ -0,0 +1,305 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/logging"
+)
+
+var (
+	ErrBadSignature   = errors.New("bad signature")
+	ErrStaleTimestamp = errors.New("timestamp outside tolerance")
+	ErrNoSecret       = errors.New("no webhook secret configured")
+)
+
+// Headers carrying signatures. Twilio and SendGrid define their own; the
+// generic HMAC scheme is ours.
+const (
+	headerTwilioSignature    = "X-Twilio-Signature"
+	headerSendgridSignature  = "X-Twilio-Email-Event-Webhook-Signature"
+	headerSendgridTimestamp  = "X-Twilio-Email-Event-Webhook-Timestamp"
+	headerWebhookSignature   = "X-Webhook-Signature"
+	headerWebhookTimestamp   = "X-Webhook-Timestamp"
+	maxWebhookBody           = 32 << 20
+	defaultWebhookTolerance  = 5 * time.Minute
+	twilioBodySHA256QueryKey = "bodySHA256"
+)
+
+// WebhookSecrets configures how inbound provider callbacks are authenticated.
+type WebhookSecrets struct {
+	TwilioAuthToken   string                     // X-Twilio-Signature HMAC-SHA1 key
+	TwilioAuthTokens  map[string]string          // keys of further Twilio accounts, by AccountSid
+	SendgridPublicKey string                     // base64 DER (PKIX) ECDSA key from the Event Webhook settings
+	SendgridBasicAuth string                     // "user:pass" embedded in the Inbound Parse URL, which SendGrid does not sign
+	HMAC              map[domain.Provider]string // X-Webhook-Signature HMAC-SHA256 keys
+	Tolerance         time.Duration              // max age of a signed timestamp
+	PublicURL         string                     // scheme://host the providers call, for Twilio; derived from the request when empty
+	AllowUnsigned     bool                       // accept requests for providers without a configured secret
+}
+
+// verify checks the signature p is expected to put on a webhook request.
+func (s WebhookSecrets) verify(p domain.Provider, r *http.Request, body []byte, now time.Time) error {
+	tol := s.Tolerance
+	if tol <= 0 {
+		tol = defaultWebhookTolerance
+	}
+	switch p {
+	case domain.ProviderTwilio:
+		token := s.twilioAuthToken(r, body)
+		if token == "" {
+			return s.unsigned(p)
+		}
+		return verifyTwilio(token, s.requestURL(r), r, body)
+	case domain.ProviderSendgrid:
+		if r.Header.Get(headerSendgridSignature) == "" && s.SendgridBasicAuth != "" {
+			return verifyBasicAuth(s.SendgridBasicAuth, r)
+		}
+		if s.SendgridPublicKey == "" {
+			return s.unsigned(p)
+		}
+		return verifySendgrid(s.SendgridPublicKey, r, body, now, tol)
+	default:
+		secret := s.HMAC[p]
+		if secret == "" {
+			return s.unsigned(p)
+		}
+		return verifyHMAC(secret, r, body, now, tol)
+	}
+}
+
+func (s WebhookSecrets) unsigned(p domain.Provider) error {
+	if s.AllowUnsigned {
+		return nil
+	}
+	return fmt.Errorf("%w for %s", ErrNoSecret, p)
+}
+
+// twilioAuthToken picks the key Twilio signed r with: that of the account
+// named by its AccountSid parameter, or else TwilioAuthToken. The AccountSid
+// only selects the key, so naming another account gains a sender nothing.
+func (s WebhookSecrets) twilioAuthToken(r *http.Request, body []byte) string {
+	sid := r.URL.Query().Get("AccountSid")
+	if isFormContent(r) {
+		if params, err := url.ParseQuery(string(body)); err == nil && params.Has("AccountSid") {
+			sid = params.Get("AccountSid")
+		}
+	}
+	if token := s.TwilioAuthTokens[sid]; sid != "" && token != "" {
+		return token
+	}
+	return s.TwilioAuthToken
+}
+
+// requestURL is the URL the provider called, as Twilio signs it.
+func (s WebhookSecrets) requestURL(r *http.Request) string {
+	base := strings.TrimRight(s.PublicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + r.URL.RequestURI()
+}
+
+// verifyTwilio checks X-Twilio-Signature: base64 HMAC-SHA1 of the full URL
+// followed by every POST parameter (sorted by name) as name+value. JSON
+// bodies are instead covered by a bodySHA256 query parameter in the URL.
+//
+// Nothing in the signature expires, so a captured request can be replayed.
+// That is left to the handlers: inbound messages are upserted by MessageSid
+// and status callbacks only advance a message, so a replay changes nothing.
+func verifyTwilio(token, fullURL string, r *http.Request, body []byte) error {
+	got, err := base64.StdEncoding.DecodeString(r.Header.Get(headerTwilioSignature))
+	if err != nil || len(got) == 0 {
+		return ErrBadSignature
+	}
+
+	signed := fullURL
+	if sum := r.URL.Query().Get(twilioBodySHA256QueryKey); sum != "" {
+		want := sha256.Sum256(body)
+		if !strings.EqualFold(sum, hex.EncodeToString(want[:])) {
+			return ErrBadSignature
+		}
+	} else if isFormContent(r) {
+		params, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ErrBadSignature
+		}
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString(fullURL)
+		for _, k := range keys {
+			vs := append([]string(nil), params[k]...)
+			sort.Strings(vs)
+			for _, v := range vs {
+				b.WriteString(k)
+				b.WriteString(v)
+			}
+		}
+		signed = b.String()
+	}
+
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(signed))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// verifySendgrid checks the Event Webhook ECDSA signature over timestamp+body.
+func verifySendgrid(publicKey string, r *http.Request, body []byte, now time.Time, tol time.Duration) error {
+	ts := r.Header.Get(headerSendgridTimestamp)
+	if err := checkTimestamp(ts, now, tol); err != nil {
+		return err
+	}
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("sendgrid public key: %w", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("sendgrid public key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("sendgrid public key: not an ECDSA key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(headerSendgridSignature))
+	if err != nil || len(sig) == 0 {
+		return ErrBadSignature
+	}
+	digest := sha256.Sum256(append([]byte(ts), body...))
+	if !ecdsa.VerifyASN1(ecKey, digest[:], sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// verifyHMAC checks X-Webhook-Signature: hex HMAC-SHA256 of
+// "<X-Webhook-Timestamp>.<body>", optionally prefixed with "sha256=".
+func verifyHMAC(secret string, r *http.Request, body []byte, now time.Time, tol time.Duration) error {
+	ts := r.Header.Get(headerWebhookTimestamp)
+	if err := checkTimestamp(ts, now, tol); err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(headerWebhookSignature), "sha256="))
+	if err != nil || len(got) == 0 {
+		return ErrBadSignature
+	}
+	if subtle.ConstantTimeCompare(got, signHMAC(secret, ts, body)) != 1 {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func signHMAC(secret, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// verifyBasicAuth checks the request's basic-auth credentials against "user:pass".
+func verifyBasicAuth(creds string, r *http.Request) error {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return ErrBadSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(creds)) != 1 {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// checkTimestamp rejects a unix-seconds timestamp further than tol from now.
+func checkTimestamp(ts string, now time.Time, tol time.Duration) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	d := now.Sub(time.Unix(sec, 0))
+	if d < -tol || d > tol {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+func isFormContent(r *http.Request) bool {
+	return strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/x-www-form-urlencoded")
+}
+
+// webhookProvider determines which provider a webhook claims to come from:
+// the {provider} URL parameter when the route has one, otherwise the
+// "<provider>_id" key of a JSON body.
+func webhookProvider(r *http.Request, body []byte) (domain.Provider, bool) {
+	if s := chi.URLParam(r, "provider"); s != "" {
+		return domain.ParseProvider(s)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return "", false
+	}
+	p, _, ok := extractProviderID(raw)
+	return p, ok
+}
+
+// verifyWebhook authenticates provider callbacks before they reach the
+// handler. The body is buffered so the handler can still read it.
+func (h *handler) verifyWebhook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		_ = r.Body.Close()
+		if err != nil {
+			respondBadRequest(w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		p, ok := webhookProvider(r, body)
+		if !ok {
+			webhookReceipts.WithLabelValues("unknown", strconv.Itoa(http.StatusBadRequest)).Inc()
+			respondBadRequest(w, ErrNoProvider.Error())
+			return
+		}
+		logging.Annotate(r.Context(), "provider_id", p.String())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			webhookReceipts.WithLabelValues(p.String(), strconv.Itoa(max(ww.Status(), http.StatusOK))).Inc()
+		}()
+		if err := h.webhooks.verify(p, r, body, time.Now()); err != nil {
+			respondUnauthorized(ww, err.Error())
+			return
+		}
+		next.ServeHTTP(ww, r)
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestVerifyTwilioForm(t *testing.T) {
+	body := "To=%2B12016661234&From=%2B18045551234&Body=hi&MessageSid=SM1"
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/status/twilio", strings.NewReader(body))
+	r.Host = "hooks.example.com"
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// URL followed by the sorted parameters as name+value
+	signed := "https://hooks.example.com/api/webhooks/status/twilio" + "Bodyhi" + "From+18045551234" + "MessageSidSM1" + "To+12016661234"
+	mac := hmac.New(sha1.New, []byte("token"))
+	mac.Write([]byte(signed))
+	r.Header.Set(headerTwilioSignature, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	s := WebhookSecrets{TwilioAuthToken: "token", PublicURL: "https://hooks.example.com"}
+	if err := s.verify(domain.ProviderTwilio, r, []byte(body), time.Now()); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	s.TwilioAuthToken = "other"
+	if err := s.verify(domain.ProviderTwilio, r, []byte(body), time.Now()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+}
+
+func TestVerifyTwilioAccounts(t *testing.T) {
+	sign := func(token, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks/twilio/sms", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		params, _ := url.ParseQuery(body)
+		signed := "https://hooks.example.com/api/webhooks/twilio/sms"
+		for _, k := range []string{"AccountSid", "Body", "MessageSid"} {
+			signed += k + params.Get(k)
+		}
+		mac := hmac.New(sha1.New, []byte(token))
+		mac.Write([]byte(signed))
+		r.Header.Set(headerTwilioSignature, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+	s := WebhookSecrets{
+		TwilioAuthToken:  "default-token",
+		TwilioAuthTokens: map[string]string{"AC2": "second-token"},
+		PublicURL:        "https://hooks.example.com",
+	}
+
+	cases := []struct {
+		name  string
+		sid   string
+		token string
+		want  error
+	}{
+		{"default account", "AC1", "default-token", nil},
+		{"routed account", "AC2", "second-token", nil},
+		{"routed account signed with the default token", "AC2", "default-token", ErrBadSignature},
+		{"unknown account signed with a routed token", "AC3", "second-token", ErrBadSignature},
+	}
+	for _, c := range cases {
+		body := "AccountSid=" + c.sid + "&Body=hi&MessageSid=SM1"
+		err := s.verify(domain.ProviderTwilio, sign(c.token, body), []byte(body), time.Now())
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// routed accounts alone still require a signature from every account
+	s.TwilioAuthToken = ""
+	body := "AccountSid=AC1&Body=hi&MessageSid=SM1"
+	if err := s.verify(domain.ProviderTwilio, sign("", body), []byte(body), time.Now()); !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("unconfigured account: want ErrNoSecret, got %v", err)
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	body := []byte(`{"from":"a","xillio_id":"m-1"}`)
+	now := time.Unix(1_700_000_000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	sign := func(secret, ts string) string {
+		return "sha256=" + hex.EncodeToString(signHMAC(secret, ts, body))
+	}
+	s := WebhookSecrets{HMAC: map[domain.Provider]string{domain.ProviderXillio: "s3cret"}}
+
+	cases := []struct {
+		name string
+		sig  string
+		ts   string
+		at   time.Time
+		want error
+	}{
+		{"valid", sign("s3cret", ts), ts, now, nil},
+		{"wrong secret", sign("nope", ts), ts, now, ErrBadSignature},
+		{"replayed", sign("s3cret", ts), ts, now.Add(10 * time.Minute), ErrStaleTimestamp},
+		{"missing timestamp", sign("s3cret", ts), "", now, ErrStaleTimestamp},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks/email", nil)
+		r.Header.Set(headerWebhookSignature, c.sig)
+		r.Header.Set(headerWebhookTimestamp, c.ts)
+		err := s.verify(domain.ProviderXillio, r, body, c.at)
+		if c.want == nil && err != nil || c.want != nil && !errors.Is(err, c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestVerifySendgrid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	body := []byte(`[{"event":"delivered","sg_message_id":"abc.filter1"}]`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	digest := sha256.Sum256(append([]byte(ts), body...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/status/sendgrid", nil)
+	r.Header.Set(headerSendgridSignature, base64.StdEncoding.EncodeToString(sig))
+	r.Header.Set(headerSendgridTimestamp, ts)
+
+	s := WebhookSecrets{SendgridPublicKey: base64.StdEncoding.EncodeToString(der)}
+	if err := s.verify(domain.ProviderSendgrid, r, body, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := s.verify(domain.ProviderSendgrid, r, append(body, ' '), now); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered body: want ErrBadSignature, got %v", err)
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/sms", nil)
+	if err := (WebhookSecrets{}).verify(domain.ProviderMessagingProvider, r, nil, time.Now()); !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("want ErrNoSecret, got %v", err)
+	}
+	if err := (WebhookSecrets{AllowUnsigned: true}).verify(domain.ProviderMessagingProvider, r, nil, time.Now()); err != nil {
+		t.Fatalf("allow unsigned: %v", err)
+	}
+}
+
+func TestVerifyWebhookMiddleware(t *testing.T) {
+	h := &handler{webhooks: WebhookSecrets{}}
+	called := false
+	mw := h.verifyWebhook(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	rr := httptest.NewRecorder()
+	mw.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/webhooks/sms", strings.NewReader(`{"messaging_provider_id":"m-1"}`)))
+	if rr.Code != http.StatusUnauthorized || called {
+		t.Fatalf("unsigned request: code %d called %v", rr.Code, called)
+	}
+
+	rr = httptest.NewRecorder()
+	mw.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/webhooks/sms", strings.NewReader(`{"from":"x"}`)))
+	if rr.Code != http.StatusBadRequest || called {
+		t.Fatalf("no provider: code %d called %v", rr.Code, called)
+	}
+}
@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+// Conversation streams push the message events of one conversation to a
+// client as they are committed, over SSE or a WebSocket. Each server holds a
+// single LISTEN connection for all of its streams (see streamHub); since
+// Postgres notifies every listening server, a stream sees the changes made
+// through any replica or processor. Event ids are message_events ids, so a
+// client resumes with the last id it received.
+const (
+	streamMessageCreated = "message.created" // a message was stored
+	streamMessageUpdated = "message.updated" // a message changed status or recorded a delivery attempt
+
+	streamPingInterval  = 15 * time.Second // keeps proxies from closing idle streams
+	streamWriteTimeout  = 10 * time.Second
+	streamRelistenDelay = time.Second
+	streamPageSize      = 100 // events loaded per query when catching up
+	streamQueueSize     = 64  // notifications buffered per stream before it reloads instead
+	streamSentMemory    = 512 // ids remembered to drop duplicates
+)
+
+var ErrBadLastEventID = errors.New("bad last event id")
+
+// streamEvent is one event of a conversation stream. Message is the message
+// as it is when the event is sent, Change the event that raised it.
+type streamEvent struct {
+	ID      int64               `json:"id"`
+	Type    string              `json:"type"`
+	Message domain.Message      `json:"message"`
+	Change  domain.MessageEvent `json:"change"`
+}
+
+// streamSink writes a conversation stream to one client.
+type streamSink interface {
+	send(e streamEvent) error
+	ping() error
+}
+
+// conversationListener is the part of repo.ConversationListener the hub uses.
+type conversationListener interface {
+	Wait(ctx context.Context) (repo.ConversationNotification, error)
+	Close()
+}
+
+// streamSub is one open stream's view of the hub.
+type streamSub struct {
+	events chan int64    // ids of events committed in the conversation
+	resync chan struct{} // events may have been missed; reload after the last one sent
+}
+
+func (s *streamSub) signalResync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
+// streamHub fans the notifications of one LISTEN connection out to the
+// streams open on this server. It listens only while a stream is open.
+type streamHub struct {
+	listen func(ctx context.Context) (conversationListener, error)
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	subs  map[int64]map[*streamSub]struct{} // by conversation id
+	count int
+	stop  context.CancelFunc // of the listen loop; nil while no stream is open
+}
+
+func newStreamHub(msgs *repo.MessageRepo, logger *slog.Logger) *streamHub {
+	return &streamHub{
+		listen: func(ctx context.Context) (conversationListener, error) {
+			l, err := msgs.ListenConversations(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return l, nil
+		},
+		logger: logger,
+		subs:   make(map[int64]map[*streamSub]struct{}),
+	}
+}
+
+// subscribe registers a stream of conversation convID, starting the listen
+// loop for the first one.
+func (h *streamHub) subscribe(convID int64) *streamSub {
+	s := &streamSub{events: make(chan int64, streamQueueSize), resync: make(chan struct{}, 1)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[convID] == nil {
+		h.subs[convID] = make(map[*streamSub]struct{})
+	}
+	h.subs[convID][s] = struct{}{}
+	h.count++
+	if h.stop == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.stop = cancel
+		go h.run(ctx)
+	}
+	return s
+}
+
+// unsubscribe removes a stream, stopping the listen loop after the last one.
+func (h *streamHub) unsubscribe(convID int64, s *streamSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[convID][s]; !ok {
+		return
+	}
+	delete(h.subs[convID], s)
+	if len(h.subs[convID]) == 0 {
+		delete(h.subs, convID)
+	}
+	h.count--
+	if h.count == 0 && h.stop != nil {
+		h.stop()
+		h.stop = nil
+	}
+}
+
+// run listens until ctx is done, reconnecting after errors.
+func (h *streamHub) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		l, err := h.listen(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.Error("listen for conversation events failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(streamRelistenDelay):
+			}
+			continue
+		}
+		// anything committed while nobody was listening is reloaded
+		h.resyncAll()
+		for {
+			n, err := l.Wait(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					h.logger.Warn("conversation listener lost", "error", err)
+				}
+				break
+			}
+			h.dispatch(n)
+		}
+		l.Close()
+	}
+}
+
+func (h *streamHub) dispatch(n repo.ConversationNotification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[n.ConversationID] {
+		select {
+		case s.events <- n.EventID:
+		default:
+			// a slow client catches up from the database instead
+			s.signalResync()
+		}
+	}
+}
+
+func (h *streamHub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for s := range subs {
+			s.signalResync()
+		}
+	}
+}
+
+// conversationStream is the state of one open stream.
+type conversationStream struct {
+	tenantID int64
+	convID   int64
+	resume   bool  // the client gave the last event it received
+	last     int64 // highest event id sent
+
+	// sent remembers recent ids: events can commit out of id order, so a
+	// notification may name an event older than last that was not sent yet.
+	sent [streamSentMemory]int64
+	next int
+}
+
+func (s *conversationStream) wasSent(id int64) bool {
+	for _, v := range s.sent {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *conversationStream) markSent(id int64) {
+	s.sent[s.next] = id
+	s.next = (s.next + 1) % len(s.sent)
+	if id > s.last {
+		s.last = id
+	}
+}
+
+// openConversationStream checks that the tenant has the conversation and
+// reads where the client wants to resume from: the Last-Event-ID header an
+// EventSource sends when it reconnects, or ?last_event_id=.
+func (h *handler) openConversationStream(r *http.Request, idStr string) (*conversationStream, error) {
+	ctx := r.Context()
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, ErrBadID
+	}
+	s := &conversationStream{tenantID: tenantFrom(ctx), convID: id}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	if last != "" {
+		if s.last, err = strconv.ParseInt(last, 10, 64); err != nil || s.last < 0 {
+			return nil, ErrBadLastEventID
+		}
+		s.resume = true
+	}
+	if _, err := h.convs.GetByID(ctx, s.tenantID, id); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// serveStream writes the events of s to sink until the client goes away or
+// the server shuts down.
+func (h *handler) serveStream(ctx context.Context, s *conversationStream, sink streamSink) error {
+	sub := h.streams.subscribe(s.convID)
+	defer h.streams.unsubscribe(s.convID, sub)
+
+	// subscribed first, so nothing committed from here on is missed
+	if s.resume {
+		if err := h.catchUp(ctx, s, sink); err != nil {
+			return err
+		}
+	} else {
+		last, err := h.msgs.LastConversationEventID(ctx, s.convID)
+		if err != nil {
+			return err
+		}
+		s.last = last
+	}
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.shuttingDown:
+			return nil
+		case id := <-sub.events:
+			if err := h.sendEventByID(ctx, s, sink, id); err != nil {
+				return err
+			}
+		case <-sub.resync:
+			if err := h.catchUp(ctx, s, sink); err != nil {
+				return err
+			}
+		case <-ping.C:
+			if err := sink.ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// catchUp sends the events after the last one sent.
+func (h *handler) catchUp(ctx context.Context, s *conversationStream, sink streamSink) error {
+	for {
+		events, err := h.msgs.ConversationEvents(ctx, s.tenantID, s.convID, s.last, streamPageSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := h.sendEvent(ctx, s, sink, e); err != nil {
+				return err
+			}
+		}
+		if len(events) < streamPageSize {
+			return nil
+		}
+	}
+}
+
+func (h *handler) sendEventByID(ctx context.Context, s *conversationStream, sink streamSink, id int64) error {
+	if s.wasSent(id) {
+		return nil
+	}
+	e, err := h.msgs.Event(ctx, id)
+	if errors.Is(err, repo.ErrNotFound) {
+		// deleted together with its message
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.sendEvent(ctx, s, sink, e)
+}
+
+func (h *handler) sendEvent(ctx context.Context, s *conversationStream, sink streamSink, e domain.MessageEvent) error {
+	if s.wasSent(e.ID) {
+		return nil
+	}
+	m, err := h.msgs.GetByIDForTenant(ctx, s.tenantID, e.MessageID)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	typ := streamMessageUpdated
+	if e.FromStatus == nil {
+		typ = streamMessageCreated
+	}
+	if err := sink.send(streamEvent{ID: e.ID, Type: typ, Message: m, Change: e}); err != nil {
+		return err
+	}
+	s.markSent(e.ID)
+	return nil
+}
+
+// handleConversationStream streams a conversation as Server-Sent Events.
+func (h *handler) handleConversationStream(w http.ResponseWriter, r *http.Request, idStr string) {
+	s, err := h.openConversationStream(r, idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrBadLastEventID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+
+	sink := &sseSink{w: w, rc: http.NewResponseController(w)}
+	// the server's ReadTimeout would otherwise cancel the request mid-stream
+	if err := sink.rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		respondInternalServerError(w, r, "stream unsupported", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise hold events back
+	w.WriteHeader(http.StatusOK)
+	if err := sink.flush(); err != nil {
+		slog.ErrorContext(r.Context(), "stream unsupported", "error", err)
+		return
+	}
+	if err := h.serveStream(r.Context(), s, sink); err != nil && r.Context().Err() == nil {
+		slog.WarnContext(r.Context(), "conversation stream ended", "error", err)
+	}
+}
+
+// sseSink writes text/event-stream frames.
+type sseSink struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseSink) send(e streamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// ping writes a comment, which EventSource ignores.
+func (s *sseSink) ping() error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *sseSink) flush() error { return s.rc.Flush() }
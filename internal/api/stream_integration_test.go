@@ -0,0 +1,79 @@
+//go:build integration
+// +build integration
+
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+func TestConversationStreamOpen(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	h := &handler{
+		convs:   repo.NewConversationRepo(pool),
+		msgs:    repo.NewMessageRepo(pool),
+		streams: newStreamHub(repo.NewMessageRepo(pool), slog.Default()),
+	}
+	ctx := withTenant(context.Background(), domain.DefaultTenantID)
+	request := func(id string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/conversations/"+id+"/ws", nil).WithContext(ctx)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		if header.Get("Upgrade") != "" {
+			h.handleConversationWebSocket(w, r, id)
+		} else {
+			h.handleConversationStream(w, r, id)
+		}
+		return w
+	}
+	upgrade := http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+
+	// a conversation that does not exist, over SSE and WebSocket
+	for _, hdr := range []http.Header{nil, upgrade} {
+		if w := request("999999999999", hdr); w.Code != http.StatusNotFound {
+			t.Errorf("missing conversation (upgrade=%v): got %d %s", hdr != nil, w.Code, w.Body)
+		}
+	}
+
+	a := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "tenant-" + uuid.NewString() + "@example.com"}
+	b := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "customer-" + uuid.NewString() + "@example.com"}
+	id, err := h.convs.GetOrCreateByEndpoints(ctx, domain.DefaultTenantID, a, b)
+	if err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	t.Cleanup(func() { _, _ = pool.Exec(context.Background(), `DELETE FROM conversations WHERE id = $1`, id) })
+
+	// a browser on another site may not open the WebSocket
+	crossSite := upgrade.Clone()
+	crossSite.Set("Origin", "https://evil.example")
+	if w := request(strconv.FormatInt(id, 10), crossSite); w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin websocket: got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+type fakeConversationListener struct {
+	notes  chan repo.ConversationNotification
+	closed chan struct{}
+}
+
+func (l *fakeConversationListener) Wait(ctx context.Context) (repo.ConversationNotification, error) {
+	select {
+	case n := <-l.notes:
+		return n, nil
+	case <-ctx.Done():
+		return repo.ConversationNotification{}, ctx.Err()
+	}
+}
+
+func (l *fakeConversationListener) Close() { close(l.closed) }
+
+func TestStreamHub(t *testing.T) {
+	l := &fakeConversationListener{notes: make(chan repo.ConversationNotification), closed: make(chan struct{})}
+	listens := 0
+	h := &streamHub{
+		listen: func(context.Context) (conversationListener, error) {
+			listens++
+			return l, nil
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subs:   make(map[int64]map[*streamSub]struct{}),
+	}
+
+	a := h.subscribe(1)
+	b := h.subscribe(2)
+	// a new listener may have missed events
+	for _, s := range []*streamSub{a, b} {
+		select {
+		case <-s.resync:
+		case <-time.After(time.Second):
+			t.Fatal("no resync after listening")
+		}
+	}
+
+	l.notes <- repo.ConversationNotification{ConversationID: 1, EventID: 10}
+	l.notes <- repo.ConversationNotification{ConversationID: 2, EventID: 11}
+	if id := <-a.events; id != 10 {
+		t.Fatalf("conversation 1 got event %d", id)
+	}
+	if id := <-b.events; id != 11 {
+		t.Fatalf("conversation 2 got event %d", id)
+	}
+
+	// a stream that falls behind reloads instead of blocking the others
+	for i := 0; i <= streamQueueSize; i++ {
+		l.notes <- repo.ConversationNotification{ConversationID: 1, EventID: int64(100 + i)}
+	}
+	l.notes <- repo.ConversationNotification{ConversationID: 2, EventID: 12}
+	if id := <-b.events; id != 12 {
+		t.Fatalf("conversation 2 got event %d", id)
+	}
+	select {
+	case <-a.resync:
+	default:
+		t.Fatal("no resync after the queue filled up")
+	}
+
+	h.unsubscribe(1, a)
+	h.unsubscribe(2, b)
+	select {
+	case <-l.closed:
+	case <-time.After(time.Second):
+		t.Fatal("listener still open without streams")
+	}
+	if listens != 1 {
+		t.Fatalf("listened %d times", listens)
+	}
+}
+
+func TestConversationStreamSent(t *testing.T) {
+	s := &conversationStream{last: 5}
+	for id := int64(1); id <= streamSentMemory+1; id++ {
+		s.markSent(id + 10)
+	}
+	if s.last != streamSentMemory+11 {
+		t.Fatalf("last = %d", s.last)
+	}
+	if s.wasSent(11) || !s.wasSent(12) || !s.wasSent(s.last) || s.wasSent(7) {
+		t.Fatal("wrong ids remembered")
+	}
+}
+
+func TestOpenConversationStreamLastEventID(t *testing.T) {
+	h := &handler{}
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/conversations/1/stream?last_event_id=x", nil),
+		httptest.NewRequest(http.MethodGet, "/api/conversations/1/stream?last_event_id=-1", nil),
+	} {
+		if _, err := h.openConversationStream(r, "1"); !errors.Is(err, ErrBadLastEventID) {
+			t.Errorf("%s: want ErrBadLastEventID, got %v", r.URL, err)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/conversations/x/stream", nil)
+	if _, err := h.openConversationStream(r, "x"); !errors.Is(err, ErrBadID) {
+		t.Errorf("want ErrBadID, got %v", err)
+	}
+}
+
+func TestSSESink(t *testing.T) {
+	rec := httptest.NewRecorder()
+	s := &sseSink{w: rec, rc: http.NewResponseController(rec)}
+	e := streamEvent{
+		ID:      42,
+		Type:    streamMessageCreated,
+		Message: domain.Message{ID: 7, Body: "hi"},
+		Change:  domain.MessageEvent{ID: 42, MessageID: 7, ToStatus: domain.StatusOutbox},
+	}
+	if err := s.send(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ping(); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "id: 42\nevent: message.created\ndata: {\"id\":42,") || !strings.HasSuffix(body, "\n\n: ping\n\n") {
+		t.Fatalf("body = %q", body)
+	}
+	if !rec.Flushed {
+		t.Fatal("not flushed")
+	}
+}
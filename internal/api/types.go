@@ -1,27 +1,76 @@
 package api
 
 import (
+	"context"
+	"log/slog"
+	"time"
+
 	"github.com/rdavison/messaging-service/internal/domain"
 	"github.com/rdavison/messaging-service/internal/repo"
 )
 
 type handler struct {
-	convs *repo.ConversationRepo
-	msgs  *repo.MessageRepo
+	convs    *repo.ConversationRepo
+	msgs     *repo.MessageRepo
+	batches  *repo.BatchRepo
+	tmpls    *repo.TemplateRepo
+	subs     *repo.SubscriptionRepo
+	keys     apiKeyAuthenticator
+	webhooks WebhookSecrets
+	streams  *streamHub
+
+	idempotencyRetention time.Duration
+	webSocketOrigins     []string
+	shuttingDown         <-chan struct{}
+}
+
+// apiKeyAuthenticator resolves an API key to its tenant (see repo.APIKeyRepo).
+type apiKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (int64, error)
+}
+
+// Options configures the HTTP API.
+type Options struct {
+	Webhooks WebhookSecrets
+	// IdempotencyRetention is how long an Idempotency-Key is remembered.
+	IdempotencyRetention time.Duration
+	// Logger writes the request log; slog.Default() if nil.
+	Logger *slog.Logger
+	// WebSocketOrigins are the host patterns (path.Match syntax, e.g.
+	// "*.example.com") of the browser origins allowed to open conversation
+	// WebSockets besides the API's own host.
+	WebSocketOrigins []string
+	// ShuttingDown is closed when the server starts shutting down, which
+	// ends the conversation streams it holds open.
+	ShuttingDown <-chan struct{}
 }
 
 type conversationsResponse struct {
 	Conversations []domain.Conversation `json:"conversations"`
+	NextCursor    *string               `json:"next_cursor"` // null on the last page
 }
 
 type messagesResponse struct {
-	Messages []domain.Message `json:"messages"`
+	Messages   []domain.Message `json:"messages"`
+	NextCursor *string          `json:"next_cursor"` // null on the last page
+}
+
+type messageEventsResponse struct {
+	Events []domain.MessageEvent `json:"events"` // oldest first
 }
 
 type idResponse struct {
 	ID string `json:"id"`
 }
 
+// Webhooks Status: POST /webhooks/status/{provider}
+type statusCallbackResponse struct {
+	Updated []string `json:"updated"` // message ids whose status advanced
+	Stale   int      `json:"stale"`   // already at or past the reported status
+	Unknown int      `json:"unknown"` // no outbound message with that provider message id (yet); answered 503
+	Ignored int      `json:"ignored"` // events that do not change a tracked status
+}
+
 // Messages SMS Outbound: POST /messages/sms
 type smsOutboundRequest struct {
 	From        string   `json:"from"`
@@ -29,7 +78,9 @@ type smsOutboundRequest struct {
 	Type        string   `json:"type"` // "sms" | "mms"
 	Body        string   `json:"body"`
 	Attachments []string `json:"attachments,omitempty"`
-	Timestamp   string   `json:"timestamp"` // RFC3339
+	Timestamp   string   `json:"timestamp"`         // RFC3339
+	SendAt      string   `json:"send_at,omitempty"` // RFC3339; schedules the message when in the future
+	templateRef
 }
 
 // Messages Email Outbound: POST /messages/email
@@ -38,7 +89,103 @@ type emailOutboundRequest struct {
 	To          string   `json:"to"`
 	Body        string   `json:"body"`
 	Attachments []string `json:"attachments,omitempty"`
-	Timestamp   string   `json:"timestamp"` // RFC3339
+	Timestamp   string   `json:"timestamp"`         // RFC3339
+	SendAt      string   `json:"send_at,omitempty"` // RFC3339; schedules the message when in the future
+	templateRef
+}
+
+// templateRef lets an outbound request use a stored template instead of a
+// literal body.
+type templateRef struct {
+	TemplateID      string            `json:"template_id,omitempty"`
+	TemplateVersion int               `json:"template_version,omitempty"` // latest when 0
+	Variables       map[string]string `json:"variables,omitempty"`
+}
+
+// Messages Batch: POST /messages/batch
+type batchRequest struct {
+	Type            string           `json:"type"` // "sms" | "mms" | "email"
+	From            string           `json:"from"`
+	Body            string           `json:"body"`                  // may contain {{variable}} placeholders
+	TemplateID      string           `json:"template_id,omitempty"` // instead of body
+	TemplateVersion int              `json:"template_version,omitempty"`
+	Attachments     []string         `json:"attachments,omitempty"`
+	Timestamp       string           `json:"timestamp,omitempty"` // RFC3339; defaults to now
+	SendAt          string           `json:"send_at,omitempty"`   // RFC3339
+	Recipients      []batchRecipient `json:"recipients"`
+}
+
+type batchRecipient struct {
+	To        string            `json:"to"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+type batchResponse struct {
+	BatchID  string            `json:"batch_id"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Items    []batchItemResult `json:"items"` // one per recipient, in request order
+}
+
+type batchItemResult struct {
+	To    string `json:"to"`
+	ID    string `json:"id,omitempty"`    // message id when accepted
+	Error string `json:"error,omitempty"` // validation error when rejected
+}
+
+// Batches: GET /batches/{id}
+type batchProgressResponse struct {
+	Batch domain.Batch `json:"batch"`
+}
+
+// Templates: POST /templates
+type templateCreateRequest struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"` // "sms" | "mms" | "email"
+	Body    string `json:"body"`
+}
+
+// Templates: PUT /templates/{id}
+type templateUpdateRequest struct {
+	Body string `json:"body"`
+}
+
+type templatesResponse struct {
+	Templates  []domain.Template `json:"templates"`
+	NextCursor *string           `json:"next_cursor"` // null on the last page
+}
+
+// Subscriptions: POST /subscriptions
+type subscriptionCreateRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"` // generated when empty
+}
+
+// Subscriptions: PATCH /subscriptions/{id}
+type subscriptionUpdateRequest struct {
+	URL     *string  `json:"url,omitempty"`
+	Events  []string `json:"events,omitempty"`
+	Enabled *bool    `json:"enabled,omitempty"`
+}
+
+type subscriptionsResponse struct {
+	Subscriptions []domain.Subscription `json:"subscriptions"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []domain.Delivery `json:"deliveries"` // newest first
+}
+
+// Messages Bulk Cancel: POST /messages/cancel
+type cancelResponse struct {
+	Canceled []string `json:"canceled"` // ids of the messages canceled
+}
+
+// Messages Reschedule: PATCH /messages/{id}
+type rescheduleRequest struct {
+	SendAt string  `json:"send_at"`        // RFC3339
+	Body   *string `json:"body,omitempty"` // replaces the body when set
 }
 
 // Webhooks SMS Inbound: POST /webhooks/sms
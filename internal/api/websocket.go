@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// wsMaxMessage is the largest message accepted from a client. Messages from
+// the client carry no meaning and are discarded.
+const wsMaxMessage = 64 << 10
+
+// wsSink sends each event of a conversation stream as a text message.
+type wsSink struct {
+	ctx  context.Context
+	conn *websocket.Conn
+}
+
+func (s *wsSink) send(e streamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, streamWriteTimeout)
+	defer cancel()
+	return s.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// ping waits for the client's pong, so a client that stops answering ends
+// the stream.
+func (s *wsSink) ping() error {
+	ctx, cancel := context.WithTimeout(s.ctx, streamWriteTimeout)
+	defer cancel()
+	return s.conn.Ping(ctx)
+}
+
+// discardMessages reads from the client, which answers pings and close
+// frames, until the connection fails or is closed, then calls done.
+func discardMessages(ctx context.Context, conn *websocket.Conn, done context.CancelFunc) {
+	defer done()
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			return
+		}
+	}
+}
+
+// handleConversationWebSocket streams a conversation over a WebSocket. Each
+// text message is a streamEvent in JSON. Browser origins other than the
+// API's own host must be listed in Options.WebSocketOrigins.
+func (h *handler) handleConversationWebSocket(w http.ResponseWriter, r *http.Request, idStr string) {
+	s, err := h.openConversationStream(r, idStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBadID), errors.Is(err, ErrBadLastEventID):
+			respondBadRequest(w, err.Error())
+		case errors.Is(err, ErrNotFound):
+			respondNotFound(w, r)
+		default:
+			respondInternalServerError(w, r, "db error", err)
+		}
+		return
+	}
+
+	// the server's read and write timeouts are meant for requests, not
+	// streams, and stay on the connection once it is hijacked
+	rc := http.NewResponseController(w)
+	for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := set(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			respondInternalServerError(w, r, "websocket unsupported", err)
+			return
+		}
+	}
+	// Accept answers requests that are not a valid upgrade, or come from an
+	// origin not allowed, itself
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: h.webSocketOrigins})
+	if err != nil {
+		slog.WarnContext(r.Context(), "websocket upgrade refused", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsMaxMessage)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go discardMessages(ctx, conn, cancel)
+
+	err = h.serveStream(ctx, s, &wsSink{ctx: ctx, conn: conn})
+	switch {
+	case err != nil && ctx.Err() == nil:
+		slog.WarnContext(ctx, "conversation stream ended", "error", err)
+		conn.Close(websocket.StatusInternalError, "")
+	case ctx.Err() == nil:
+		// the server is shutting down
+		conn.Close(websocket.StatusGoingAway, "")
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestWSSink(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.CloseNow()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go discardMessages(ctx, conn, cancel)
+
+		s := &wsSink{ctx: ctx, conn: conn}
+		if err := s.send(streamEvent{ID: 42, Type: streamMessageCreated, Message: domain.Message{ID: 7}}); err != nil {
+			done <- err
+			return
+		}
+		// answered by the client's reader
+		done <- s.ping()
+		conn.Close(websocket.StatusGoingAway, "")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	typ, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var e streamEvent
+	if err := json.Unmarshal(data, &e); err != nil || typ != websocket.MessageText || e.ID != 42 || e.Message.ID != 7 {
+		t.Fatalf("got %v %s (%v)", typ, data, err)
+	}
+	// reading answers the ping, then gets the close
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Fatalf("want going away, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rdavison/messaging-service/internal/config"
+	"github.com/rdavison/messaging-service/internal/db"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+// CreateAPIKey issues a key for tenant (created if missing) and writes the
+// plaintext key to out. It cannot be recovered afterwards.
+func CreateAPIKey(ctx context.Context, out io.Writer, tenant, name string) error {
+	keys, closeFn, err := apiKeyRepo(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	key, err := keys.Create(ctx, tenant, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, key)
+	return nil
+}
+
+// RevokeAPIKey revokes the keys starting with prefix.
+func RevokeAPIKey(ctx context.Context, out io.Writer, prefix string) error {
+	keys, closeFn, err := apiKeyRepo(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	n, err := keys.Revoke(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no active api key with prefix %q", prefix)
+	}
+	fmt.Fprintf(out, "revoked %d key(s)\n", n)
+	return nil
+}
+
+func apiKeyRepo(ctx context.Context) (*repo.APIKeyRepo, func(), error) {
+	cfg := config.MustLoad()
+	dbCtx, cancel := context.WithTimeout(ctx, cfg.DBConnectTO)
+	defer cancel()
+
+	pool, err := db.NewPool(dbCtx, cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo.NewAPIKeyRepo(pool), pool.Close, nil
+}
@@ -2,7 +2,7 @@ package app
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,20 +12,20 @@ import (
 	"github.com/rdavison/messaging-service/internal/api"
 	"github.com/rdavison/messaging-service/internal/config"
 	"github.com/rdavison/messaging-service/internal/db"
-	"github.com/rdavison/messaging-service/internal/processor"
+	"github.com/rdavison/messaging-service/internal/domain"
 )
 
 func DefaultAPIServer() {
-	logger := log.New(os.Stdout, "", log.LstdFlags)
-
 	cfg := config.MustLoad()
+	logger := newLogger(cfg)
 
 	rootCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	a, err := NewAPIServer(rootCtx, cfg, logger)
 	if err != nil {
-		logger.Fatalf("init: %v", err)
+		logger.Error("init failed", "error", err)
+		os.Exit(1)
 	}
 	a.Start(rootCtx)
 
@@ -34,11 +34,11 @@ func DefaultAPIServer() {
 	shCtx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout())
 	defer cancel()
 
-	logger.Println("shutting down...")
+	logger.Info("shutting down")
 	a.Shutdown(shCtx)
 }
 
-func NewAPIServer(ctx context.Context, cfg config.Config, logger *log.Logger) (*appApiserver, error) {
+func NewAPIServer(ctx context.Context, cfg config.Config, logger *slog.Logger) (*appApiserver, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, cfg.DBConnectTO)
 	defer cancel()
 
@@ -47,34 +47,72 @@ func NewAPIServer(ctx context.Context, cfg config.Config, logger *log.Logger) (*
 		return nil, err
 	}
 
-	h := api.NewRouter(pool)
+	registerPoolMetrics(pool)
+	opts, err := apiOptions(cfg)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	stopTracing, err := startTracing(ctx, "messaging-apiserver", logger)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	shuttingDown := make(chan struct{})
+	opts.ShuttingDown = shuttingDown
+	h := api.NewRouter(pool, opts)
 	srv := &http.Server{
 		Addr:         cfg.Addr,
 		Handler:      h,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
-		ErrorLog:     logger,
+		ErrorLog:     errorLog(logger),
 	}
-
-	provRouter := processor.DefaultRouter()
-	entry := processor.NewEntrypoint(pool, provRouter, logger)
+	// Shutdown waits for open requests, so conversation streams must end
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
 
 	return &appApiserver{
 		cfg:    cfg,
 		pool:   pool,
 		server: srv,
-		entry:  entry,
 		logger: logger,
+
+		stopTracing: stopTracing,
+	}, nil
+}
+
+func apiOptions(cfg config.Config) (api.Options, error) {
+	twilioTokens, err := twilioAuthTokens(cfg)
+	if err != nil {
+		return api.Options{}, err
+	}
+	return api.Options{
+		Webhooks: api.WebhookSecrets{
+			TwilioAuthToken:   cfg.TwilioAuthToken,
+			TwilioAuthTokens:  twilioTokens,
+			SendgridPublicKey: cfg.SendgridWebhookPublicKey,
+			SendgridBasicAuth: cfg.SendgridInboundBasicAuth,
+			HMAC: map[domain.Provider]string{
+				domain.ProviderMessagingProvider: cfg.MessagingProviderWebhookSecret,
+				domain.ProviderXillio:            cfg.XillioWebhookSecret,
+			},
+			Tolerance:     cfg.WebhookTolerance,
+			PublicURL:     cfg.WebhookPublicURL,
+			AllowUnsigned: cfg.WebhookAllowUnsigned,
+		},
+		IdempotencyRetention: cfg.IdempotencyRetention,
+		WebSocketOrigins:     cfg.WebSocketOrigins,
 	}, nil
 }
 
 func (a *appApiserver) Start(ctx context.Context) {
 	// start the api server
 	go func() {
-		a.logger.Printf("listening on %s", a.server.Addr)
+		a.logger.Info("listening", "addr", a.server.Addr)
 		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			a.logger.Fatalf("http server: %v", err)
+			a.logger.Error("http server failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 }
@@ -82,10 +120,14 @@ func (a *appApiserver) Start(ctx context.Context) {
 func (a *appApiserver) Shutdown(ctx context.Context) {
 	// stop HTTP first to drain keep-alives
 	if err := a.server.Shutdown(ctx); err != nil {
-		a.logger.Printf("server shutdown error: %v", err)
+		a.logger.Error("server shutdown failed", "error", err)
 	}
 	// DB pool closes after in-flight ops finish
 	a.pool.Close()
+	// flush the spans of the last requests
+	if err := a.stopTracing(ctx); err != nil {
+		a.logger.Error("tracing shutdown failed", "error", err)
+	}
 }
 
 func (a *appApiserver) ShutdownTimeout() time.Duration { return a.cfg.ShutdownAfter }
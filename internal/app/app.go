@@ -3,12 +3,17 @@ package app
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"sync"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/rdavison/messaging-service/internal/config"
+	"github.com/rdavison/messaging-service/internal/logging"
 	"github.com/rdavison/messaging-service/internal/processor"
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
 type App interface {
@@ -21,8 +26,9 @@ type appApiserver struct {
 	cfg    config.Config
 	pool   *pgxpool.Pool
 	server *http.Server
-	entry  *processor.Entrypoint
-	logger *log.Logger
+	logger *slog.Logger
+
+	stopTracing func(context.Context) error
 }
 
 type appProcessor struct {
@@ -30,5 +36,38 @@ type appProcessor struct {
 	pool   *pgxpool.Pool
 	server *http.Server
 	entry  *processor.Entrypoint
-	logger *log.Logger
+	logger *slog.Logger
+
+	deliveries  *processor.DeliveryWorker
+	stopTracing func(context.Context) error
+
+	running sync.WaitGroup // the send and delivery loops
+}
+
+// startTracing sets up tracing under the service name given by
+// OTEL_SERVICE_NAME or service. Spans are exported if an OTLP endpoint is
+// configured.
+func startTracing(ctx context.Context, service string, logger *slog.Logger) (func(context.Context) error, error) {
+	return tracing.Init(ctx, tracing.Options{ServiceName: service, Logger: logger})
+}
+
+// newLogger builds the logger from the LOG_* settings and makes it the slog
+// default. An unknown level falls back to info.
+func newLogger(cfg config.Config) *slog.Logger {
+	level, levelErr := logging.ParseLevel(cfg.LogLevel)
+	logger := logging.New(os.Stdout, logging.Options{
+		Format: cfg.LogFormat,
+		Level:  level,
+		Redact: cfg.LogRedact,
+	})
+	if levelErr != nil {
+		logger.Warn("using log level info", "error", levelErr)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// errorLog adapts logger for http.Server.ErrorLog.
+func errorLog(logger *slog.Logger) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), slog.LevelError)
 }
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+// runIdempotencyCleanup purges Idempotency-Keys older than retention every
+// interval until ctx is done. Lookups already ignore expired keys, so this
+// only keeps the table from growing.
+func runIdempotencyCleanup(ctx context.Context, msgs *repo.MessageRepo, retention, interval time.Duration, logger *slog.Logger) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := msgs.PurgeIdempotencyKeys(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			logger.ErrorContext(ctx, "idempotency cleanup failed", "error", err)
+		} else if n > 0 {
+			logger.InfoContext(ctx, "idempotency keys purged", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
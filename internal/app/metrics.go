@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rdavison/messaging-service/internal/metrics"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+// outboxScrapeTimeout bounds the outbox query run on each scrape.
+const outboxScrapeTimeout = 5 * time.Second
+
+// register adds c to the default registry, replacing a collector of the same
+// metrics registered by an earlier server in this process.
+func register(c prometheus.Collector) {
+	err := metrics.Default.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		metrics.Default.Unregister(are.ExistingCollector)
+		err = metrics.Default.Register(c)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// poolCollector exposes the connection pool's statistics.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	conns, maxConns, acquires, canceled, acquireDuration *prometheus.Desc
+}
+
+func registerPoolMetrics(pool *pgxpool.Pool) {
+	register(&poolCollector{
+		pool:            pool,
+		conns:           prometheus.NewDesc("pgxpool_connections", "Connections in the pool by state.", []string{"state"}, nil),
+		maxConns:        prometheus.NewDesc("pgxpool_max_connections", "Maximum size of the pool.", nil, nil),
+		acquires:        prometheus.NewDesc("pgxpool_acquires_total", "Connections acquired from the pool, by whether the pool had to wait for one.", []string{"wait"}, nil),
+		canceled:        prometheus.NewDesc("pgxpool_canceled_acquires_total", "Acquires canceled before a connection was available.", nil, nil),
+		acquireDuration: prometheus.NewDesc("pgxpool_acquire_duration_seconds_total", "Total time spent acquiring connections.", nil, nil),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conns
+	ch <- c.maxConns
+	ch <- c.acquires
+	ch <- c.canceled
+	ch <- c.acquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()-s.EmptyAcquireCount()), "false")
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()), "true")
+	ch <- prometheus.MustNewConstMetric(c.canceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
+
+// outboxCollector exposes the number of messages waiting to be sent. It is
+// queried on every scrape, so only the processor registers it.
+type outboxCollector struct {
+	msgs  *repo.MessageRepo
+	depth *prometheus.Desc
+}
+
+func registerOutboxMetrics(msgs *repo.MessageRepo) {
+	register(&outboxCollector{
+		msgs:  msgs,
+		depth: prometheus.NewDesc("messaging_outbox_messages", "Outbound messages not yet handed to a provider, by status and channel.", []string{"status", "channel"}, nil),
+	})
+}
+
+func (c *outboxCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.depth }
+
+func (c *outboxCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxScrapeTimeout)
+	defer cancel()
+	depth, err := c.msgs.OutboxDepth(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.depth, err)
+		return
+	}
+	for _, d := range depth {
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(d.Count), string(d.Status), string(d.Channel))
+	}
+}
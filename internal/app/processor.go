@@ -2,7 +2,9 @@ package app
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,20 +14,24 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/rdavison/messaging-service/internal/config"
 	"github.com/rdavison/messaging-service/internal/db"
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/health"
+	"github.com/rdavison/messaging-service/internal/metrics"
 	"github.com/rdavison/messaging-service/internal/processor"
+	"github.com/rdavison/messaging-service/internal/repo"
 )
 
 func DefaultProcessor() {
-	logger := log.New(os.Stdout, "", log.LstdFlags)
-
 	cfg := config.MustLoad()
+	logger := newLogger(cfg)
 
 	rootCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	a, err := NewProcessor(rootCtx, cfg, logger)
 	if err != nil {
-		logger.Fatalf("init: %v", err)
+		logger.Error("init failed", "error", err)
+		os.Exit(1)
 	}
 	a.Start(rootCtx)
 
@@ -34,11 +40,16 @@ func DefaultProcessor() {
 	shCtx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout())
 	defer cancel()
 
-	logger.Println("shutting down...")
+	logger.Info("shutting down")
 	a.Shutdown(shCtx)
 }
 
-func NewProcessor(ctx context.Context, cfg config.Config, logger *log.Logger) (*appProcessor, error) {
+func NewProcessor(ctx context.Context, cfg config.Config, logger *slog.Logger) (*appProcessor, error) {
+	provRouter, limits, err := providerRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	dbCtx, cancel := context.WithTimeout(ctx, cfg.DBConnectTO)
 	defer cancel()
 
@@ -47,11 +58,29 @@ func NewProcessor(ctx context.Context, cfg config.Config, logger *log.Logger) (*
 		return nil, err
 	}
 
+	entry := processor.NewEntrypoint(pool, provRouter, logger, processorOptions(cfg, limits))
+	deliveries := processor.NewDeliveryWorker(pool, logger, deliveryOptions(cfg))
+
+	registerPoolMetrics(pool)
+	registerOutboxMetrics(repo.NewMessageRepo(pool))
+	stopTracing, err := startTracing(ctx, "messaging-processor", logger)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	pollLoop := pollLoopCheck(entry, cfg.HealthPollMaxAge)
 	h := chi.NewRouter()
-	h.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Do something more sophisticated
-		w.WriteHeader(http.StatusOK)
-	})
+	h.Use(metrics.Middleware)
+	h.Get("/healthz", providerHealth(provRouter))
+	h.Get("/livez", health.Handler(pollLoop))
+	h.Get("/readyz", health.Handler(
+		health.Database(pool),
+		health.Schema(pool),
+		pollLoop,
+		backlogCheck(repo.NewMessageRepo(pool), cfg.HealthBacklogMaxAge),
+	))
+	h.Method(http.MethodGet, "/metrics", metrics.Handler())
 
 	srv := &http.Server{
 		Addr:         cfg.Addr,
@@ -59,43 +88,161 @@ func NewProcessor(ctx context.Context, cfg config.Config, logger *log.Logger) (*
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
-		ErrorLog:     logger,
+		ErrorLog:     errorLog(logger),
 	}
 
-	provRouter := processor.DefaultRouter()
-	entry := processor.NewEntrypoint(pool, provRouter, logger)
-
 	return &appProcessor{
 		cfg:    cfg,
 		pool:   pool,
 		server: srv,
 		entry:  entry,
 		logger: logger,
+
+		deliveries:  deliveries,
+		stopTracing: stopTracing,
 	}, nil
 }
 
+// processorHealthResponse is served on the processor's /healthz. The status is
+// "degraded" while any provider's circuit breaker is not closed.
+type processorHealthResponse struct {
+	Status    string                             `json:"status"`
+	Providers map[string]processor.BreakerStatus `json:"providers"`
+}
+
+func providerHealth(engine *processor.RoutingEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := processorHealthResponse{Status: "ok", Providers: engine.Breakers()}
+		for _, b := range resp.Providers {
+			if b.State != processor.BreakerClosed {
+				resp.Status = "degraded"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// pollLoopCheck fails once the processor has neither claimed nor finished a
+// message for maxAge, e.g. because a send or the database hangs.
+func pollLoopCheck(entry *processor.Entrypoint, maxAge time.Duration) health.Check {
+	return health.Check{Name: "poll_loop", Run: func(ctx context.Context) (any, error) {
+		last := entry.LastProgress()
+		age := time.Since(last)
+		info := map[string]any{"last_poll": entry.LastPoll().UTC(), "last_progress": last.UTC(), "age_seconds": int(age.Seconds())}
+		if age > maxAge {
+			return info, fmt.Errorf("no progress for %s", age.Round(time.Second))
+		}
+		return info, nil
+	}}
+}
+
+// backlogCheck fails once a due message has waited longer than maxAge to be
+// sent.
+func backlogCheck(msgs *repo.MessageRepo, maxAge time.Duration) health.Check {
+	return health.Check{Name: "outbox_backlog", Run: func(ctx context.Context) (any, error) {
+		b, err := msgs.Backlog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if b.OldestDue != nil {
+			if age := time.Since(*b.OldestDue); age > maxAge {
+				return b, fmt.Errorf("oldest due message waiting for %s", age.Round(time.Second))
+			}
+		}
+		return b, nil
+	}}
+}
+
+func processorOptions(cfg config.Config, limits processor.RateLimits) processor.Options {
+	return processor.Options{
+		Workers:    cfg.ProcessorWorkers,
+		BatchSize:  cfg.ProcessorBatchSize,
+		Lease:      cfg.ProcessorLease,
+		Period:     cfg.ProcessorPeriod,
+		Backoff:    backoffPolicies(cfg.Backoff),
+		RateLimits: limits.Share(cfg.ProcessorReplicas),
+	}
+}
+
+func backoffPolicies(in map[string]config.Backoff) processor.BackoffPolicies {
+	out := processor.DefaultBackoffPolicies()
+	for ch, b := range in {
+		out.ByChannel[domain.Channel(ch)] = backoffPolicy(b)
+	}
+	return out
+}
+
+func backoffPolicy(b config.Backoff) processor.BackoffPolicy {
+	return processor.BackoffPolicy{
+		Base:        b.Base,
+		Multiplier:  b.Multiplier,
+		Jitter:      b.Jitter,
+		Cap:         b.Cap,
+		MaxAttempts: b.MaxAttempts,
+	}
+}
+
+func deliveryOptions(cfg config.Config) processor.DeliveryOptions {
+	return processor.DeliveryOptions{
+		Workers:      cfg.DeliveryWorkers,
+		Period:       cfg.DeliveryPeriod,
+		Timeout:      cfg.DeliveryTimeout,
+		Backoff:      backoffPolicy(cfg.DeliveryBackoff),
+		DisableAfter: cfg.SubscriptionDisableAfter,
+	}
+}
+
 func (a *appProcessor) Start(ctx context.Context) {
 	// start the server (for the health check)
 	go func() {
-		a.logger.Printf("listening on %s", a.server.Addr)
+		a.logger.Info("listening", "addr", a.server.Addr)
 		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			a.logger.Fatalf("http server: %v", err)
+			a.logger.Error("http server failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 
+	a.running.Add(2)
 	go func() {
+		defer a.running.Done()
 		if err := a.entry.Run(ctx); err != nil && ctx.Err() == nil {
-			a.logger.Printf("processor stopped: %v", err)
+			a.logger.Error("processor stopped", "error", err)
+		}
+	}()
+
+	go func() {
+		defer a.running.Done()
+		if err := a.deliveries.Run(ctx); err != nil && ctx.Err() == nil {
+			a.logger.Error("webhook delivery stopped", "error", err)
 		}
 	}()
+
+	go runIdempotencyCleanup(ctx, repo.NewMessageRepo(a.pool), a.cfg.IdempotencyRetention, a.cfg.IdempotencyCleanupInterval, a.logger)
 }
 func (a *appProcessor) Shutdown(ctx context.Context) {
 	// stop HTTP first to drain keep-alives
 	if err := a.server.Shutdown(ctx); err != nil {
-		a.logger.Printf("server shutdown error: %v", err)
+		a.logger.Error("server shutdown failed", "error", err)
+	}
+	// sends in flight are recorded before the pool goes away; ctx bounds the
+	// wait, after which their leases expire and they are sent again
+	done := make(chan struct{})
+	go func() {
+		a.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		a.logger.Error("processor shutdown timed out with sends in flight")
 	}
 	// DB pool closes after in-flight ops finish
 	a.pool.Close()
+	// flush the spans of the last requests
+	if err := a.stopTracing(ctx); err != nil {
+		a.logger.Error("tracing shutdown failed", "error", err)
+	}
 }
 
 func (a *appProcessor) ShutdownTimeout() time.Duration { return a.cfg.ShutdownAfter }
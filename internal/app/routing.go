@@ -0,0 +1,190 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/rdavison/messaging-service/internal/config"
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/processor"
+	"github.com/rdavison/messaging-service/internal/provider"
+)
+
+var (
+	phoneChannels = []domain.Channel{domain.ChannelSMS, domain.ChannelMMS}
+	emailChannels = []domain.Channel{domain.ChannelEmail}
+)
+
+// twilioLimits are the default budgets of a Twilio account: carriers throttle
+// long-code numbers to about one message a second.
+var twilioLimits = processor.ProviderLimits{Source: processor.RateLimit{Rate: 1, Burst: 1}}
+
+// providerRouter builds the routing engine and the providers' rate limits from
+// the ROUTING_CONFIG file. Without one, SMS/MMS go to Twilio and email to
+// SendGrid.
+func providerRouter(cfg config.Config) (*processor.RoutingEngine, processor.RateLimits, error) {
+	providers := map[string]processor.RouteProvider{
+		"twilio": {
+			Provider: provider.TwilioProvider{
+				AccountSID:     cfg.TwilioAccountSID,
+				AuthToken:      cfg.TwilioAuthToken,
+				BaseURL:        cfg.TwilioBaseURL,
+				StatusCallback: cfg.TwilioStatusCallback,
+			},
+			Channels: phoneChannels,
+		},
+		"sendgrid": {
+			Provider: provider.SendgridProvider{
+				APIKey:      cfg.SendgridAPIKey,
+				BaseURL:     cfg.SendgridBaseURL,
+				Subject:     cfg.SendgridSubject,
+				Attachments: cfg.SendgridAttachments,
+			},
+			Channels: emailChannels,
+		},
+	}
+
+	limits := processor.RateLimits{
+		ByProvider: map[string]processor.ProviderLimits{"twilio": twilioLimits},
+	}
+
+	if cfg.RoutingConfigPath == "" {
+		engine, err := processor.NewRoutingEngine(processor.RoutingConfig{
+			Rules: []processor.RouteRule{
+				{Name: "sms", Channels: phoneChannels, Providers: []processor.WeightedProvider{{Name: "twilio"}}},
+				{Name: "email", Channels: emailChannels, Providers: []processor.WeightedProvider{{Name: "sendgrid"}}},
+			},
+		}, providers)
+		return engine, limits, err
+	}
+
+	rt, err := config.LoadRouting(cfg.RoutingConfigPath)
+	if err != nil {
+		return nil, limits, err
+	}
+	for name, p := range rt.Providers {
+		if _, ok := providers[name]; ok {
+			return nil, limits, fmt.Errorf("routing config: provider %q is predefined", name)
+		}
+		rp, err := routeProvider(cfg, p)
+		if err != nil {
+			return nil, limits, fmt.Errorf("routing config: provider %q: %w", name, err)
+		}
+		providers[name] = rp
+		if p.Type == "twilio" {
+			limits.ByProvider[name] = twilioLimits
+		}
+	}
+	for name, rl := range rt.RateLimits {
+		if _, ok := providers[name]; !ok {
+			return nil, limits, fmt.Errorf("routing config: rate limits for unknown provider %q", name)
+		}
+		limits.ByProvider[name] = providerLimits(limits.ByProvider[name], rl)
+	}
+
+	rc := processor.RoutingConfig{
+		Breaker: processor.BreakerOptions{
+			ConsecutiveFailures: rt.FailoverAfter,
+			FailureRate:         rt.FailureRate,
+			Window:              rt.FailureWindow,
+			MinRequests:         rt.FailureMinRequests,
+			OpenFor:             rt.FailoverCooldown,
+		},
+	}
+	for _, r := range rt.Rules {
+		rule := processor.RouteRule{
+			Name:         r.Name,
+			CountryCodes: r.CountryCodes,
+			TenantIDs:    r.TenantIDs,
+			Failover:     r.Failover,
+		}
+		for _, ch := range r.Channels {
+			rule.Channels = append(rule.Channels, domain.Channel(ch))
+		}
+		for _, w := range r.Providers {
+			rule.Providers = append(rule.Providers, processor.WeightedProvider{Name: w.Name, Weight: w.Weight})
+		}
+		rc.Rules = append(rc.Rules, rule)
+	}
+	engine, err := processor.NewRoutingEngine(rc, providers)
+	return engine, limits, err
+}
+
+// twilioAuthTokens returns the auth tokens of the Twilio accounts messages
+// are sent from, by AccountSid: the default account and those in the
+// ROUTING_CONFIG file. Their webhooks are signed with these tokens.
+func twilioAuthTokens(cfg config.Config) (map[string]string, error) {
+	tokens := make(map[string]string)
+	if cfg.TwilioAccountSID != "" && cfg.TwilioAuthToken != "" {
+		tokens[cfg.TwilioAccountSID] = cfg.TwilioAuthToken
+	}
+	if cfg.RoutingConfigPath == "" {
+		return tokens, nil
+	}
+	rt, err := config.LoadRouting(cfg.RoutingConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	for name, p := range rt.Providers {
+		if p.Type != "twilio" || p.AccountSID == "" || p.AuthToken == "" {
+			continue
+		}
+		if t, ok := tokens[p.AccountSID]; ok && t != p.AuthToken {
+			return nil, fmt.Errorf("routing config: provider %q: another auth token is configured for account %s", name, p.AccountSID)
+		}
+		tokens[p.AccountSID] = p.AuthToken
+	}
+	return tokens, nil
+}
+
+// providerLimits overrides the limits in def that rl sets.
+func providerLimits(def processor.ProviderLimits, rl config.RoutingRateLimits) processor.ProviderLimits {
+	for _, o := range []struct {
+		dst *processor.RateLimit
+		src *config.RateLimit
+	}{
+		{&def.Provider, rl.Provider},
+		{&def.Source, rl.Source},
+		{&def.Tenant, rl.Tenant},
+	} {
+		if o.src != nil {
+			*o.dst = processor.RateLimit{Rate: o.src.Rate, Burst: o.src.Burst}
+		}
+	}
+	return def
+}
+
+// routeProvider creates an additional provider account from the routing file,
+// taking unset base URLs and options from the default account of its type.
+func routeProvider(cfg config.Config, p config.RoutingProvider) (processor.RouteProvider, error) {
+	switch p.Type {
+	case "twilio":
+		return processor.RouteProvider{
+			Provider: provider.TwilioProvider{
+				AccountSID:     p.AccountSID,
+				AuthToken:      p.AuthToken,
+				BaseURL:        orDefault(p.BaseURL, cfg.TwilioBaseURL),
+				StatusCallback: orDefault(p.StatusCallback, cfg.TwilioStatusCallback),
+			},
+			Channels: phoneChannels,
+		}, nil
+	case "sendgrid":
+		return processor.RouteProvider{
+			Provider: provider.SendgridProvider{
+				APIKey:      p.APIKey,
+				BaseURL:     orDefault(p.BaseURL, cfg.SendgridBaseURL),
+				Subject:     orDefault(p.Subject, cfg.SendgridSubject),
+				Attachments: orDefault(p.Attachments, cfg.SendgridAttachments),
+			},
+			Channels: emailChannels,
+		}, nil
+	default:
+		return processor.RouteProvider{}, fmt.Errorf("unknown type %q", p.Type)
+	}
+}
+
+func orDefault(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
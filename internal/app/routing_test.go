@@ -0,0 +1,46 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/config"
+)
+
+func TestTwilioAuthTokens(t *testing.T) {
+	t.Setenv("TEST_BACKUP_TOKEN", "backup-token")
+	path := filepath.Join(t.TempDir(), "routing.json")
+	err := os.WriteFile(path, []byte(`{
+  "providers": {
+    "twilio-backup": {"type": "twilio", "account_sid": "AC2", "auth_token": "${TEST_BACKUP_TOKEN}"},
+    "sendgrid-backup": {"type": "sendgrid", "api_key": "SG.key"}
+  }
+}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{TwilioAccountSID: "AC1", TwilioAuthToken: "default-token", RoutingConfigPath: path}
+
+	tokens, err := twilioAuthTokens(cfg)
+	if err != nil {
+		t.Fatalf("tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens["AC1"] != "default-token" || tokens["AC2"] != "backup-token" {
+		t.Fatalf("tokens = %v", tokens)
+	}
+
+	opts, err := apiOptions(cfg)
+	if err != nil {
+		t.Fatalf("api options: %v", err)
+	}
+	if opts.Webhooks.TwilioAuthToken != "default-token" || opts.Webhooks.TwilioAuthTokens["AC2"] != "backup-token" {
+		t.Fatalf("webhook secrets = %+v", opts.Webhooks)
+	}
+
+	// one account can not have two tokens
+	cfg.TwilioAccountSID = "AC2"
+	if _, err := twilioAuthTokens(cfg); err == nil {
+		t.Fatal("want an error for conflicting tokens")
+	}
+}
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/processor"
 )
 
 type Config struct {
@@ -13,6 +19,72 @@ type Config struct {
 	IdleTimeout   time.Duration
 	ShutdownAfter time.Duration
 	DBConnectTO   time.Duration
+
+	// logging
+	LogFormat string // "text" | "json"
+	LogLevel  string // "debug" | "info" | "warn" | "error"
+	LogRedact bool   // hide message bodies and phone numbers
+
+	// processor
+	ProcessorWorkers   int
+	ProcessorBatchSize int
+	ProcessorLease     time.Duration
+	ProcessorPeriod    time.Duration
+	ProcessorReplicas  int
+
+	// processor probes
+	HealthPollMaxAge    time.Duration // /livez fails once the last claim is older
+	HealthBacklogMaxAge time.Duration // /readyz fails once a due message waited longer
+
+	// provider routing file (see Routing); one provider per channel when empty
+	RoutingConfigPath string
+
+	// twilio
+	TwilioAccountSID     string
+	TwilioAuthToken      string
+	TwilioBaseURL        string
+	TwilioStatusCallback string
+
+	// sendgrid
+	SendgridAPIKey      string
+	SendgridBaseURL     string
+	SendgridSubject     string
+	SendgridAttachments string
+
+	// inbound webhook authentication
+	SendgridWebhookPublicKey       string
+	SendgridInboundBasicAuth       string
+	MessagingProviderWebhookSecret string
+	XillioWebhookSecret            string
+	WebhookTolerance               time.Duration
+	WebhookPublicURL               string
+	WebhookAllowUnsigned           bool
+
+	// origins, besides the API's own, allowed to open conversation WebSockets
+	WebSocketOrigins []string
+
+	// Idempotency-Key retention and how often expired keys are purged
+	IdempotencyRetention       time.Duration
+	IdempotencyCleanupInterval time.Duration
+
+	// retry backoff, keyed by channel ("sms", "mms", "email")
+	Backoff map[string]Backoff
+
+	// webhooks to subscriptions
+	DeliveryWorkers          int
+	DeliveryPeriod           time.Duration
+	DeliveryTimeout          time.Duration
+	DeliveryBackoff          Backoff // BACKOFF_WEBHOOK_*
+	SubscriptionDisableAfter int     // failed deliveries in a row; 0 never disables
+}
+
+// Backoff is the retry policy for one channel.
+type Backoff struct {
+	Base        time.Duration
+	Multiplier  float64
+	Jitter      float64
+	Cap         time.Duration
+	MaxAttempts int
 }
 
 func getenvWithDefault(key, def string) string {
@@ -31,7 +103,81 @@ func getenvWithDefaultDuration(key string, def time.Duration) time.Duration {
 	return def
 }
 
+func getenvWithDefaultInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getenvWithDefaultBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func getenvWithDefaultFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// getenvList reads a comma-separated list, dropping empty entries.
+func getenvList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// loadBackoff reads BACKOFF_<CHANNEL>_{BASE,MULTIPLIER,JITTER,CAP,MAX_ATTEMPTS}.
+// A base that is not positive or a multiplier below 1 is an error, since
+// retries would no longer back off.
+func loadBackoff(channel string, def processor.BackoffPolicy) (Backoff, error) {
+	prefix := "BACKOFF_" + strings.ToUpper(channel) + "_"
+	b := Backoff{
+		Base:        getenvWithDefaultDuration(prefix+"BASE", def.Base),
+		Multiplier:  getenvWithDefaultFloat(prefix+"MULTIPLIER", def.Multiplier),
+		Jitter:      getenvWithDefaultFloat(prefix+"JITTER", def.Jitter),
+		Cap:         getenvWithDefaultDuration(prefix+"CAP", def.Cap),
+		MaxAttempts: getenvWithDefaultInt(prefix+"MAX_ATTEMPTS", def.MaxAttempts),
+	}
+	if b.Base <= 0 {
+		return Backoff{}, fmt.Errorf("%sBASE must be positive, got %s", prefix, b.Base)
+	}
+	if b.Multiplier < 1 {
+		return Backoff{}, fmt.Errorf("%sMULTIPLIER must be at least 1, got %g", prefix, b.Multiplier)
+	}
+	return b, nil
+}
+
 func Load() (Config, error) {
+	// defaults are the processor's own
+	backoffs := processor.DefaultBackoffPolicies()
+	backoff := make(map[string]Backoff)
+	for _, ch := range []domain.Channel{domain.ChannelSMS, domain.ChannelMMS, domain.ChannelEmail} {
+		b, err := loadBackoff(string(ch), backoffs.For(ch))
+		if err != nil {
+			return Config{}, err
+		}
+		backoff[string(ch)] = b
+	}
+	deliveryBackoff, err := loadBackoff("webhook", processor.DefaultDeliveryOptions().Backoff)
+	if err != nil {
+		return Config{}, err
+	}
+
 	cfg := Config{
 		DatabaseURL:   os.Getenv("DATABASE_URL"),
 		Addr:          getenvWithDefault("ADDR", ":8080"),
@@ -40,6 +186,52 @@ func Load() (Config, error) {
 		IdleTimeout:   getenvWithDefaultDuration("IDLE_TIMEOUT", 60*time.Second),
 		ShutdownAfter: getenvWithDefaultDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
 		DBConnectTO:   getenvWithDefaultDuration("DB_CONNECT_TIMEOUT", 5*time.Second),
+
+		LogFormat: getenvWithDefault("LOG_FORMAT", "text"),
+		LogLevel:  getenvWithDefault("LOG_LEVEL", "info"),
+		LogRedact: getenvWithDefaultBool("LOG_REDACT", true),
+
+		ProcessorWorkers:   getenvWithDefaultInt("PROCESSOR_WORKERS", 4),
+		ProcessorBatchSize: getenvWithDefaultInt("PROCESSOR_BATCH_SIZE", 200),
+		ProcessorLease:     getenvWithDefaultDuration("PROCESSOR_LEASE", 60*time.Second),
+		ProcessorPeriod:    getenvWithDefaultDuration("PROCESSOR_POLL_INTERVAL", 2*time.Second),
+		ProcessorReplicas:  getenvWithDefaultInt("PROCESSOR_REPLICAS", 1),
+
+		HealthPollMaxAge:    getenvWithDefaultDuration("HEALTH_POLL_MAX_AGE", 5*time.Minute),
+		HealthBacklogMaxAge: getenvWithDefaultDuration("HEALTH_BACKLOG_MAX_AGE", 15*time.Minute),
+
+		RoutingConfigPath: os.Getenv("ROUTING_CONFIG"),
+
+		TwilioAccountSID:     os.Getenv("TWILIO_ACCOUNT_SID"),
+		TwilioAuthToken:      os.Getenv("TWILIO_AUTH_TOKEN"),
+		TwilioBaseURL:        getenvWithDefault("TWILIO_BASE_URL", "https://api.twilio.com"),
+		TwilioStatusCallback: os.Getenv("TWILIO_STATUS_CALLBACK_URL"),
+
+		SendgridAPIKey:      os.Getenv("SENDGRID_API_KEY"),
+		SendgridBaseURL:     getenvWithDefault("SENDGRID_BASE_URL", "https://api.sendgrid.com"),
+		SendgridSubject:     os.Getenv("SENDGRID_SUBJECT"),
+		SendgridAttachments: getenvWithDefault("SENDGRID_ATTACHMENTS", "inline"),
+
+		SendgridWebhookPublicKey:       os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY"),
+		SendgridInboundBasicAuth:       os.Getenv("SENDGRID_INBOUND_BASIC_AUTH"),
+		MessagingProviderWebhookSecret: os.Getenv("MESSAGING_PROVIDER_WEBHOOK_SECRET"),
+		XillioWebhookSecret:            os.Getenv("XILLIO_WEBHOOK_SECRET"),
+		WebhookTolerance:               getenvWithDefaultDuration("WEBHOOK_TOLERANCE", 5*time.Minute),
+		WebhookPublicURL:               os.Getenv("WEBHOOK_PUBLIC_URL"),
+		WebhookAllowUnsigned:           getenvWithDefaultBool("WEBHOOK_ALLOW_UNSIGNED", false),
+
+		WebSocketOrigins: getenvList("WEBSOCKET_ALLOWED_ORIGINS"),
+
+		IdempotencyRetention:       getenvWithDefaultDuration("IDEMPOTENCY_RETENTION", 24*time.Hour),
+		IdempotencyCleanupInterval: getenvWithDefaultDuration("IDEMPOTENCY_CLEANUP_INTERVAL", time.Hour),
+
+		Backoff: backoff,
+
+		DeliveryWorkers:          getenvWithDefaultInt("DELIVERY_WORKERS", 4),
+		DeliveryPeriod:           getenvWithDefaultDuration("DELIVERY_POLL_INTERVAL", 2*time.Second),
+		DeliveryTimeout:          getenvWithDefaultDuration("DELIVERY_TIMEOUT", 10*time.Second),
+		DeliveryBackoff:          deliveryBackoff,
+		SubscriptionDisableAfter: getenvWithDefaultInt("SUBSCRIPTION_DISABLE_AFTER", 20),
 	}
 	return cfg, nil
 }
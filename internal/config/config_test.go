@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/processor"
+)
+
+func TestLoadBackoffDefaults(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	def := processor.DefaultBackoffPolicies()
+	for ch, b := range cfg.Backoff {
+		if want := def.For(domain.Channel(ch)); processor.BackoffPolicy(b) != want {
+			t.Errorf("%s: got %+v, want %+v", ch, b, want)
+		}
+	}
+	if want := processor.DefaultDeliveryOptions().Backoff; processor.BackoffPolicy(cfg.DeliveryBackoff) != want {
+		t.Errorf("webhook: got %+v, want %+v", cfg.DeliveryBackoff, want)
+	}
+
+	t.Setenv("BACKOFF_SMS_BASE", "1s")
+	t.Setenv("BACKOFF_SMS_MULTIPLIER", "1.5")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if b := cfg.Backoff["sms"]; b.Base != time.Second || b.Multiplier != 1.5 {
+		t.Errorf("overrides not applied: %+v", b)
+	}
+}
+
+func TestLoadRejectsBadBackoff(t *testing.T) {
+	for _, tt := range []struct{ key, value string }{
+		{"BACKOFF_SMS_MULTIPLIER", "0"},
+		{"BACKOFF_EMAIL_MULTIPLIER", "0.5"},
+		{"BACKOFF_MMS_BASE", "0s"},
+		{"BACKOFF_WEBHOOK_BASE", "-1s"},
+	} {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), tt.key) {
+				t.Fatalf("%s=%s: got %v, want an error naming it", tt.key, tt.value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Routing is the provider routing file named by ROUTING_CONFIG. It is JSON:
+//
+//	{
+//	  "failover_after": 3,
+//	  "failover_cooldown": "1m",
+//	  "failure_rate": 0.5,
+//	  "providers": {
+//	    "twilio-backup": {"type": "twilio", "account_sid": "${TWILIO_BACKUP_SID}", "auth_token": "${TWILIO_BACKUP_TOKEN}"}
+//	  },
+//	  "rate_limits": {
+//	    "twilio": {"per_source": {"rate": 1}, "per_tenant": {"rate": 20, "burst": 40}},
+//	    "sendgrid": {"provider": {"rate": 100, "burst": 100}}
+//	  },
+//	  "rules": [
+//	    {"channels": ["sms", "mms"], "country_codes": ["44"], "providers": [{"name": "twilio-backup"}]},
+//	    {"channels": ["sms", "mms"], "providers": [{"name": "twilio", "weight": 80}, {"name": "twilio-backup", "weight": 20}]},
+//	    {"channels": ["email"], "providers": [{"name": "sendgrid"}]}
+//	  ]
+//	}
+//
+// The providers "twilio" and "sendgrid" always exist and use the TWILIO_* and
+// SENDGRID_* settings. String values in "providers" may reference environment
+// variables as ${NAME}, so secrets need not be written into the file.
+type Routing struct {
+	// circuit breaker of every provider
+	FailoverAfter      int           `json:"failover_after"`       // consecutive failures that open it
+	FailoverCooldown   time.Duration `json:"-"`                    // how long it stays open
+	FailureRate        float64       `json:"failure_rate"`         // share of failed sends that opens it
+	FailureWindow      int           `json:"failure_window"`       // sends the rate is computed over
+	FailureMinRequests int           `json:"failure_min_requests"` // sends before the rate applies
+
+	Providers  map[string]RoutingProvider   `json:"providers"`
+	RateLimits map[string]RoutingRateLimits `json:"rate_limits"` // by provider name
+	Rules      []RoutingRule                `json:"rules"`
+}
+
+// RoutingProvider defines an additional provider account. Only the fields of
+// its type apply.
+type RoutingProvider struct {
+	Type string `json:"type"` // "twilio" | "sendgrid"
+
+	// twilio
+	AccountSID     string `json:"account_sid"`
+	AuthToken      string `json:"auth_token"`
+	StatusCallback string `json:"status_callback"`
+
+	// sendgrid
+	APIKey      string `json:"api_key"`
+	Subject     string `json:"subject"`
+	Attachments string `json:"attachments"`
+
+	BaseURL string `json:"base_url"`
+}
+
+// RoutingRateLimits are the send budgets of a provider. Unset ones keep their
+// default; a rate of 0 removes the limit.
+type RoutingRateLimits struct {
+	Provider *RateLimit `json:"provider"`   // the account as a whole
+	Source   *RateLimit `json:"per_source"` // each sending number or address
+	Tenant   *RateLimit `json:"per_tenant"` // each tenant
+}
+
+// RateLimit is a token bucket of Rate sends per second in bursts of up to
+// Burst (default 1).
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RoutingRule sends matching messages to its providers. Empty match fields
+// match anything; the first matching rule wins.
+type RoutingRule struct {
+	Name         string          `json:"name"`
+	Channels     []string        `json:"channels"`
+	CountryCodes []string        `json:"country_codes"` // calling code prefixes of the target, without "+"
+	TenantIDs    []int64         `json:"tenant_ids"`
+	Providers    []RoutingWeight `json:"providers"`
+	Failover     []string        `json:"failover"` // tried in order when every provider is failing
+}
+
+// RoutingWeight is a provider and its share of a rule's traffic.
+type RoutingWeight struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"` // defaults to 1
+}
+
+// LoadRouting reads and parses a routing file.
+func LoadRouting(path string) (Routing, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Routing{}, fmt.Errorf("read routing config: %w", err)
+	}
+	var raw struct {
+		Routing
+		FailoverCooldown string `json:"failover_cooldown"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return Routing{}, fmt.Errorf("parse routing config %s: %w", path, err)
+	}
+	rt := raw.Routing
+	if raw.FailoverCooldown != "" {
+		if rt.FailoverCooldown, err = time.ParseDuration(raw.FailoverCooldown); err != nil {
+			return Routing{}, fmt.Errorf("parse routing config %s: failover_cooldown: %w", path, err)
+		}
+	}
+	for name, p := range rt.Providers {
+		for _, s := range []*string{&p.AccountSID, &p.AuthToken, &p.StatusCallback, &p.APIKey, &p.Subject, &p.Attachments, &p.BaseURL} {
+			*s = os.ExpandEnv(*s)
+		}
+		rt.Providers[name] = p
+	}
+	return rt, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadRouting(t *testing.T) {
+	t.Setenv("TEST_BACKUP_TOKEN", "s3cret")
+	path := filepath.Join(t.TempDir(), "routing.json")
+	err := os.WriteFile(path, []byte(`{
+  "failover_after": 5,
+  "failover_cooldown": "30s",
+  "rate_limits": {"twilio": {"per_source": {"rate": 1}, "per_tenant": {"rate": 20, "burst": 40}}},
+  "providers": {"twilio-backup": {"type": "twilio", "account_sid": "AC1", "auth_token": "${TEST_BACKUP_TOKEN}"}},
+  "rules": [{"channels": ["sms"], "country_codes": ["44"], "providers": [{"name": "twilio", "weight": 2}, {"name": "twilio-backup"}]}]
+}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := LoadRouting(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if rt.FailoverAfter != 5 || rt.FailoverCooldown != 30*time.Second {
+		t.Errorf("failover: %d %s", rt.FailoverAfter, rt.FailoverCooldown)
+	}
+	if got := rt.Providers["twilio-backup"].AuthToken; got != "s3cret" {
+		t.Errorf("auth_token not expanded: %q", got)
+	}
+	if rl := rt.RateLimits["twilio"]; rl.Source == nil || rl.Source.Rate != 1 || rl.Tenant == nil || rl.Tenant.Burst != 40 || rl.Provider != nil {
+		t.Errorf("rate_limits: %+v", rl)
+	}
+	if len(rt.Rules) != 1 || rt.Rules[0].Providers[0].Weight != 2 || rt.Rules[0].CountryCodes[0] != "44" {
+		t.Errorf("rules: %+v", rt.Rules)
+	}
+}
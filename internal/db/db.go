@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
 func NewPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
@@ -11,5 +13,6 @@ func NewPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg.ConnConfig.Tracer = tracing.QueryTracer{}
 	return pgxpool.NewWithConfig(ctx, cfg)
 }
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Batch is a group of outbound messages created by one batch send request.
+type Batch struct {
+	ID        int64
+	TenantID  int64
+	Total     int            // recipients in the request
+	Rejected  int            // recipients that failed validation and got no message
+	Statuses  map[Status]int // messages per current status
+	CreatedAt time.Time
+}
+
+// Accepted is the number of messages the batch created.
+func (b Batch) Accepted() int { return b.Total - b.Rejected }
+
+// Pending is the number of messages not yet in a terminal status.
+func (b Batch) Pending() int {
+	n := 0
+	for st, c := range b.Statuses {
+		if !IsStatusTerminal(st) {
+			n += c
+		}
+	}
+	return n
+}
+
+func (b Batch) MarshalJSON() ([]byte, error) {
+	statuses := b.Statuses
+	if statuses == nil {
+		statuses = map[Status]int{}
+	}
+	return json.Marshal(struct {
+		ID        string         `json:"id"`
+		Total     int            `json:"total"`
+		Accepted  int            `json:"accepted"`
+		Rejected  int            `json:"rejected"`
+		Pending   int            `json:"pending"`
+		Statuses  map[Status]int `json:"statuses"`
+		CreatedAt time.Time      `json:"created_at"`
+	}{
+		ID:        strconv.FormatInt(b.ID, 10),
+		Total:     b.Total,
+		Accepted:  b.Accepted(),
+		Rejected:  b.Rejected,
+		Pending:   b.Pending(),
+		Statuses:  statuses,
+		CreatedAt: b.CreatedAt,
+	})
+}
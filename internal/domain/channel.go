@@ -0,0 +1,25 @@
+package domain
+
+// Channel is the delivery channel of a message, matching the channel enum in
+// the database.
+type Channel string
+
+const (
+	ChannelSMS   Channel = "sms"
+	ChannelMMS   Channel = "mms"
+	ChannelEmail Channel = "email"
+)
+
+func (c Channel) String() string { return string(c) }
+
+// ChannelOf returns the channel a message with the given source endpoint is
+// delivered on.
+func ChannelOf(e Endpoint) Channel {
+	if e.Kind == EndpointKindEmail {
+		return ChannelEmail
+	}
+	if e.Channel != nil && *e.Channel == PhoneChannelMMS {
+		return ChannelMMS
+	}
+	return ChannelSMS
+}
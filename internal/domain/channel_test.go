@@ -0,0 +1,20 @@
+package domain
+
+import "testing"
+
+func TestChannelOf(t *testing.T) {
+	sms, mms := PhoneChannelSMS, PhoneChannelMMS
+	cases := []struct {
+		e    Endpoint
+		want Channel
+	}{
+		{Endpoint{Kind: EndpointKindPhone, Channel: &sms}, ChannelSMS},
+		{Endpoint{Kind: EndpointKindPhone, Channel: &mms}, ChannelMMS},
+		{Endpoint{Kind: EndpointKindEmail}, ChannelEmail},
+	}
+	for _, c := range cases {
+		if got := ChannelOf(c.e); got != c.want {
+			t.Fatalf("ChannelOf(%+v) = %q, want %q", c.e, got, c.want)
+		}
+	}
+}
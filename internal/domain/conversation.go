@@ -25,6 +25,7 @@ type ConversationJSON struct {
 
 type Conversation struct {
 	ID        int64
+	TenantID  int64
 	Source    Endpoint
 	Target    Endpoint
 	CreatedAt time.Time
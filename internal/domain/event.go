@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// Actor is who changed a message's status.
+type Actor string
+
+const (
+	ActorAPI       Actor = "api"       // a client request, e.g. a send or a cancel
+	ActorProcessor Actor = "processor" // the processor dispatching the message
+	ActorProvider  Actor = "provider"  // a provider webhook, e.g. an inbound message or a delivery callback
+)
+
+// MessageEvent is one entry of a message's delivery timeline: a status change
+// or a delivery attempt.
+type MessageEvent struct {
+	ID                int64     `json:"id"`
+	MessageID         int64     `json:"message_id"`
+	FromStatus        *Status   `json:"from_status"` // null for the creation of the message
+	ToStatus          Status    `json:"to_status"`
+	ProviderID        string    `json:"provider_id,omitempty"`
+	ProviderMessageID string    `json:"provider_message_id,omitempty"`
+	Payload           *string   `json:"payload,omitempty"` // the provider's response or the error
+	Attempt           int       `json:"attempt"`           // attempts made so far
+	Actor             Actor     `json:"actor"`
+	At                time.Time `json:"at"`
+}
@@ -9,9 +9,25 @@ type ProviderRef struct {
 	MessageID string `json:"message_id"` // provider-assigned message id
 }
 
+// TemplateRef identifies the template version a message body was rendered from.
+type TemplateRef struct {
+	ID      int64 `json:"id"`
+	Version int   `json:"version"`
+}
+
+// Attempt records the outcome of one outbound delivery attempt.
+type Attempt struct {
+	Number   int       `json:"number"`
+	At       time.Time `json:"at"`
+	Status   Status    `json:"status"`
+	Provider string    `json:"provider,omitempty"`
+	Payload  *string   `json:"payload,omitempty"`
+}
+
 // Message is the domain entity used throughout business logic and APIs.
 type Message struct {
 	ID             int64             `json:"id"`
+	TenantID       int64             `json:"-"`
 	ConversationID int64             `json:"conversation_id"`
 	Source         Endpoint          `json:"source"`
 	Target         Endpoint          `json:"target"`
@@ -22,6 +38,16 @@ type Message struct {
 	Status         Status            `json:"status"`
 	StatusPayload  *string           `json:"status_payload,omitempty"`
 	Provider       *ProviderRef      `json:"provider,omitempty"`
+	AttemptCount   int               `json:"attempt_count"`
+	NextAttemptAt  *time.Time        `json:"next_attempt_at,omitempty"`
+	Attempts       []Attempt         `json:"attempts,omitempty"`
+	SendAt         *time.Time        `json:"send_at,omitempty"` // requested dispatch time of a scheduled message
+	BatchID        *int64            `json:"batch_id,omitempty"`
+	TemplateRef    *TemplateRef      `json:"template,omitempty"` // template the body was rendered from
+	Traceparent    string            `json:"-"`                  // W3C trace context of the request that created it
 	CreatedAt      time.Time         `json:"created_at"`
 	UpdatedAt      time.Time         `json:"updated_at"`
 }
+
+// Channel returns the channel the message is delivered on.
+func (m Message) Channel() Channel { return ChannelOf(m.Source) }
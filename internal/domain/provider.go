@@ -15,3 +15,13 @@ func (p Provider) String() string { return string(p) }
 func AllProviders() []Provider {
 	return []Provider{ProviderTwilio, ProviderSendgrid, ProviderMessagingProvider, ProviderXillio}
 }
+
+// ParseProvider returns the known provider named s.
+func ParseProvider(s string) (Provider, bool) {
+	for _, p := range AllProviders() {
+		if p.String() == s {
+			return p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrMissingVariable is returned by Render for a placeholder without a value.
+	ErrMissingVariable = errors.New("missing variable")
+	// ErrBadTemplate is returned by ParsePlaceholders for malformed text.
+	ErrBadTemplate = errors.New("bad template")
+)
+
+// placeholderRe matches "{{name}}", with optional spaces inside the braces.
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Render substitutes every {{name}} placeholder in text with vars[name].
+// Values are inserted as-is; text without placeholders is returned unchanged.
+func Render(text string, vars map[string]string) (string, error) {
+	var missing string
+	out := placeholderRe.ReplaceAllStringFunc(text, func(ph string) string {
+		name := placeholderRe.FindStringSubmatch(ph)[1]
+		v, ok := vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("%w %q", ErrMissingVariable, missing)
+	}
+	return out, nil
+}
+
+// ParsePlaceholders returns the distinct placeholder names in text, in order of
+// first use. Any "{{" that does not open a well-formed placeholder is an error,
+// so typos are caught when a template is saved rather than when it is sent.
+func ParsePlaceholders(text string) ([]string, error) {
+	locs := placeholderRe.FindAllStringSubmatchIndex(text, -1)
+	if strings.Count(text, "{{") != len(locs) {
+		return nil, fmt.Errorf("%w: unterminated or invalid placeholder", ErrBadTemplate)
+	}
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(locs))
+	for _, l := range locs {
+		name := text[l[2]:l[3]]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
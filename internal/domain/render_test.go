@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	got, err := Render("Hi {{name}}, your code is {{ code }}. {{name}}!", map[string]string{"name": "Ann", "code": "42"})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if want := "Hi Ann, your code is 42. Ann!"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if got, err := Render("no placeholders {here}", nil); err != nil || got != "no placeholders {here}" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if _, err := Render("Hi {{name}}", map[string]string{"nom": "Ann"}); !errors.Is(err, ErrMissingVariable) {
+		t.Fatalf("want ErrMissingVariable, got %v", err)
+	}
+}
+
+func TestParsePlaceholders(t *testing.T) {
+	got, err := ParsePlaceholders("Hi {{name}}, code {{ code }} for {{name}}")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(got) != 2 || got[0] != "name" || got[1] != "code" {
+		t.Fatalf("got %v", got)
+	}
+	for _, text := range []string{"Hi {{name", "Hi {{ first name }}", "{{1st}}"} {
+		if _, err := ParsePlaceholders(text); !errors.Is(err, ErrBadTemplate) {
+			t.Errorf("%q: want ErrBadTemplate, got %v", text, err)
+		}
+	}
+}
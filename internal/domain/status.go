@@ -7,8 +7,77 @@ const (
 	StatusRetry  Status = "retry"
 	StatusOK     Status = "ok"
 	StatusFailed Status = "failed"
+
+	// An outbound message waiting for its send_at; it becomes StatusOutbox
+	// when the processor picks it up.
+	StatusScheduled Status = "scheduled"
+
+	// An outbound message withdrawn by the client before it was dispatched.
+	StatusCanceled Status = "canceled"
+
+	// Reported asynchronously by provider delivery callbacks after StatusOK.
+	StatusSent        Status = "sent"
+	StatusDelivered   Status = "delivered"
+	StatusUndelivered Status = "undelivered"
+	StatusBounced     Status = "bounced"
 )
 
+func AllStatuses() []Status {
+	return []Status{
+		StatusScheduled, StatusOutbox, StatusRetry, StatusOK, StatusFailed, StatusCanceled,
+		StatusSent, StatusDelivered, StatusUndelivered, StatusBounced,
+	}
+}
+
+// ParseStatus returns the known status named s.
+func ParseStatus(s string) (Status, bool) {
+	for _, st := range AllStatuses() {
+		if string(st) == s {
+			return st, true
+		}
+	}
+	return "", false
+}
+
 func IsStatusTerminal(status Status) bool {
-	return status == StatusOK || status == StatusFailed
+	switch status {
+	case StatusOK, StatusFailed, StatusCanceled, StatusSent, StatusDelivered, StatusUndelivered, StatusBounced:
+		return true
+	}
+	return false
+}
+
+// deliveryRank orders the statuses a delivery callback may move an accepted
+// message through. Callbacks can arrive out of order, so a message only ever
+// moves to a higher rank.
+var deliveryRank = map[Status]int{
+	StatusOK:          1,
+	StatusSent:        2,
+	StatusDelivered:   3,
+	StatusUndelivered: 3,
+	StatusBounced:     3,
+	StatusFailed:      3,
+}
+
+// CanAdvance reports whether a delivery callback may move a message from one
+// status to another.
+func CanAdvance(from, to Status) bool {
+	rf, ok := deliveryRank[from]
+	if !ok {
+		return false
+	}
+	rt, ok := deliveryRank[to]
+	return ok && rt > rf
+}
+
+// AdvanceableFrom lists the statuses a message may be in for a delivery
+// callback to move it to status to.
+func AdvanceableFrom(to Status) []Status {
+	var out []Status
+	for from := range deliveryRank {
+		if CanAdvance(from, to) {
+			out = append(out, from)
+		}
+	}
+	return out
 }
@@ -0,0 +1,26 @@
+package domain
+
+import "testing"
+
+func TestCanAdvance(t *testing.T) {
+	cases := []struct {
+		from, to Status
+		want     bool
+	}{
+		{StatusOK, StatusSent, true},
+		{StatusOK, StatusDelivered, true},
+		{StatusSent, StatusDelivered, true},
+		{StatusSent, StatusBounced, true},
+		{StatusDelivered, StatusSent, false}, // late "sent" after "delivered"
+		{StatusDelivered, StatusUndelivered, false},
+		{StatusOutbox, StatusDelivered, false}, // never accepted by a provider
+		{StatusRetry, StatusSent, false},
+		{StatusOK, StatusOutbox, false},
+		{StatusCanceled, StatusDelivered, false}, // never handed to a provider
+	}
+	for _, c := range cases {
+		if got := CanAdvance(c.from, c.to); got != c.want {
+			t.Fatalf("CanAdvance(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package domain
+
+import "time"
+
+// EventType names a lifecycle event a Subscription can receive.
+type EventType string
+
+const (
+	EventMessageReceived     EventType = "message.received"     // an inbound message arrived
+	EventMessageSent         EventType = "message.sent"         // a provider accepted an outbound message
+	EventMessageFailed       EventType = "message.failed"       // an outbound message failed, bounced or was not delivered
+	EventConversationCreated EventType = "conversation.created" // the first message between two endpoints
+)
+
+func EventTypes() []EventType {
+	return []EventType{EventMessageReceived, EventMessageSent, EventMessageFailed, EventConversationCreated}
+}
+
+// ParseEventType returns the known event type named s.
+func ParseEventType(s string) (EventType, bool) {
+	for _, t := range EventTypes() {
+		if string(t) == s {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// Subscription is a tenant's webhook endpoint. Each event of one of its
+// types is POSTed to URL, signed with Secret. It is disabled after too many
+// failed deliveries in a row.
+type Subscription struct {
+	ID                  int64       `json:"id"`
+	TenantID            int64       `json:"-"`
+	URL                 string      `json:"url"`
+	Secret              string      `json:"secret,omitempty"` // only returned when the subscription is created
+	Events              []EventType `json:"events"`
+	Enabled             bool        `json:"enabled"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	DisabledAt          *time.Time  `json:"disabled_at,omitempty"`
+	DisabledReason      *string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time   `json:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+}
+
+// DeliveryStatus is the state of a Delivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // waiting for its first or next attempt
+	DeliveryDelivered DeliveryStatus = "delivered" // the endpoint answered 2xx
+	DeliveryFailed    DeliveryStatus = "failed"    // every attempt failed
+)
+
+// Delivery is one event queued for one subscription.
+type Delivery struct {
+	ID             int64             `json:"id"`
+	SubscriptionID int64             `json:"subscription_id"`
+	EventType      EventType         `json:"event_type"`
+	MessageID      *int64            `json:"message_id,omitempty"`
+	MessageEventID *int64            `json:"message_event_id,omitempty"`
+	ConversationID *int64            `json:"conversation_id,omitempty"`
+	Status         DeliveryStatus    `json:"status"`
+	AttemptCount   int               `json:"attempt_count"`
+	NextAttemptAt  *time.Time        `json:"next_attempt_at,omitempty"` // while pending
+	Attempts       []DeliveryAttempt `json:"attempts"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// DeliveryAttempt records one POST of a Delivery.
+type DeliveryAttempt struct {
+	Number     int       `json:"number"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"` // 0 if no response was received
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// OK reports whether the endpoint accepted the delivery.
+func (a DeliveryAttempt) OK() bool { return a.Error == "" && a.StatusCode/100 == 2 }
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Template is one version of a named message body with {{variable}}
+// placeholders. Editing a template adds a version; messages record the
+// version they were rendered from.
+type Template struct {
+	ID        int64
+	TenantID  int64
+	Name      string
+	Channel   Channel
+	Version   int
+	Body      string
+	Variables []string // placeholders used by Body, see ParsePlaceholders
+	CreatedAt time.Time
+	UpdatedAt time.Time // when this version was added
+}
+
+func (t Template) MarshalJSON() ([]byte, error) {
+	vars := t.Variables
+	if vars == nil {
+		vars = []string{}
+	}
+	return json.Marshal(struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`
+		Channel   Channel   `json:"channel"`
+		Version   int       `json:"version"`
+		Body      string    `json:"body"`
+		Variables []string  `json:"variables"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}{
+		ID:        strconv.FormatInt(t.ID, 10),
+		Name:      t.Name,
+		Channel:   t.Channel,
+		Version:   t.Version,
+		Body:      t.Body,
+		Variables: vars,
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+	})
+}
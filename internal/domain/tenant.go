@@ -0,0 +1,10 @@
+package domain
+
+// DefaultTenantID owns data created before tenancy existed, and inbound
+// messages that cannot be matched to an existing conversation.
+const DefaultTenantID int64 = 1
+
+type Tenant struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,108 @@
+// Package health serves liveness and readiness probes as JSON, with the
+// outcome of every check.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusFail Status = "fail"
+)
+
+// checkTimeout bounds each check, so a hung dependency fails its check
+// rather than the probe.
+const checkTimeout = 2 * time.Second
+
+// Check reports on one dependency. Info, if not nil, is shown next to the
+// check's status whether or not it failed.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (info any, err error)
+}
+
+type Result struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Info   any    `json:"info,omitempty"`
+}
+
+// Response is the body of a probe. Status is "fail" if any check failed.
+type Response struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Handler runs checks on every request and responds 200, or 503 if any of
+// them failed.
+func Handler(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := Run(r.Context(), checks...)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if resp.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Run runs checks concurrently.
+func Run(ctx context.Context, checks ...Check) Response {
+	resp := Response{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			info, err := c.Run(ctx)
+			results[i] = Result{Status: StatusOK, Info: info}
+			if err != nil {
+				results[i].Status, results[i].Error = StatusFail, err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	for i, c := range checks {
+		resp.Checks[c.Name] = results[i]
+		if results[i].Status != StatusOK {
+			resp.Status = StatusFail
+		}
+	}
+	return resp
+}
+
+// Database pings the database through the pool.
+func Database(pool *pgxpool.Pool) Check {
+	return Check{Name: "database", Run: func(ctx context.Context) (any, error) {
+		s := pool.Stat()
+		info := map[string]int32{
+			"total_conns":    s.TotalConns(),
+			"acquired_conns": s.AcquiredConns(),
+			"idle_conns":     s.IdleConns(),
+		}
+		return info, pool.Ping(ctx)
+	}}
+}
+
+// Schema checks that the migrations this build depends on have been applied.
+func Schema(pool *pgxpool.Pool) Check {
+	schema := repo.NewSchemaRepo(pool)
+	return Check{Name: "schema", Run: func(ctx context.Context) (any, error) {
+		return map[string]string{"want": repo.SchemaVersion}, schema.Check(ctx)
+	}}
+}
@@ -0,0 +1,34 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := Check{Name: "ok", Run: func(context.Context) (any, error) { return map[string]int{"n": 1}, nil }}
+	bad := Check{Name: "bad", Run: func(context.Context) (any, error) { return nil, errors.New("down") }}
+
+	rec := httptest.NewRecorder()
+	Handler(ok)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(ok, bad)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503, got %d", rec.Code)
+	}
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Status != StatusFail || resp.Checks["ok"].Status != StatusOK || resp.Checks["bad"].Error != "down" {
+		t.Fatalf("got %+v", resp)
+	}
+}
@@ -0,0 +1,179 @@
+// Package logging sets up structured logging with log/slog. Lines logged with
+// a context carry the request id, trace id and any fields added to that
+// context, and message bodies and phone numbers can be redacted.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options configures New.
+type Options struct {
+	Format string     // "text" (default) or "json"
+	Level  slog.Level // lines below it are dropped
+	Redact bool       // replace bodies and mask phone numbers
+}
+
+// ParseLevel reads "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("log level %q: %w", s, err)
+	}
+	return l, nil
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, opts Options) *slog.Logger {
+	ho := &slog.HandlerOptions{Level: opts.Level}
+	if opts.Redact {
+		ho.ReplaceAttr = redact
+	}
+	var h slog.Handler
+	if strings.EqualFold(opts.Format, "json") {
+		h = slog.NewJSONHandler(w, ho)
+	} else {
+		h = slog.NewTextHandler(w, ho)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// contextHandler adds the fields carried by the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		r.AddAttrs(f.attrs()...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type fieldsKey struct{}
+
+// fields are the attributes a context carries. Annotate adds to them in
+// place, so that code further down a request can add to the request's own
+// log line.
+type fields struct {
+	parent *fields
+
+	mu  sync.Mutex
+	own []slog.Attr
+}
+
+func (f *fields) add(attrs []slog.Attr) {
+	f.mu.Lock()
+	f.own = append(f.own, attrs...)
+	f.mu.Unlock()
+}
+
+func (f *fields) attrs() []slog.Attr {
+	if f == nil {
+		return nil
+	}
+	out := f.parent.attrs()
+	f.mu.Lock()
+	out = append(out, f.own...)
+	f.mu.Unlock()
+	return out
+}
+
+// With returns a context whose log lines carry args, given as for
+// slog.Logger.With, in addition to those of ctx.
+func With(ctx context.Context, args ...any) context.Context {
+	parent, _ := ctx.Value(fieldsKey{}).(*fields)
+	f := &fields{parent: parent}
+	f.add(argsToAttrs(args))
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// Annotate adds args to the fields of ctx in place, so that they also appear
+// on lines logged with contexts ctx was derived from, such as the request
+// line written by Middleware. It does nothing for a context without fields.
+func Annotate(ctx context.Context, args ...any) {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.add(argsToAttrs(args))
+	}
+}
+
+func argsToAttrs(args []any) []slog.Attr {
+	var r slog.Record
+	r.Add(args...)
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return attrs
+}
+
+// bodyKeys are attributes holding message content.
+var bodyKeys = map[string]bool{"body": true, "variables": true}
+
+// phoneRe matches E.164 phone numbers within text.
+var phoneRe = regexp.MustCompile(`\+?[1-9][0-9]{6,14}\b`)
+
+// phoneKeys are attributes whose value is an endpoint, which may be a phone
+// number written without "+".
+var phoneKeys = map[string]bool{"from": true, "to": true, "source": true, "target": true}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if bodyKeys[a.Key] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	s := a.Value.String()
+	if phoneKeys[a.Key] && phoneRe.FindString(s) == s {
+		return slog.String(a.Key, MaskPhone(s))
+	}
+	if strings.ContainsRune(s, '+') {
+		s = phoneRe.ReplaceAllStringFunc(s, func(m string) string {
+			if !strings.HasPrefix(m, "+") {
+				return m
+			}
+			return MaskPhone(m)
+		})
+		return slog.String(a.Key, s)
+	}
+	return a
+}
+
+// MaskPhone keeps the "+" and the last four digits of a phone number.
+func MaskPhone(p string) string {
+	plus := strings.HasPrefix(p, "+")
+	digits := strings.TrimPrefix(p, "+")
+	if len(digits) <= 4 {
+		return p
+	}
+	masked := strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	if plus {
+		return "+" + masked
+	}
+	return masked
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestRedactAndContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{Format: "json", Level: slog.LevelInfo, Redact: true})
+
+	ctx := With(context.Background(), "message_id", int64(42))
+	Annotate(ctx, "provider_id", "twilio")
+	logger.InfoContext(ctx, "sent",
+		"body", "your code is 1234",
+		"to", "15551234567",
+		"error", "invalid number +15557654321",
+	)
+	logger.DebugContext(ctx, "dropped below level")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want one JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"msg":         "sent",
+		"message_id":  float64(42),
+		"provider_id": "twilio",
+		"body":        "[REDACTED]",
+		"to":          "*******4567",
+		"error":       "invalid number +*******4321",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s: got %v, want %v", k, line[k], v)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("warn"); err != nil || l != slog.LevelWarn {
+		t.Fatalf("got %v, %v", l, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("want error")
+	}
+}
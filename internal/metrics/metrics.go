@@ -0,0 +1,40 @@
+// Package metrics holds the Prometheus registry the service's instruments
+// are registered with, and serves it.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default is the registry the service's instruments are registered with and
+// served from. It includes the Go runtime and process collectors.
+var Default = newRegistry()
+
+// Factory creates instruments registered with Default.
+var Factory = promauto.With(Default)
+
+// DefBuckets are histogram buckets in seconds for request and send
+// latencies.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// Handler serves every metric registered with Default. A collector that
+// fails is left out rather than failing the scrape.
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(Default, promhttp.HandlerFor(Default, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+}
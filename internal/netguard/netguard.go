@@ -0,0 +1,133 @@
+// Package netguard builds HTTP clients for fetching URLs supplied by API
+// clients, such as attachments and webhook endpoints, so that a request can
+// not be pointed at the service's own network.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// MaxRedirects is how many redirects a Client follows.
+const MaxRedirects = 3
+
+// ErrForbidden is wrapped by the errors of requests a Client refuses to make.
+var ErrForbidden = errors.New("netguard: destination not allowed")
+
+// Options configures a Client.
+type Options struct {
+	Timeout   time.Duration
+	AllowHTTP bool // plain http is refused unless set
+}
+
+// nonPublic lists the ranges not covered by the netip.Addr predicates that
+// must not be reached either.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64 of any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+	netip.MustParsePrefix("2002::/16"),      // 6to4, which embeds any IPv4 address
+	netip.MustParsePrefix("2001::/32"),      // Teredo, likewise
+	netip.MustParsePrefix("100::/64"),       // discard-only
+}
+
+// IsPublic reports whether ip may be dialed: loopback, private (including
+// IPv6 unique local), link-local, multicast, unspecified and reserved
+// addresses may not.
+func IsPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// control is a net.Dialer Control function refusing non-public addresses.
+// It runs on the address actually dialed, after name resolution, so a host
+// name that resolves differently on a second lookup can not get past it.
+func control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrForbidden, network, address)
+	}
+	if !IsPublic(ap.Addr()) {
+		return fmt.Errorf("%w: %s is not a public address", ErrForbidden, ap.Addr())
+	}
+	return nil
+}
+
+// schemeGuard refuses requests, including redirects, to other schemes than
+// https, or http when allowed.
+type schemeGuard struct {
+	next      http.RoundTripper
+	allowHTTP bool
+}
+
+func (g schemeGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Scheme {
+	case "https":
+	case "http":
+		if !g.allowHTTP {
+			return nil, fmt.Errorf("%w: http is not allowed, use https", ErrForbidden)
+		}
+	default:
+		return nil, fmt.Errorf("%w: scheme %q", ErrForbidden, req.URL.Scheme)
+	}
+	return g.next.RoundTrip(req)
+}
+
+// Client returns an HTTP client that only connects to public addresses,
+// ignores proxy settings (a proxy would dial on its behalf) and follows at
+// most MaxRedirects redirects.
+func Client(opts Options) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: schemeGuard{next: tr, allowHTTP: opts.AllowHTTP},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > MaxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrForbidden, MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// CheckHost reports an error for a host that is a non-public IP literal or
+// a name that can only be local, so that obviously bad URLs are refused when
+// they are given rather than when they are fetched. Names are not resolved:
+// the Client checks the addresses it dials.
+func CheckHost(host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !IsPublic(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrForbidden, ip)
+		}
+		return nil
+	}
+	h := strings.ToLower(strings.TrimRight(host, "."))
+	if h == "localhost" || strings.HasSuffix(h, ".localhost") || strings.HasSuffix(h, ".local") || strings.HasSuffix(h, ".internal") {
+		return fmt.Errorf("%w: %s is a local name", ErrForbidden, host)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package netguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::7f00:1", false},
+	} {
+		if got := IsPublic(netip.MustParseAddr(tc.ip)); got != tc.want {
+			t.Errorf("IsPublic(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "169.254.169.254", "localhost", "LOCALHOST.", "db.internal", "printer.local"} {
+		if err := CheckHost(host); !errors.Is(err, ErrForbidden) {
+			t.Errorf("CheckHost(%q) = %v, want ErrForbidden", host, err)
+		}
+	}
+	for _, host := range []string{"example.com", "93.184.216.34"} {
+		if err := CheckHost(host); err != nil {
+			t.Errorf("CheckHost(%q) = %v", host, err)
+		}
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the server")
+	}))
+	defer srv.Close()
+
+	_, err := Client(Options{}).Get(srv.URL)
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestClientRefusesHTTP(t *testing.T) {
+	_, err := Client(Options{}).Get("http://example.com/")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestClientCapsRedirects(t *testing.T) {
+	hops := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, "/next", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// the test server is on loopback, so only the redirect policy is checked
+	c := srv.Client()
+	c.CheckRedirect = Client(Options{}).CheckRedirect
+	_, err := c.Get(srv.URL)
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("err = %v, want ErrForbidden", err)
+	}
+	if hops != MaxRedirects+1 {
+		t.Fatalf("hops = %d, want %d", hops, MaxRedirects+1)
+	}
+}
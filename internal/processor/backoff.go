@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// BackoffPolicy decides when a retry is attempted again and when to give up.
+type BackoffPolicy struct {
+	Base        time.Duration // delay after the first failed attempt
+	Multiplier  float64       // growth factor per further attempt
+	Jitter      float64       // fraction of the delay randomized in either direction, 0..1
+	Cap         time.Duration // upper bound on any single delay
+	MaxAttempts int           // attempts after which the message is failed
+}
+
+// Delay returns how long to wait after the given (1-based) failed attempt.
+// rnd is a value in [0,1) used for jitter.
+func (p BackoffPolicy) Delay(attempt int, rnd float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(p.Base) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.Cap > 0 && d > float64(p.Cap) {
+		d = float64(p.Cap)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rnd-1)
+	}
+	if p.Cap > 0 && d > float64(p.Cap) {
+		d = float64(p.Cap)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// Exhausted reports whether no further attempt should follow the given one.
+func (p BackoffPolicy) Exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt >= p.MaxAttempts
+}
+
+// BackoffPolicies holds a policy per channel, falling back to Default.
+type BackoffPolicies struct {
+	Default   BackoffPolicy
+	ByChannel map[domain.Channel]BackoffPolicy
+}
+
+func (b BackoffPolicies) For(ch domain.Channel) BackoffPolicy {
+	if p, ok := b.ByChannel[ch]; ok {
+		return p
+	}
+	return b.Default
+}
+
+func DefaultBackoffPolicies() BackoffPolicies {
+	phone := BackoffPolicy{
+		Base:        5 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+		Cap:         10 * time.Minute,
+		MaxAttempts: 8,
+	}
+	email := BackoffPolicy{
+		Base:        30 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+		Cap:         time.Hour,
+		MaxAttempts: 10,
+	}
+	return BackoffPolicies{
+		Default: phone,
+		ByChannel: map[domain.Channel]BackoffPolicy{
+			domain.ChannelSMS:   phone,
+			domain.ChannelMMS:   phone,
+			domain.ChannelEmail: email,
+		},
+	}
+}
+
+// nextAttempt returns when the given failed attempt should be retried, or nil
+// if the policy is exhausted.
+func (b BackoffPolicies) nextAttempt(ch domain.Channel, attempt int, now time.Time) *time.Time {
+	p := b.For(ch)
+	if p.Exhausted(attempt) {
+		return nil
+	}
+	t := now.Add(p.Delay(attempt, rand.Float64()))
+	return &t
+}
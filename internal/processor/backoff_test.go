@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	p := BackoffPolicy{Base: time.Second, Multiplier: 2, Cap: 10 * time.Second}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second}, // capped
+		{50, 10 * time.Second},
+	}
+	for _, c := range cases {
+		if got := p.Delay(c.attempt, 0.5); got != c.want {
+			t.Fatalf("Delay(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestBackoffPolicyJitter(t *testing.T) {
+	p := BackoffPolicy{Base: 10 * time.Second, Multiplier: 2, Jitter: 0.2, Cap: time.Minute}
+	if got := p.Delay(1, 0); got != 8*time.Second {
+		t.Fatalf("low jitter: got %v want 8s", got)
+	}
+	if got := p.Delay(1, 0.999999); got < 11*time.Second || got > 12*time.Second {
+		t.Fatalf("high jitter: got %v want ~12s", got)
+	}
+	if got := p.Delay(10, 0.999999); got != time.Minute {
+		t.Fatalf("jitter must not exceed cap: got %v", got)
+	}
+}
+
+func TestBackoffPoliciesExhausted(t *testing.T) {
+	b := BackoffPolicies{
+		Default: BackoffPolicy{Base: time.Second, Multiplier: 2, MaxAttempts: 5},
+		ByChannel: map[domain.Channel]BackoffPolicy{
+			domain.ChannelEmail: {Base: time.Second, Multiplier: 2, MaxAttempts: 2},
+		},
+	}
+	now := time.Now()
+	if b.nextAttempt(domain.ChannelSMS, 4, now) == nil {
+		t.Fatal("sms attempt 4 should be retried")
+	}
+	if b.nextAttempt(domain.ChannelSMS, 5, now) != nil {
+		t.Fatal("sms attempt 5 should be exhausted")
+	}
+	if b.nextAttempt(domain.ChannelEmail, 2, now) != nil {
+		t.Fatal("email attempt 2 should be exhausted")
+	}
+}
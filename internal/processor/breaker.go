@@ -0,0 +1,228 @@
+package processor
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // sends go through
+	BreakerOpen     BreakerState = "open"      // sends are held back
+	BreakerHalfOpen BreakerState = "half_open" // one probe send decides whether to close again
+)
+
+// BreakerOptions decides when a CircuitBreaker opens and for how long.
+type BreakerOptions struct {
+	ConsecutiveFailures int           // opens after this many failures in a row
+	FailureRate         float64       // or once this share of the last Window sends failed, 0..1
+	Window              int           // sends the failure rate is computed over
+	MinRequests         int           // sends in the window before FailureRate applies
+	OpenFor             time.Duration // how long it stays open before a probe is let through
+}
+
+func DefaultBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		ConsecutiveFailures: 3,
+		FailureRate:         0.5,
+		Window:              20,
+		MinRequests:         10,
+		OpenFor:             time.Minute,
+	}
+}
+
+// BreakerStatus is a snapshot of a CircuitBreaker, as shown on the health
+// endpoint.
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	Requests            int          `json:"requests"` // sends in the current window
+	Failures            int          `json:"failures"` // failed sends in the current window
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	RetryAt             *time.Time   `json:"retry_at,omitempty"` // when an open breaker lets a probe through
+}
+
+// CircuitBreaker tracks the outcomes of sends through one provider. It opens
+// when too many of them fail, rejects sends while open, and after OpenFor
+// lets a single probe through: a success closes it, a failure opens it again.
+type CircuitBreaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	window      []bool // ring buffer of recent outcomes, true for a failure
+	next        int
+	count       int
+	failures    int
+	consecutive int
+	openedAt    time.Time
+	probeAt     time.Time // when the half-open probe was let through
+}
+
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	def := DefaultBreakerOptions()
+	if opts.ConsecutiveFailures <= 0 {
+		opts.ConsecutiveFailures = def.ConsecutiveFailures
+	}
+	if opts.FailureRate <= 0 || opts.FailureRate > 1 {
+		opts.FailureRate = def.FailureRate
+	}
+	if opts.Window <= 0 {
+		opts.Window = def.Window
+	}
+	if opts.MinRequests <= 0 || opts.MinRequests > opts.Window {
+		opts.MinRequests = min(def.MinRequests, opts.Window)
+	}
+	if opts.OpenFor <= 0 {
+		opts.OpenFor = def.OpenFor
+	}
+	return &CircuitBreaker{
+		opts:   opts,
+		now:    time.Now,
+		state:  BreakerClosed,
+		window: make([]bool, opts.Window),
+	}
+}
+
+// Ready reports whether Allow would let a send through, without claiming the
+// half-open probe.
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readyLocked(b.now())
+}
+
+func (b *CircuitBreaker) readyLocked(now time.Time) bool {
+	switch b.state {
+	case BreakerOpen:
+		return !now.Before(b.openedAt.Add(b.opts.OpenFor))
+	case BreakerHalfOpen:
+		// a probe that never reported back (e.g. the send was abandoned)
+		// does not hold the breaker half-open forever
+		return !now.Before(b.probeAt.Add(b.opts.OpenFor))
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a send may go through and, once an open breaker's
+// OpenFor has passed, claims the half-open probe.
+func (b *CircuitBreaker) Allow() bool {
+	ok, _ := b.claim()
+	return ok
+}
+
+// claim is Allow, also reporting whether the send is the half-open probe.
+func (b *CircuitBreaker) claim() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if !b.readyLocked(now) {
+		return false, false
+	}
+	if b.state != BreakerClosed {
+		b.state = BreakerHalfOpen
+		b.probeAt = now
+		return true, true
+	}
+	return true, false
+}
+
+// Release gives back the half-open probe claimed for a send that was not
+// made after all, e.g. because it was rate limited, so that the next send
+// probes instead of waiting another OpenFor.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+		b.probeAt = time.Time{}
+	}
+}
+
+// Record reports the outcome of a send that Allow let through.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.openLocked()
+		} else {
+			b.resetLocked()
+		}
+		return
+	case BreakerOpen:
+		// a send that started before the breaker opened
+		return
+	}
+
+	if b.count == len(b.window) {
+		if b.window[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+	b.window[b.next] = failed
+	b.next = (b.next + 1) % len(b.window)
+	if failed {
+		b.failures++
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	if b.consecutive >= b.opts.ConsecutiveFailures ||
+		b.count >= b.opts.MinRequests && float64(b.failures) >= b.opts.FailureRate*float64(b.count) {
+		b.openLocked()
+	}
+}
+
+func (b *CircuitBreaker) openLocked() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+}
+
+func (b *CircuitBreaker) resetLocked() {
+	b.state = BreakerClosed
+	b.count, b.next, b.failures, b.consecutive = 0, 0, 0, 0
+	b.openedAt, b.probeAt = time.Time{}, time.Time{}
+}
+
+// RetryAt is when an open breaker next lets a probe through; the zero time if
+// it is closed.
+func (b *CircuitBreaker) RetryAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retryAtLocked()
+}
+
+func (b *CircuitBreaker) retryAtLocked() time.Time {
+	switch b.state {
+	case BreakerOpen:
+		return b.openedAt.Add(b.opts.OpenFor)
+	case BreakerHalfOpen:
+		return b.probeAt.Add(b.opts.OpenFor)
+	default:
+		return time.Time{}
+	}
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{
+		State:               b.state,
+		Requests:            b.count,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutive,
+	}
+	if b.state != BreakerClosed {
+		opened, retry := b.openedAt, b.retryAtLocked()
+		s.OpenedAt, s.RetryAt = &opened, &retry
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	b := NewCircuitBreaker(BreakerOptions{ConsecutiveFailures: 100, FailureRate: 0.5, Window: 10, MinRequests: 4})
+	// alternating outcomes never fail twice in a row but reach 50%; the
+	// rate only applies from the fourth send
+	for i, failed := range []bool{true, false, true} {
+		b.Record(failed)
+		if b.Status().State != BreakerClosed {
+			t.Fatalf("opened after %d sends", i+1)
+		}
+	}
+	b.Record(false)
+	if s := b.Status(); s.State != BreakerOpen || s.Failures != 2 || s.Requests != 4 {
+		t.Fatalf("want open after 2 of 4 failed, got %+v", s)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(BreakerOptions{ConsecutiveFailures: 2, OpenFor: 30 * time.Second})
+	b.now = func() time.Time { return now }
+
+	b.Record(true)
+	b.Record(true)
+	if b.Status().State != BreakerOpen || b.Allow() {
+		t.Fatal("want open breaker to reject")
+	}
+
+	now = now.Add(30 * time.Second)
+	if !b.Allow() {
+		t.Fatal("want probe after OpenFor")
+	}
+	if b.Allow() {
+		t.Fatal("want a single probe while half-open")
+	}
+	b.Record(true)
+	if b.Status().State != BreakerOpen {
+		t.Fatal("failed probe should reopen")
+	}
+
+	now = now.Add(30 * time.Second)
+	if !b.Allow() {
+		t.Fatal("want second probe")
+	}
+	b.Record(false)
+	if s := b.Status(); s.State != BreakerClosed || s.Requests != 0 {
+		t.Fatalf("successful probe should close and reset, got %+v", s)
+	}
+}
@@ -0,0 +1,321 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/logging"
+	"github.com/rdavison/messaging-service/internal/netguard"
+	"github.com/rdavison/messaging-service/internal/repo"
+	"github.com/rdavison/messaging-service/internal/tracing"
+)
+
+// Headers of a subscription webhook. The signature is the scheme the API
+// accepts from providers: hex HMAC-SHA256 of "<timestamp>.<body>" keyed with
+// the subscription's secret, prefixed with "sha256=".
+const (
+	HeaderWebhookID        = "X-Webhook-Id"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// DeliveryOptions tunes a DeliveryWorker.
+type DeliveryOptions struct {
+	Workers   int           // goroutines POSTing concurrently
+	BatchSize int           // deliveries claimed per poll
+	Lease     time.Duration // how long a claim is held before other workers may reclaim it
+	Period    time.Duration // wait between polls when nothing was due
+	Timeout   time.Duration // for one POST, including reading the response
+	Backoff   BackoffPolicy
+	// DisableAfter failed attempts in a row disable a subscription; never
+	// when 0.
+	DisableAfter int
+}
+
+func DefaultDeliveryOptions() DeliveryOptions {
+	return DeliveryOptions{
+		Workers:      4,
+		BatchSize:    100,
+		Lease:        60 * time.Second,
+		Period:       2 * time.Second,
+		Timeout:      10 * time.Second,
+		Backoff:      BackoffPolicy{Base: 30 * time.Second, Multiplier: 2, Jitter: 0.2, Cap: time.Hour, MaxAttempts: 10},
+		DisableAfter: 20,
+	}
+}
+
+// WebhookEvent is the JSON body POSTed to a subscription.
+type WebhookEvent struct {
+	ID        string           `json:"id"` // the delivery's id, the same on every retry
+	Type      domain.EventType `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+// WebhookEventData holds the message or conversation an event is about, as
+// they are when the event is delivered.
+type WebhookEventData struct {
+	Message      *domain.Message      `json:"message,omitempty"`
+	Change       *domain.MessageEvent `json:"change,omitempty"` // the status change that raised a message event
+	Conversation *domain.Conversation `json:"conversation,omitempty"`
+}
+
+// DeliveryWorker POSTs queued subscription events (see repo.SubscriptionRepo)
+// and retries failed ones with backoff.
+type DeliveryWorker struct {
+	subs   *repo.SubscriptionRepo
+	msgs   *repo.MessageRepo
+	convs  *repo.ConversationRepo
+	client *http.Client
+	logger *slog.Logger
+	owner  string
+	opts   DeliveryOptions
+	rnd    func() float64
+	now    func() time.Time
+}
+
+func NewDeliveryWorker(pool *pgxpool.Pool, logger *slog.Logger, opts DeliveryOptions) *DeliveryWorker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	def := DefaultDeliveryOptions()
+	if opts.Workers <= 0 {
+		opts.Workers = def.Workers
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = def.BatchSize
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = def.Lease
+	}
+	if opts.Period <= 0 {
+		opts.Period = def.Period
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = def.Timeout
+	}
+	if opts.Backoff == (BackoffPolicy{}) {
+		opts.Backoff = def.Backoff
+	}
+	return &DeliveryWorker{
+		subs:   repo.NewSubscriptionRepo(pool),
+		msgs:   repo.NewMessageRepo(pool),
+		convs:  repo.NewConversationRepo(pool),
+		client: netguard.Client(netguard.Options{Timeout: opts.Timeout, AllowHTTP: true}),
+		logger: logger,
+		owner:  leaseOwner(),
+		opts:   opts,
+		rnd:    rand.Float64,
+		now:    time.Now,
+	}
+}
+
+// Run claims and delivers due events until ctx is done. Deliveries already
+// claimed are still posted and recorded; each POST is bounded by the timeout.
+func (w *DeliveryWorker) Run(ctx context.Context) error {
+	jobs := make(chan repo.PendingDelivery)
+	var wg sync.WaitGroup
+	dctx := context.WithoutCancel(ctx)
+	for i := 0; i < w.opts.Workers; i++ {
+		go func() {
+			for d := range jobs {
+				w.deliver(dctx, d)
+				wg.Done()
+			}
+		}()
+	}
+	defer close(jobs)
+
+	for ctx.Err() == nil {
+		ds, err := w.subs.ClaimDeliveries(ctx, w.owner, w.opts.BatchSize, w.opts.Lease)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.ErrorContext(ctx, "claim deliveries failed", "error", err)
+			}
+			sleepCtx(ctx, w.opts.Period)
+			continue
+		}
+		wg.Add(len(ds))
+		for _, d := range ds {
+			jobs <- d
+		}
+		wg.Wait()
+		if len(ds) < w.opts.BatchSize {
+			sleepCtx(ctx, w.opts.Period)
+		}
+	}
+	return ctx.Err()
+}
+
+// deliver POSTs d once and records the outcome.
+func (w *DeliveryWorker) deliver(ctx context.Context, d repo.PendingDelivery) {
+	ctx = logging.With(ctx, "delivery_id", d.ID, "subscription_id", d.SubscriptionID, "event", d.EventType)
+	ctx, span := tracing.Start(ctx, "deliver webhook", trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(attribute.String("messaging.webhook.event", string(d.EventType)))
+
+	// the lease may have run out while the delivery waited for a worker; only
+	// post while it is still ours, for long enough to record the outcome
+	if err := w.subs.RenewDeliveryLease(ctx, d.ID, w.owner, w.opts.Lease); err != nil {
+		w.logDeliveryErr(ctx, span, "renew delivery lease failed", err)
+		return
+	}
+
+	// a payload that can not be built counts as a failed attempt, so the
+	// delivery is retried with backoff rather than on every lease expiry;
+	// it fails at once if what it is about was deleted
+	disableAfter := w.opts.DisableAfter
+	body, payloadErr := w.payload(ctx, d)
+	var attempt domain.DeliveryAttempt
+	if payloadErr != nil {
+		tracing.RecordError(span, payloadErr)
+		w.logger.ErrorContext(ctx, "build webhook payload failed", "error", payloadErr)
+		attempt = domain.DeliveryAttempt{At: w.now(), Error: "build payload: " + payloadErr.Error()}
+		// not the endpoint's fault
+		disableAfter = 0
+	} else {
+		attempt = w.post(ctx, d, body)
+		span.SetAttributes(attribute.Int("http.response.status_code", attempt.StatusCode))
+		deliveryDuration.WithLabelValues(string(d.EventType)).Observe(float64(attempt.DurationMS) / 1000)
+	}
+	attempt.Number = d.AttemptCount + 1
+
+	var next *time.Time
+	outcome := string(domain.DeliveryDelivered)
+	if !attempt.OK() {
+		outcome = string(domain.DeliveryFailed)
+		if !w.opts.Backoff.Exhausted(attempt.Number) && !errors.Is(payloadErr, repo.ErrNotFound) {
+			t := w.now().Add(w.opts.Backoff.Delay(attempt.Number, w.rnd()))
+			next = &t
+			outcome = "retry"
+		}
+	}
+	deliveryAttempts.WithLabelValues(string(d.EventType), outcome).Inc()
+
+	disabled, err := w.subs.RecordAttempt(ctx, d, w.owner, attempt, next, disableAfter)
+	if err != nil {
+		w.logDeliveryErr(ctx, span, "record delivery attempt failed", err)
+		return
+	}
+	args := []any{"attempt", attempt.Number, "status_code", attempt.StatusCode, "outcome", outcome}
+	switch {
+	case attempt.OK():
+		w.logger.InfoContext(ctx, "webhook delivered", args...)
+	default:
+		w.logger.WarnContext(ctx, "webhook delivery failed", append(args, "error", attempt.Error, "next_attempt_at", next)...)
+	}
+	if disabled {
+		w.logger.WarnContext(ctx, "subscription disabled after repeated failures", "failures", w.opts.DisableAfter)
+	}
+}
+
+// logDeliveryErr logs err, or a warning if it is only that another worker took
+// the delivery over.
+func (w *DeliveryWorker) logDeliveryErr(ctx context.Context, span trace.Span, msg string, err error) {
+	if errors.Is(err, repo.ErrLeaseLost) {
+		w.logger.WarnContext(ctx, "lease lost; delivery not updated")
+		return
+	}
+	tracing.RecordError(span, err)
+	w.logger.ErrorContext(ctx, msg, "error", err)
+}
+
+// payload renders the body of d from the current state of its message or
+// conversation.
+func (w *DeliveryWorker) payload(ctx context.Context, d repo.PendingDelivery) ([]byte, error) {
+	ev := WebhookEvent{ID: strconv.FormatInt(d.ID, 10), Type: d.EventType, CreatedAt: d.CreatedAt}
+	if d.MessageID != nil {
+		m, err := w.msgs.GetByID(ctx, *d.MessageID)
+		if err != nil {
+			return nil, fmt.Errorf("load message %d: %w", *d.MessageID, err)
+		}
+		ev.Data.Message = &m
+	}
+	if d.MessageEventID != nil {
+		change, err := w.msgs.Event(ctx, *d.MessageEventID)
+		if err != nil {
+			return nil, fmt.Errorf("load message event %d: %w", *d.MessageEventID, err)
+		}
+		ev.Data.Change = &change
+	}
+	if d.ConversationID != nil && d.EventType == domain.EventConversationCreated {
+		c, err := w.convs.GetByID(ctx, d.TenantID, *d.ConversationID)
+		if err != nil {
+			return nil, fmt.Errorf("load conversation %d: %w", *d.ConversationID, err)
+		}
+		ev.Data.Conversation = &c
+	}
+	return json.Marshal(ev)
+}
+
+// post sends body to the subscription's URL. The attempt counts as failed
+// unless the endpoint answers 2xx within the timeout. The client refuses to
+// connect to non-public addresses, whatever the URL's host resolves to.
+func (w *DeliveryWorker) post(ctx context.Context, d repo.PendingDelivery, body []byte) (a domain.DeliveryAttempt) {
+	start := w.now()
+	a.At = start
+	defer func() { a.DurationMS = time.Since(start).Milliseconds() }()
+
+	req, err := newWebhookRequest(ctx, d, body, start)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	// drain a little so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode/100 != 2 {
+		a.Error = "unexpected status " + resp.Status
+	}
+	return a
+}
+
+func newWebhookRequest(ctx context.Context, d repo.PendingDelivery, body []byte, now time.Time) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "messaging-service-webhooks")
+	req.Header.Set(HeaderWebhookID, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderWebhookEvent, string(d.EventType))
+	req.Header.Set(HeaderWebhookTimestamp, ts)
+	req.Header.Set(HeaderWebhookSignature, signWebhook(d.Secret, ts, body))
+	return req, nil
+}
+
+// signWebhook returns the X-Webhook-Signature of body sent at timestamp ts
+// (unix seconds).
+func signWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
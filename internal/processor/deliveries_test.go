@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/repo"
+)
+
+func TestDeliveryWorkerPost(t *testing.T) {
+	status := http.StatusNoContent
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	w := &DeliveryWorker{client: srv.Client(), now: func() time.Time { return now }}
+	d := repo.PendingDelivery{
+		Delivery: domain.Delivery{ID: 7, EventType: domain.EventMessageSent},
+		URL:      srv.URL,
+		Secret:   "whsec_test",
+	}
+	body := []byte(`{"id":"7"}`)
+
+	a := w.post(context.Background(), d, body)
+	if !a.OK() || a.StatusCode != http.StatusNoContent || !a.At.Equal(now) {
+		t.Fatalf("attempt = %+v, want ok at %s", a, now)
+	}
+	if got.Header.Get(HeaderWebhookID) != "7" || got.Header.Get(HeaderWebhookEvent) != "message.sent" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	if ts := got.Header.Get(HeaderWebhookTimestamp); ts != "1730469600" {
+		t.Errorf("timestamp = %q", ts)
+	}
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1730469600." + string(body)))
+	if sig := got.Header.Get(HeaderWebhookSignature); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature = %q", sig)
+	}
+	if string(gotBody) != string(body) {
+		t.Errorf("body = %s", gotBody)
+	}
+
+	status = http.StatusServiceUnavailable
+	a = w.post(context.Background(), d, body)
+	if a.OK() || a.StatusCode != http.StatusServiceUnavailable || a.Error == "" {
+		t.Fatalf("attempt = %+v, want failure", a)
+	}
+
+	srv.Close()
+	a = w.post(context.Background(), d, body)
+	if a.OK() || a.StatusCode != 0 || a.Error == "" {
+		t.Fatalf("attempt = %+v, want connection error", a)
+	}
+}
+
+func TestDeliveryWorkerRefusesPrivateAddress(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	// the default client, which only connects to public addresses
+	w := NewDeliveryWorker(nil, nil, DeliveryOptions{})
+	d := repo.PendingDelivery{
+		Delivery: domain.Delivery{ID: 7, EventType: domain.EventMessageSent},
+		URL:      srv.URL,
+		Secret:   "whsec_test",
+	}
+	a := w.post(context.Background(), d, []byte(`{"id":"7"}`))
+	if a.OK() || called || !strings.Contains(a.Error, "not a public address") {
+		t.Fatalf("attempt = %+v, called = %v; want refused", a, called)
+	}
+}
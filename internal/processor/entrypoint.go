@@ -2,36 +2,156 @@ package processor
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/logging"
 	"github.com/rdavison/messaging-service/internal/repo"
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
+// Options tunes how an Entrypoint claims and works through the outbox.
+type Options struct {
+	Workers   int           // goroutines sending concurrently
+	BatchSize int           // messages claimed per poll
+	Lease     time.Duration // how long a claim is held before other workers may reclaim it
+	Period    time.Duration // longest wait between polls when no new message is announced
+	Backoff   BackoffPolicies
+	// RateLimits hold back sends beyond each provider's budget; unlimited
+	// when zero.
+	RateLimits RateLimits
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Workers:   4,
+		BatchSize: 200,
+		Lease:     60 * time.Second,
+		Period:    2 * time.Second,
+		Backoff:   DefaultBackoffPolicies(),
+	}
+}
+
 type Entrypoint struct {
-	pool   *pgxpool.Pool
-	msgs   *repo.MessageRepo
-	router Router
-	logger *log.Logger
-	period time.Duration
+	pool    *pgxpool.Pool
+	msgs    *repo.MessageRepo
+	router  Router
+	logger  *slog.Logger
+	owner   string
+	workers int
+	batch   int
+	lease   time.Duration
+	period  time.Duration
+	backoff BackoffPolicies
+	limiter *RateLimiter
+
+	lastPoll     atomic.Int64 // unix nanoseconds of the last successful claim
+	lastProgress atomic.Int64 // unix nanoseconds of the last claim or finished message
+
+	wakeMu sync.Mutex
+	wakeAt []time.Time // when the messages this processor deferred come due, in order
 }
 
-func NewEntrypoint(pool *pgxpool.Pool, router Router, logger *log.Logger) *Entrypoint {
+const (
+	// wakeSlack is added to the time a deferred message comes due before
+	// polling for it, so that a database clock slightly behind ours still
+	// finds it due.
+	wakeSlack = 50 * time.Millisecond
+	// maxWakes bounds the deferrals remembered; later ones are found by
+	// the regular polls.
+	maxWakes = 1024
+)
+
+func NewEntrypoint(pool *pgxpool.Pool, router Router, logger *slog.Logger, opts Options) *Entrypoint {
 	if logger == nil {
-		logger = log.Default()
+		logger = slog.Default()
 	}
-	return &Entrypoint{
-		pool:   pool,
-		msgs:   repo.NewMessageRepo(pool),
-		router: router,
-		logger: logger,
-		period: 2 * time.Second,
+	def := DefaultOptions()
+	if opts.Workers <= 0 {
+		opts.Workers = def.Workers
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = def.BatchSize
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = def.Lease
+	}
+	if opts.Period <= 0 {
+		opts.Period = def.Period
+	}
+	if opts.Backoff.ByChannel == nil && opts.Backoff.Default == (BackoffPolicy{}) {
+		opts.Backoff = def.Backoff
+	}
+	e := &Entrypoint{
+		pool:    pool,
+		msgs:    repo.NewMessageRepo(pool),
+		router:  router,
+		logger:  logger,
+		owner:   leaseOwner(),
+		workers: opts.Workers,
+		batch:   opts.BatchSize,
+		lease:   opts.Lease,
+		period:  opts.Period,
+		backoff: opts.Backoff,
+		limiter: NewRateLimiter(opts.RateLimits),
+	}
+	e.lastPoll.Store(time.Now().UnixNano())
+	e.lastProgress.Store(time.Now().UnixNano())
+	return e
+}
+
+// LastPoll is when messages were last claimed successfully, or when the
+// Entrypoint was created if they have not been yet.
+func (e *Entrypoint) LastPoll() time.Time {
+	return time.Unix(0, e.lastPoll.Load())
+}
+
+// LastProgress is when messages were last claimed or a worker last finished
+// one. A large or rate-limited batch can take a long time to work through,
+// but each message takes at most a send; so a stuck send or database shows
+// here while a slow batch does not.
+func (e *Entrypoint) LastProgress() time.Time {
+	return time.Unix(0, e.lastProgress.Load())
+}
+
+// leaseOwner identifies this process in messages.lease_owner.
+func leaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "processor"
 	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), uuid.NewString()[:8])
 }
 
+// Run claims and sends messages until ctx is done. It claims again right away
+// after a full batch, and otherwise waits until a new outbox message is
+// announced, a message it deferred comes due or Period has passed, whichever
+// is first. Polling also picks up
+// retries and scheduled messages as they come due, and anything missed while
+// the listener was down.
 func (e *Entrypoint) Run(ctx context.Context) error {
+	jobs := make(chan domain.Message)
+	var wg sync.WaitGroup
+	for i := 0; i < e.workers; i++ {
+		go e.work(ctx, jobs, &wg)
+	}
+	defer close(jobs)
+
+	wake := make(chan struct{}, 1)
+	go e.listen(ctx, wake)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -39,25 +159,184 @@ func (e *Entrypoint) Run(ctx context.Context) error {
 		default:
 		}
 
-		e.logger.Printf("Polling for unprocessed messages")
-		msgs, err := e.msgs.PollOutboxOrRetry(ctx, 200) // oldest first
+		e.logger.DebugContext(ctx, "polling for unprocessed messages")
+		start := time.Now()
+		msgs, err := e.msgs.ClaimOutboxOrRetry(ctx, e.owner, e.batch, e.lease) // oldest first
 		if err != nil {
-			e.logger.Printf("poll error: %v", err)
-			time.Sleep(e.period)
+			e.logger.ErrorContext(ctx, "poll failed", "error", err)
+			e.wait(ctx, wake)
+			continue
+		}
+		e.lastPoll.Store(time.Now().UnixNano())
+		e.lastProgress.Store(time.Now().UnixNano())
+		e.polled(start)
+		e.logger.DebugContext(ctx, "claimed messages", "count", len(msgs))
+
+		sent := e.dispatch(ctx, jobs, &wg, msgs)
+		// sends already handed to a worker are finished and recorded
+		// before Run returns, even when shutting down
+		wg.Wait()
+		pollDuration.Observe(time.Since(start).Seconds())
+		pollClaimed.Add(float64(len(msgs)))
+		if sent < len(msgs) {
+			return ctx.Err()
+		}
+
+		// a full batch means more are probably waiting
+		if len(msgs) < e.batch {
+			e.wait(ctx, wake)
+		}
+	}
+}
+
+// dispatch hands msgs to the workers until ctx is done, and releases the
+// leases of those it did not hand out. Returns how many it handed out.
+func (e *Entrypoint) dispatch(ctx context.Context, jobs chan<- domain.Message, wg *sync.WaitGroup, msgs []domain.Message) int {
+	for i, m := range msgs {
+		wg.Add(1)
+		select {
+		case jobs <- m:
 			continue
+		case <-ctx.Done():
+			wg.Done()
+		}
+		ids := make([]int64, 0, len(msgs)-i)
+		for _, m := range msgs[i:] {
+			ids = append(ids, m.ID)
 		}
-		e.logger.Printf("Got %d unprocessed messages", len(msgs))
+		rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		if err := e.msgs.ReleaseLeases(rctx, e.owner, ids); err != nil {
+			e.logger.WarnContext(rctx, "release leases failed", "count", len(ids), "error", err)
+		}
+		cancel()
+		return i
+	}
+	return len(msgs)
+}
+
+// wakeBy makes Run poll again by t, when a message it deferred comes due,
+// rather than after up to a whole Period.
+func (e *Entrypoint) wakeBy(t time.Time) {
+	e.wakeMu.Lock()
+	defer e.wakeMu.Unlock()
+	i, found := slices.BinarySearchFunc(e.wakeAt, t, time.Time.Compare)
+	if found || i >= maxWakes {
+		return
+	}
+	e.wakeAt = slices.Insert(e.wakeAt, i, t)
+	if len(e.wakeAt) > maxWakes {
+		e.wakeAt = e.wakeAt[:maxWakes]
+	}
+}
+
+// polled forgets the deferred messages that were due by a poll at start.
+func (e *Entrypoint) polled(start time.Time) {
+	e.wakeMu.Lock()
+	defer e.wakeMu.Unlock()
+	n := 0
+	for n < len(e.wakeAt) && !e.wakeAt[n].Add(wakeSlack).After(start) {
+		n++
+	}
+	e.wakeAt = slices.Delete(e.wakeAt, 0, n)
+}
+
+// nextPoll is how long to wait for a notification before polling anyway:
+// Period, or less if a deferred message comes due sooner.
+func (e *Entrypoint) nextPoll(now time.Time) time.Duration {
+	e.wakeMu.Lock()
+	defer e.wakeMu.Unlock()
+	d := e.period
+	if len(e.wakeAt) > 0 {
+		d = min(d, max(e.wakeAt[0].Add(wakeSlack).Sub(now), 0))
+	}
+	return d
+}
+
+// wait returns once wake fires, the next poll is due or ctx is done.
+func (e *Entrypoint) wait(ctx context.Context, wake <-chan struct{}) {
+	t := time.NewTimer(e.nextPoll(time.Now()))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-wake:
+	case <-t.C:
+	}
+}
 
-		for i, m := range msgs {
-			e.logger.Printf("Processing message %d/%d (id=%d)", i+1, len(msgs), m.ID)
-			status, err := e.TransitionStatus(ctx, m.ID)
-			if err != nil {
-				e.logger.Printf("transition error id=%d: %v", m.ID, err)
-				continue
+// listen signals wake for every outbox notification until ctx is done. wake
+// holds at most one pending signal, so a burst of inserts causes one extra
+// poll. The connection is reestablished after errors; meanwhile Run falls
+// back to polling.
+func (e *Entrypoint) listen(ctx context.Context, wake chan<- struct{}) {
+	notify := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	for ctx.Err() == nil {
+		l, err := e.msgs.ListenOutbox(ctx)
+		if err != nil {
+			e.logger.WarnContext(ctx, "listen failed", "error", err)
+			sleepCtx(ctx, e.period)
+			continue
+		}
+		// messages inserted while not listening
+		notify()
+		for {
+			if err := l.Wait(ctx); err != nil {
+				if ctx.Err() == nil {
+					e.logger.WarnContext(ctx, "listen failed", "error", err)
+				}
+				break
 			}
-			e.logger.Printf("New status for message id=%d => %s", m.ID, status)
+			notify()
 		}
+		l.Close()
+	}
+}
+
+// sleepCtx sleeps for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
 
-		time.Sleep(e.period)
+// work sends claimed messages until jobs is closed. A message it has taken is
+// sent and recorded even if ctx is canceled meanwhile: abandoning it after
+// the provider accepted it would send it again once its lease expired.
+func (e *Entrypoint) work(ctx context.Context, jobs <-chan domain.Message, wg *sync.WaitGroup) {
+	ctx = context.WithoutCancel(ctx)
+	for m := range jobs {
+		// continue the trace of the request that created the message
+		mctx, span := tracing.Start(tracing.ContextWithRemoteParent(ctx, m.Traceparent), "process message", trace.SpanKindConsumer)
+		mctx = logging.With(mctx, "message_id", m.ID, "conversation_id", m.ConversationID)
+		e.logger.DebugContext(mctx, "processing message")
+		span.SetAttributes(attribute.Int64("messaging.message.id", m.ID))
+		status, err := e.TransitionStatus(mctx, m.ID)
+		span.SetAttributes(attribute.String("messaging.message.status", string(status)))
+		var d *DeferredError
+		deferred := errors.As(err, &d)
+		lost := errors.Is(err, repo.ErrLeaseLost)
+		if !deferred && !lost {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+		switch {
+		case lost:
+			e.logger.WarnContext(mctx, "lease lost; message not updated", "status", status)
+		case deferred:
+			e.logger.InfoContext(mctx, "message deferred", "status", status, "until", d.Until, "reason", d.Err)
+		case err != nil:
+			e.logger.ErrorContext(mctx, "transition failed", "status", status, "error", err)
+		default:
+			e.logger.InfoContext(mctx, "message processed", "status", status)
+		}
+		e.lastProgress.Store(time.Now().UnixNano())
+		wg.Done()
 	}
 }
@@ -0,0 +1,46 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntrypointWakesForDeferred(t *testing.T) {
+	e := &Entrypoint{period: 2 * time.Second}
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+
+	if got := e.nextPoll(now); got != 2*time.Second {
+		t.Fatalf("nothing deferred: wait %s, want the period", got)
+	}
+
+	// the earliest deferral decides, rounded up by the slack only
+	e.wakeBy(now.Add(700 * time.Millisecond))
+	e.wakeBy(now.Add(300 * time.Millisecond))
+	e.wakeBy(now.Add(time.Hour))
+	if got, want := e.nextPoll(now), 300*time.Millisecond+wakeSlack; got != want {
+		t.Fatalf("wait %s, want %s", got, want)
+	}
+	// a notification may poll before it is due; that poll does not count
+	e.polled(now.Add(100 * time.Millisecond))
+	if got, want := e.nextPoll(now.Add(200*time.Millisecond)), 100*time.Millisecond+wakeSlack; got != want {
+		t.Fatalf("after an early poll: wait %s, want %s", got, want)
+	}
+	// already due: poll right away
+	if got := e.nextPoll(now.Add(time.Second)); got != 0 {
+		t.Fatalf("overdue: wait %s, want 0", got)
+	}
+
+	// then the next deferral is waited for
+	e.polled(now.Add(400 * time.Millisecond))
+	if got, want := e.nextPoll(now.Add(400*time.Millisecond)), 300*time.Millisecond+wakeSlack; got != want {
+		t.Fatalf("after the poll: wait %s, want %s", got, want)
+	}
+	// deferrals further out than the period do not shorten the wait
+	e.polled(now.Add(time.Second))
+	if got := e.nextPoll(now.Add(time.Second)); got != 2*time.Second {
+		t.Fatalf("far deferral: wait %s, want the period", got)
+	}
+	if got, want := e.nextPoll(now.Add(time.Hour-time.Second)), time.Second+wakeSlack; got != want {
+		t.Fatalf("far deferral coming due: wait %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/metrics"
+	"github.com/rdavison/messaging-service/internal/provider"
+)
+
+var (
+	sendAttempts = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "messaging_send_attempts_total",
+		Help: "Sends through a provider by channel and outcome: the status it returned, or error.",
+	}, []string{"provider", "channel", "outcome"})
+	sendDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "messaging_send_duration_seconds",
+		Help:    "Time a provider took to send a message.",
+		Buckets: metrics.DefBuckets,
+	}, []string{"provider"})
+	sendDeferred = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "messaging_send_deferred_total",
+		Help: "Messages held back without an attempt, by reason: circuit_open or rate_limited.",
+	}, []string{"reason"})
+	pollDuration = metrics.Factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "messaging_poll_duration_seconds",
+		Help:    "Time to claim a batch of messages and send all of them.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	})
+	pollClaimed = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Name: "messaging_poll_claimed_total",
+		Help: "Messages claimed from the outbox.",
+	})
+	deliveryAttempts = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "messaging_webhook_deliveries_total",
+		Help: "Webhook deliveries to subscriptions by event type and outcome: delivered, retry or failed.",
+	}, []string{"event", "outcome"})
+	deliveryDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "messaging_webhook_delivery_duration_seconds",
+		Help:    "Time a subscription's endpoint took to answer a webhook.",
+		Buckets: metrics.DefBuckets,
+	}, []string{"event"})
+)
+
+func observeSend(p provider.Provider, ch domain.Channel, resp provider.Response, err error, took time.Duration) {
+	name := providerName(p)
+	if name == "" {
+		name = "unknown"
+	}
+	outcome := string(resp.Status)
+	if err != nil {
+		outcome = "error"
+	}
+	sendAttempts.WithLabelValues(name, string(ch), outcome).Inc()
+	sendDuration.WithLabelValues(name).Observe(took.Seconds())
+}
+
+func observeDeferred(d *DeferredError) {
+	reason := "other"
+	switch {
+	case errors.Is(d, ErrRateLimited):
+		reason = "rate_limited"
+	case errors.Is(d, ErrCircuitOpen):
+		reason = "circuit_open"
+	}
+	sendDeferred.WithLabelValues(reason).Inc()
+}
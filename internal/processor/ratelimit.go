@@ -0,0 +1,197 @@
+package processor
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit is a token bucket: Rate sends per second on average, in bursts of
+// up to Burst. A zero Rate is unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst int // at least 1
+}
+
+// ProviderLimits are the send budgets of one provider. Each applies to its own
+// key: the provider account as a whole, every source endpoint sending through
+// it, and every tenant sending through it.
+type ProviderLimits struct {
+	Provider RateLimit
+	Source   RateLimit
+	Tenant   RateLimit
+}
+
+// RateLimits configures a RateLimiter.
+type RateLimits struct {
+	Default    ProviderLimits            // for providers not in ByProvider
+	ByProvider map[string]ProviderLimits // by provider name
+}
+
+// Share returns the part of l that each of n processors enforces, so that
+// together they stay within l: every rate is divided by n. Bursts are divided
+// too, but not below 1, so n processors may together burst up to n sends.
+func (l RateLimits) Share(n int) RateLimits {
+	if n <= 1 {
+		return l
+	}
+	share := func(pl ProviderLimits) ProviderLimits {
+		for _, rl := range []*RateLimit{&pl.Provider, &pl.Source, &pl.Tenant} {
+			rl.Rate /= float64(n)
+			rl.Burst = max((rl.Burst+n-1)/n, 1)
+		}
+		return pl
+	}
+	out := RateLimits{Default: share(l.Default), ByProvider: make(map[string]ProviderLimits, len(l.ByProvider))}
+	for name, pl := range l.ByProvider {
+		out.ByProvider[name] = share(pl)
+	}
+	return out
+}
+
+// sweepEvery is how often buckets that have refilled are forgotten.
+const sweepEvery = time.Minute
+
+// RateLimiter holds back sends that would exceed a provider's budgets. Buckets
+// are kept in memory, so each processor enforces the limits on its own; run
+// several with their Share of the limits.
+type RateLimiter struct {
+	limits RateLimits
+	now    func() time.Time
+
+	mu       sync.Mutex
+	buckets  map[bucketKey]*bucket
+	reserved map[int64]reservation // by message id
+	swept    time.Time
+}
+
+// reservation is the slot a deferred message holds with a provider.
+type reservation struct {
+	provider string
+	at       time.Time
+}
+
+type bucketKey struct {
+	provider string
+	scope    string // "provider" | "source" | "tenant"
+	id       string
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{
+		limits:   limits,
+		now:      time.Now,
+		buckets:  make(map[bucketKey]*bucket),
+		reserved: make(map[int64]reservation),
+	}
+}
+
+// Reserve takes a token from every bucket m is sent through with the named
+// provider and returns true if all of them had one. Otherwise the buckets go
+// into debt and m is given the time its turn comes, after every message
+// deferred before it: the k-th message over a budget waits k/rate. When m comes
+// back at that time it is let through on the tokens it already took.
+func (l *RateLimiter) Reserve(name string, m domain.Message) (time.Time, bool) {
+	lim, ok := l.limits.ByProvider[name]
+	if !ok {
+		lim = l.limits.Default
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweepLocked(now)
+
+	if r, ok := l.reserved[m.ID]; ok {
+		if r.provider == name {
+			if now.Before(r.at) {
+				return r.at, false
+			}
+			delete(l.reserved, m.ID)
+			return now, true
+		}
+		// routed elsewhere this time; the tokens taken before are lost
+		delete(l.reserved, m.ID)
+	}
+
+	checks := [...]struct {
+		key   bucketKey
+		limit RateLimit
+	}{
+		{bucketKey{name, "provider", ""}, lim.Provider},
+		{bucketKey{name, "source", m.Source.Payload}, lim.Source},
+		{bucketKey{name, "tenant", strconv.FormatInt(m.TenantID, 10)}, lim.Tenant},
+	}
+	var until time.Time
+	for _, c := range checks {
+		if c.limit.Rate <= 0 {
+			continue
+		}
+		b := l.bucketLocked(c.key, c.limit, now)
+		b.tokens--
+		if b.tokens < 0 {
+			at := now.Add(time.Duration(-b.tokens / c.limit.Rate * float64(time.Second)))
+			if at.After(until) {
+				until = at
+			}
+		}
+	}
+	if !until.IsZero() {
+		l.reserved[m.ID] = reservation{provider: name, at: until}
+		return until, false
+	}
+	return now, true
+}
+
+// bucketLocked returns the bucket for key, refilled up to now. New buckets
+// start full.
+func (l *RateLimiter) bucketLocked(key bucketKey, limit RateLimit, now time.Time) *bucket {
+	limit.Burst = max(limit.Burst, 1)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.refill(now)
+	return b
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// sweepLocked drops full buckets, which behave the same as missing ones, so
+// that one-off senders do not accumulate. Reservations of messages that did
+// not come back for a while after their turn are dropped too.
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.swept) < sweepEvery {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	for id, r := range l.reserved {
+		if now.Sub(r.at) >= sweepEvery {
+			delete(l.reserved, id)
+		}
+	}
+}
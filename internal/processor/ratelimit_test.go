@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(RateLimits{
+		ByProvider: map[string]ProviderLimits{
+			"twilio": {Source: RateLimit{Rate: 1, Burst: 1}, Tenant: RateLimit{Rate: 10, Burst: 3}},
+		},
+	})
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	msg := func(id, tenant int64, from string) domain.Message {
+		return domain.Message{ID: id, TenantID: tenant, Source: domain.Endpoint{Kind: domain.EndpointKindPhone, Payload: from}}
+	}
+
+	if _, ok := l.Reserve("twilio", msg(1, 1, "+15550001")); !ok {
+		t.Fatal("first send should pass")
+	}
+	// the same number is over its budget until a second has passed
+	until, ok := l.Reserve("twilio", msg(2, 1, "+15550001"))
+	if ok || !until.Equal(now.Add(time.Second)) {
+		t.Fatalf("want deferral until %s, got %s %v", now.Add(time.Second), until, ok)
+	}
+	// another number of the same tenant uses the rest of the tenant's burst,
+	// since the deferred send holds a token of it
+	if _, ok := l.Reserve("twilio", msg(3, 1, "+15550002")); !ok {
+		t.Fatal("other number should pass")
+	}
+	until, ok = l.Reserve("twilio", msg(4, 1, "+15550003"))
+	if ok || !until.Equal(now.Add(100*time.Millisecond)) {
+		t.Fatalf("want tenant deferral until %s, got %s %v", now.Add(100*time.Millisecond), until, ok)
+	}
+	// other tenants and providers are unaffected
+	if _, ok := l.Reserve("twilio", msg(5, 2, "+15550004")); !ok {
+		t.Fatal("other tenant should pass")
+	}
+	if _, ok := l.Reserve("sendgrid", msg(6, 1, "+15550001")); !ok {
+		t.Fatal("unlimited provider should pass")
+	}
+
+	// a deferred send asking again early keeps its turn
+	if until, ok := l.Reserve("twilio", msg(2, 1, "+15550001")); ok || !until.Equal(now.Add(time.Second)) {
+		t.Fatalf("early retry: got %s %v", until, ok)
+	}
+	now = now.Add(time.Second)
+	// at its turn it goes out on the token it already took, and a new send
+	// waits for the next one
+	if _, ok := l.Reserve("twilio", msg(2, 1, "+15550001")); !ok {
+		t.Fatal("deferred send should pass at its turn")
+	}
+	if until, ok := l.Reserve("twilio", msg(7, 1, "+15550001")); ok || !until.Equal(now.Add(time.Second)) {
+		t.Fatalf("want deferral until %s, got %s %v", now.Add(time.Second), until, ok)
+	}
+}
+
+func TestRateLimiterQueuesDeferred(t *testing.T) {
+	l := NewRateLimiter(RateLimits{
+		ByProvider: map[string]ProviderLimits{"twilio": {Provider: RateLimit{Rate: 2, Burst: 1}}},
+	})
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if _, ok := l.Reserve("twilio", domain.Message{ID: 1}); !ok {
+		t.Fatal("first send should pass")
+	}
+	// each message over budget gets its own slot instead of all waking at once
+	for k := int64(1); k <= 4; k++ {
+		until, ok := l.Reserve("twilio", domain.Message{ID: 1 + k})
+		want := now.Add(time.Duration(k) * 500 * time.Millisecond)
+		if ok || !until.Equal(want) {
+			t.Fatalf("message %d: want deferral until %s, got %s %v", k, want, until, ok)
+		}
+	}
+}
+
+func TestRateLimitsShare(t *testing.T) {
+	limits := RateLimits{
+		Default: ProviderLimits{Provider: RateLimit{Rate: 30, Burst: 10}},
+		ByProvider: map[string]ProviderLimits{
+			"twilio": {Source: RateLimit{Rate: 1, Burst: 1}},
+		},
+	}
+	got := limits.Share(3)
+	if p := got.Default.Provider; p.Rate != 10 || p.Burst != 4 {
+		t.Errorf("default provider limit = %+v, want 10/s in bursts of 4", p)
+	}
+	if s := got.ByProvider["twilio"].Source; s.Rate != 1.0/3 || s.Burst != 1 {
+		t.Errorf("twilio source limit = %+v, want 1/3 per second in bursts of 1", s)
+	}
+	if tl := got.ByProvider["twilio"].Tenant; tl.Rate != 0 {
+		t.Errorf("unlimited tenant limit became %+v", tl)
+	}
+	if limits.ByProvider["twilio"].Source.Rate != 1 {
+		t.Error("Share changed the limits it was given")
+	}
+	if one := limits.Share(1); one.Default.Provider.Rate != 30 {
+		t.Errorf("a single processor gets %+v", one.Default.Provider)
+	}
+}
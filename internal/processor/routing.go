@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/provider"
+)
+
+var (
+	ErrNoRoute     = errors.New("no route")
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// RouteProvider is a provider available to a RoutingEngine under its name.
+type RouteProvider struct {
+	Provider provider.Provider
+	Channels []domain.Channel // channels it can deliver
+}
+
+// RouteRule sends matching messages to its providers. Empty match fields
+// match any message.
+type RouteRule struct {
+	Name         string
+	Channels     []domain.Channel
+	CountryCodes []string // calling code prefixes of the target number, without "+"
+	TenantIDs    []int64
+	Providers    []WeightedProvider // traffic is split among the healthy ones by weight
+	Failover     []string           // used in order once the breakers of all providers are open
+}
+
+type WeightedProvider struct {
+	Name   string
+	Weight int // relative share; 0 counts as 1
+}
+
+// RoutingConfig configures a RoutingEngine.
+type RoutingConfig struct {
+	Rules   []RouteRule    // evaluated in order; the first match wins
+	Breaker BreakerOptions // for the circuit breaker of every provider
+}
+
+// RoutingEngine is a Router that picks providers by rule, splits traffic by
+// weight and fails over to other providers while one is failing. Each
+// provider has a CircuitBreaker; the provider returned by ChooseProvider
+// reports the outcome of each send to it.
+type RoutingEngine struct {
+	providers map[string]RouteProvider
+	breakers  map[string]*CircuitBreaker
+	rules     []RouteRule
+	rnd       func() float64
+}
+
+// NewRoutingEngine checks that every rule refers to known providers.
+func NewRoutingEngine(cfg RoutingConfig, providers map[string]RouteProvider) (*RoutingEngine, error) {
+	rules := slices.Clone(cfg.Rules)
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Providers) == 0 {
+			return nil, fmt.Errorf("routing %s: no providers", rule.Name)
+		}
+		names := append([]string(nil), rule.Failover...)
+		for _, wp := range rule.Providers {
+			if wp.Weight < 0 {
+				return nil, fmt.Errorf("routing %s: negative weight for %s", rule.Name, wp.Name)
+			}
+			names = append(names, wp.Name)
+		}
+		for _, n := range names {
+			if _, ok := providers[n]; !ok {
+				return nil, fmt.Errorf("routing %s: unknown provider %q", rule.Name, n)
+			}
+		}
+	}
+	breakers := make(map[string]*CircuitBreaker, len(providers))
+	for name := range providers {
+		breakers[name] = NewCircuitBreaker(cfg.Breaker)
+	}
+	return &RoutingEngine{
+		providers: providers,
+		breakers:  breakers,
+		rules:     rules,
+		rnd:       rand.Float64,
+	}, nil
+}
+
+func (r *RoutingEngine) ChooseProvider(m domain.Message) (provider.Provider, error) {
+	if m.Source.Kind != m.Target.Kind {
+		return nil, fmt.Errorf("no provider for source -> target: %s -> %s", m.Source.Kind, m.Target.Kind)
+	}
+	ch := m.Channel()
+	rule, ok := r.match(m, ch)
+	if !ok {
+		return nil, fmt.Errorf("%w for %s to %s", ErrNoRoute, ch, m.Target.Payload)
+	}
+
+	var healthy []WeightedProvider
+	total := 0
+	for _, wp := range rule.Providers {
+		if r.supports(wp.Name, ch) && r.breakers[wp.Name].Ready() {
+			w := max(wp.Weight, 1)
+			healthy = append(healthy, WeightedProvider{Name: wp.Name, Weight: w})
+			total += w
+		}
+	}
+	// a half-open breaker may refuse the claim when another send holds its
+	// probe; draw again from the rest before falling back to Failover
+	for len(healthy) > 0 {
+		pick := r.rnd() * float64(total)
+		i := len(healthy) - 1
+		for j, wp := range healthy {
+			if pick < float64(wp.Weight) {
+				i = j
+				break
+			}
+			pick -= float64(wp.Weight)
+		}
+		name := healthy[i].Name
+		if ok, probe := r.breakers[name].claim(); ok {
+			return r.routed(name, probe), nil
+		}
+		total -= healthy[i].Weight
+		healthy = append(healthy[:i], healthy[i+1:]...)
+	}
+	for _, name := range rule.Failover {
+		if !r.supports(name, ch) {
+			continue
+		}
+		if ok, probe := r.breakers[name].claim(); ok {
+			return r.routed(name, probe), nil
+		}
+	}
+	until := r.reopensAt(rule)
+	if until.IsZero() {
+		return nil, fmt.Errorf("%w: no provider of %s delivers %s", ErrNoRoute, rule.Name, ch)
+	}
+	return nil, &DeferredError{
+		Until: until,
+		Err:   fmt.Errorf("%w for every provider of %s", ErrCircuitOpen, rule.Name),
+	}
+}
+
+// reopensAt is the earliest time a breaker of rule's providers lets a probe
+// through, or the zero time if none of them is open.
+func (r *RoutingEngine) reopensAt(rule RouteRule) time.Time {
+	var at time.Time
+	names := append([]string(nil), rule.Failover...)
+	for _, wp := range rule.Providers {
+		names = append(names, wp.Name)
+	}
+	for _, n := range names {
+		t := r.breakers[n].RetryAt()
+		if !t.IsZero() && (at.IsZero() || t.Before(at)) {
+			at = t
+		}
+	}
+	return at
+}
+
+// Breakers returns the state of every provider's circuit breaker by name.
+func (r *RoutingEngine) Breakers() map[string]BreakerStatus {
+	out := make(map[string]BreakerStatus, len(r.breakers))
+	for name, b := range r.breakers {
+		out[name] = b.Status()
+	}
+	return out
+}
+
+// match returns the first rule that applies to m.
+func (r *RoutingEngine) match(m domain.Message, ch domain.Channel) (RouteRule, bool) {
+	for _, rule := range r.rules {
+		if len(rule.Channels) > 0 && !slices.Contains(rule.Channels, ch) {
+			continue
+		}
+		if len(rule.TenantIDs) > 0 && !slices.Contains(rule.TenantIDs, m.TenantID) {
+			continue
+		}
+		if len(rule.CountryCodes) > 0 && !matchesCountry(rule.CountryCodes, m.Target) {
+			continue
+		}
+		return rule, true
+	}
+	return RouteRule{}, false
+}
+
+// matchesCountry reports whether a phone target starts with one of codes.
+func matchesCountry(codes []string, target domain.Endpoint) bool {
+	if target.Kind != domain.EndpointKindPhone {
+		return false
+	}
+	num := strings.TrimPrefix(target.Payload, "+")
+	for _, c := range codes {
+		if strings.HasPrefix(num, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RoutingEngine) supports(name string, ch domain.Channel) bool {
+	return slices.Contains(r.providers[name].Channels, ch)
+}
+
+func (r *RoutingEngine) routed(name string, probe bool) provider.Provider {
+	return routedProvider{name: name, inner: r.providers[name].Provider, breaker: r.breakers[name], probe: probe}
+}
+
+// routedProvider reports every send to the provider's breaker.
+type routedProvider struct {
+	name    string
+	inner   provider.Provider
+	breaker *CircuitBreaker
+	probe   bool // the send is the breaker's half-open probe
+}
+
+// Name is the provider's name in the routing config.
+func (p routedProvider) Name() string { return p.name }
+
+// Release gives back the breaker's probe when the message is not sent
+// through p after all.
+func (p routedProvider) Release() {
+	if p.probe {
+		p.breaker.Release()
+	}
+}
+
+// Send counts errors and retryable responses as failures of the provider. A
+// permanent rejection (e.g. an invalid number) says nothing about its health.
+func (p routedProvider) Send(ctx context.Context, m domain.Message) (provider.Response, error) {
+	resp, err := p.inner.Send(ctx, m)
+	if ctx.Err() == nil {
+		p.breaker.Record(err != nil || resp.Status == domain.StatusRetry)
+	}
+	return resp, err
+}
@@ -0,0 +1,236 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/provider"
+)
+
+// flakyProv fails every send while down is set.
+type flakyProv struct {
+	name string
+	down *bool
+}
+
+func (f flakyProv) Send(_ context.Context, _ domain.Message) (provider.Response, error) {
+	if *f.down {
+		return provider.Response{}, errors.New(f.name + " unavailable")
+	}
+	return provider.Response{ProviderID: f.name, Status: domain.StatusOK}, nil
+}
+
+func smsTo(to string, tenantID int64) domain.Message {
+	ch := domain.PhoneChannelSMS
+	return domain.Message{
+		TenantID: tenantID,
+		Source:   domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: "+12016661234"},
+		Target:   domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: to},
+	}
+}
+
+// sendVia chooses a provider for m, sends through it and returns the
+// provider id of the response.
+func sendVia(t *testing.T, r *RoutingEngine, m domain.Message) string {
+	t.Helper()
+	p, err := r.ChooseProvider(m)
+	if err != nil {
+		t.Fatalf("choose: %v", err)
+	}
+	resp, _ := p.Send(context.Background(), m)
+	return resp.ProviderID
+}
+
+func TestRoutingEngineRules(t *testing.T) {
+	phone := []domain.Channel{domain.ChannelSMS, domain.ChannelMMS}
+	up := false
+	r, err := NewRoutingEngine(RoutingConfig{Rules: []RouteRule{
+		{CountryCodes: []string{"44"}, Providers: []WeightedProvider{{Name: "uk"}}},
+		{TenantIDs: []int64{7}, Providers: []WeightedProvider{{Name: "vip"}}},
+		{Channels: phone, Providers: []WeightedProvider{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}},
+		{Channels: []domain.Channel{domain.ChannelEmail}, Providers: []WeightedProvider{{Name: "mail"}}},
+	}}, map[string]RouteProvider{
+		"uk":   {Provider: flakyProv{"uk", &up}, Channels: phone},
+		"vip":  {Provider: flakyProv{"vip", &up}, Channels: phone},
+		"a":    {Provider: flakyProv{"a", &up}, Channels: phone},
+		"b":    {Provider: flakyProv{"b", &up}, Channels: phone},
+		"mail": {Provider: flakyProv{"mail", &up}, Channels: []domain.Channel{domain.ChannelEmail}},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	if got := sendVia(t, r, smsTo("+447700900123", 7)); got != "uk" {
+		t.Errorf("country rule: got %s", got)
+	}
+	if got := sendVia(t, r, smsTo("+18045551234", 7)); got != "vip" {
+		t.Errorf("tenant rule: got %s", got)
+	}
+
+	// weights 3:1 split [0,0.75) to a and [0.75,1) to b
+	r.rnd = func() float64 { return 0.74 }
+	if got := sendVia(t, r, smsTo("+18045551234", 1)); got != "a" {
+		t.Errorf("weighted 0.74: got %s", got)
+	}
+	r.rnd = func() float64 { return 0.75 }
+	if got := sendVia(t, r, smsTo("+18045551234", 1)); got != "b" {
+		t.Errorf("weighted 0.75: got %s", got)
+	}
+
+	email := domain.Message{
+		Source: domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "a@example.com"},
+		Target: domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "b@example.com"},
+	}
+	if got := sendVia(t, r, email); got != "mail" {
+		t.Errorf("email rule: got %s", got)
+	}
+}
+
+func TestRoutingEngineFailover(t *testing.T) {
+	phone := []domain.Channel{domain.ChannelSMS, domain.ChannelMMS}
+	primaryDown, backupDown := true, false
+	r, err := NewRoutingEngine(RoutingConfig{
+		Breaker: BreakerOptions{ConsecutiveFailures: 2, OpenFor: time.Minute},
+		Rules: []RouteRule{
+			{Providers: []WeightedProvider{{Name: "primary"}}, Failover: []string{"backup"}},
+		},
+	}, map[string]RouteProvider{
+		"primary": {Provider: flakyProv{"primary", &primaryDown}, Channels: phone},
+		"backup":  {Provider: flakyProv{"backup", &backupDown}, Channels: phone},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	for _, b := range r.breakers {
+		b.now = func() time.Time { return now }
+	}
+	m := smsTo("+18045551234", 1)
+
+	// two consecutive failures trip the primary
+	for i := 0; i < 2; i++ {
+		if got := sendVia(t, r, m); got != "" {
+			t.Fatalf("attempt %d: want failure, got %s", i, got)
+		}
+	}
+	if got := sendVia(t, r, m); got != "backup" {
+		t.Fatalf("want failover to backup, got %q", got)
+	}
+
+	// after the cooldown the primary is tried again and a success restores it
+	primaryDown = false
+	now = now.Add(time.Minute)
+	if got := sendVia(t, r, m); got != "primary" {
+		t.Fatalf("want primary after cooldown, got %q", got)
+	}
+
+	// with every breaker open the message is deferred until one reopens
+	primaryDown, backupDown = true, true
+	for i := 0; i < 4; i++ {
+		p, err := r.ChooseProvider(m)
+		if err != nil {
+			break
+		}
+		_, _ = p.Send(context.Background(), m)
+	}
+	_, err = r.ChooseProvider(m)
+	var d *DeferredError
+	if !errors.As(err, &d) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want deferred ErrCircuitOpen, got %v", err)
+	}
+	if want := now.Add(time.Minute); !d.Until.Equal(want) {
+		t.Fatalf("deferred until %s, want %s", d.Until, want)
+	}
+	if got := r.Breakers()["primary"].State; got != BreakerOpen {
+		t.Fatalf("primary breaker %s, want open", got)
+	}
+}
+
+func TestNewRoutingEngineUnknownProvider(t *testing.T) {
+	_, err := NewRoutingEngine(RoutingConfig{Rules: []RouteRule{
+		{Providers: []WeightedProvider{{Name: "missing"}}},
+	}}, map[string]RouteProvider{})
+	if err == nil {
+		t.Fatal("want error for unknown provider")
+	}
+}
+
+func TestRoutingEngineReleasedProbe(t *testing.T) {
+	phone := []domain.Channel{domain.ChannelSMS, domain.ChannelMMS}
+	down := true
+	r, err := NewRoutingEngine(RoutingConfig{
+		Breaker: BreakerOptions{ConsecutiveFailures: 1, OpenFor: time.Minute},
+		Rules:   []RouteRule{{Providers: []WeightedProvider{{Name: "primary"}}}},
+	}, map[string]RouteProvider{
+		"primary": {Provider: flakyProv{"primary", &down}, Channels: phone},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	r.breakers["primary"].now = func() time.Time { return now }
+	m := smsTo("+18045551234", 1)
+
+	sendVia(t, r, m)
+	now = now.Add(time.Minute)
+
+	// the probe is handed out, but the send is held back (e.g. rate limited)
+	p, err := r.ChooseProvider(m)
+	if err != nil {
+		t.Fatalf("choose probe: %v", err)
+	}
+	if _, err := r.ChooseProvider(m); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want a single probe, got %v", err)
+	}
+	release(p)
+
+	// the next message probes right away instead of waiting another minute
+	down = false
+	if got := sendVia(t, r, m); got != "primary" {
+		t.Fatalf("want probe after release, got %q", got)
+	}
+	if got := r.Breakers()["primary"].State; got != BreakerClosed {
+		t.Fatalf("breaker %s, want closed", got)
+	}
+}
+
+func TestRoutingEngineRepicksAfterLostClaim(t *testing.T) {
+	phone := []domain.Channel{domain.ChannelSMS, domain.ChannelMMS}
+	aDown, bDown, backupDown := true, false, false
+	r, err := NewRoutingEngine(RoutingConfig{
+		Breaker: BreakerOptions{ConsecutiveFailures: 1, OpenFor: time.Minute},
+		Rules: []RouteRule{
+			{Providers: []WeightedProvider{{Name: "a"}, {Name: "b"}}, Failover: []string{"backup"}},
+		},
+	}, map[string]RouteProvider{
+		"a":      {Provider: flakyProv{"a", &aDown}, Channels: phone},
+		"b":      {Provider: flakyProv{"b", &bDown}, Channels: phone},
+		"backup": {Provider: flakyProv{"backup", &backupDown}, Channels: phone},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+	for _, b := range r.breakers {
+		b.now = func() time.Time { return now }
+	}
+	m := smsTo("+18045551234", 1)
+
+	// trip a, then let its cooldown pass so it is ready for a probe
+	r.rnd = func() float64 { return 0 }
+	sendVia(t, r, m)
+	now = now.Add(time.Minute)
+
+	// another send takes a's probe between the health check and the claim
+	r.rnd = func() float64 {
+		r.breakers["a"].claim()
+		r.rnd = func() float64 { return 0 }
+		return 0
+	}
+	if got := sendVia(t, r, m); got != "b" {
+		t.Fatalf("want the remaining weighted provider b, got %q", got)
+	}
+}
@@ -2,11 +2,32 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/logging"
+	"github.com/rdavison/messaging-service/internal/provider"
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
+// DeferredError is returned by a Router that cannot hand out a provider until
+// a later time. The message is left as it is and claimed again after Until.
+type DeferredError struct {
+	Until time.Time
+	Err   error
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("deferred until %s: %v", e.Until.Format(time.RFC3339), e.Err)
+}
+
+func (e *DeferredError) Unwrap() error { return e.Err }
+
 func (e *Entrypoint) TransitionStatus(ctx context.Context, id int64) (domain.Status, error) {
 	// load message
 	m, err := e.msgs.GetByID(ctx, id)
@@ -22,31 +43,122 @@ func (e *Entrypoint) TransitionStatus(ctx context.Context, id int64) (domain.Sta
 	// choose a provider to handle the current message type
 	prov, err := e.router.ChooseProvider(m)
 	if err != nil {
+		// no provider can take it right now; try again later without
+		// counting an attempt
+		var d *DeferredError
+		if errors.As(err, &d) {
+			return e.postpone(ctx, m, d)
+		}
 		// route failure -> retry with payload
 		payload := "route error: " + err.Error()
-		_ = e.msgs.UpdateStatus(ctx, id, domain.StatusRetry, nil, nil, &payload)
-		return domain.StatusRetry, err
+		status, _ := e.record(ctx, m, domain.StatusRetry, nil, nil, &payload)
+		return status, err
+	}
+
+	ctx = logging.With(ctx, "provider_id", providerName(prov))
+
+	// hold the message back while the provider, its sender or its tenant is
+	// over budget
+	if until, ok := e.limiter.Reserve(providerName(prov), m); !ok {
+		release(prov)
+		return e.postpone(ctx, m, &DeferredError{Until: until, Err: ErrRateLimited})
+	}
+
+	// the lease may have run out while the message waited in its batch; only
+	// send while it is still ours, for long enough to record the outcome
+	if err := e.msgs.RenewLease(ctx, m.ID, e.owner, e.lease); err != nil {
+		release(prov)
+		return m.Status, err
 	}
 
 	// send via provider; providers encapsulate the "send_and_transition_status" logic
-	resp, sendErr := prov.Send(ctx, m)
+	sctx, span := tracing.Start(ctx, "provider.Send", trace.SpanKindClient)
+	span.SetAttributes(
+		attribute.String("messaging.provider", providerName(prov)),
+		attribute.String("messaging.channel", string(m.Channel())),
+	)
+	start := time.Now()
+	resp, sendErr := prov.Send(sctx, m)
+	observeSend(prov, m.Channel(), resp, sendErr, time.Since(start))
+	span.SetAttributes(attribute.String("messaging.provider.status", string(resp.Status)))
+	tracing.RecordError(span, sendErr)
+	span.End()
 	if sendErr != nil {
 		payload := "send error: " + sendErr.Error()
-		_ = e.msgs.UpdateStatus(ctx, id, domain.StatusRetry, nil, nil, &payload)
-		return domain.StatusRetry, sendErr
+		status, _ := e.record(ctx, m, domain.StatusRetry, nil, nil, &payload)
+		return status, sendErr
 	}
 
 	// For the processor, mutate the CURRENT row by id.
 	// Use the conflict-safe guarded UPDATE so we never violate the unique (provider_id, provider_message_id).
 	if resp.ProviderID != "" && resp.ProviderMessageID != "" {
-		fmt.Printf("status = %s ; provider = %s ; message_id = %s ; ", resp.Status, resp.ProviderID, resp.ProviderMessageID)
-		if err := e.msgs.UpdateStatus(ctx, id, resp.Status, &resp.ProviderID, &resp.ProviderMessageID, resp.StatusPayload); err != nil {
+		e.logger.InfoContext(ctx, "provider accepted message", "status", resp.Status, "provider", resp.ProviderID, "provider_message_id", resp.ProviderMessageID)
+		status, err := e.record(ctx, m, resp.Status, &resp.ProviderID, &resp.ProviderMessageID, resp.StatusPayload)
+		if err != nil {
 			return "", fmt.Errorf("update status with provider: %w", err)
 		}
-	} else {
-		if err := e.msgs.UpdateStatus(ctx, id, resp.Status, nil, nil, resp.StatusPayload); err != nil {
-			return "", fmt.Errorf("update status: %w", err)
+		return status, nil
+	}
+	status, err := e.record(ctx, m, resp.Status, nil, nil, resp.StatusPayload)
+	if err != nil {
+		return "", fmt.Errorf("update status: %w", err)
+	}
+	return status, nil
+}
+
+// postpone leaves m as it is until d.Until, without counting an attempt.
+func (e *Entrypoint) postpone(ctx context.Context, m domain.Message, d *DeferredError) (domain.Status, error) {
+	if err := e.msgs.Defer(ctx, m.ID, e.owner, d.Until); err != nil {
+		return "", fmt.Errorf("defer message: %w", err)
+	}
+	e.wakeBy(d.Until)
+	observeDeferred(d)
+	return m.Status, d
+}
+
+// providerName is the name a provider is rate limited under: its routing name
+// if the Router gave it one.
+func providerName(p provider.Provider) string {
+	if n, ok := p.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// release lets the Router hand out whatever it reserved for a send through p
+// that is not made, such as a circuit breaker's probe.
+func release(p provider.Provider) {
+	if r, ok := p.(interface{ Release() }); ok {
+		r.Release()
+	}
+}
+
+// record persists the outcome of an attempt. A retry is scheduled according to
+// the channel's backoff policy, or promoted to failed once the policy's
+// attempts are exhausted. Returns the status actually stored, or
+// repo.ErrLeaseLost if the message is no longer ours to update.
+func (e *Entrypoint) record(
+	ctx context.Context,
+	m domain.Message,
+	status domain.Status,
+	providerID, providerMessageID *string,
+	payload *string,
+) (domain.Status, error) {
+	var next *time.Time
+	if status == domain.StatusRetry {
+		attempt := m.AttemptCount + 1
+		next = e.backoff.nextAttempt(m.Channel(), attempt, time.Now())
+		if next == nil {
+			status = domain.StatusFailed
+			reason := fmt.Sprintf("giving up after %d attempts", attempt)
+			if payload != nil {
+				reason += ": " + *payload
+			}
+			payload = &reason
 		}
 	}
-	return resp.Status, nil
+	if err := e.msgs.UpdateStatus(ctx, m.ID, e.owner, status, providerID, providerMessageID, payload, next); err != nil {
+		return status, err
+	}
+	return status, nil
 }
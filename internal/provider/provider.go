@@ -2,6 +2,8 @@ package provider
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/rdavison/messaging-service/internal/domain"
 )
@@ -18,3 +20,24 @@ type Response struct {
 type Provider interface {
 	Send(ctx context.Context, m domain.Message) (Response, error)
 }
+
+// maxResponseBody bounds how much of a provider response we read.
+const maxResponseBody = 1 << 20
+
+var defaultHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return defaultHTTPClient
+}
+
+// statusForHTTP classifies a non-2xx provider response: rate limiting and
+// server errors are retried, anything else is a permanent failure.
+func statusForHTTP(code int) domain.Status {
+	if code == http.StatusTooManyRequests || code >= 500 {
+		return domain.StatusRetry
+	}
+	return domain.StatusFailed
+}
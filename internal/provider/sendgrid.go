@@ -1,35 +1,299 @@
 package provider
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/netguard"
 )
 
-type SendgridProvider struct{}
+const SendgridDefaultBaseURL = "https://api.sendgrid.com"
+
+// How attachments are delivered by SendgridProvider.
+const (
+	SendgridAttachmentsInline = "inline" // fetched and base64-encoded into the mail
+	SendgridAttachmentsLink   = "link"   // listed as URLs at the end of the body
+)
+
+// maxAttachmentSize bounds a single fetched attachment.
+const maxAttachmentSize = 10 << 20
+
+// SendgridProvider sends email through the SendGrid v3 Mail Send API.
+type SendgridProvider struct {
+	APIKey      string
+	BaseURL     string // defaults to SendgridDefaultBaseURL; overridden in tests
+	Subject     string // used for every mail; derived from the body when empty
+	Attachments string // SendgridAttachmentsInline (default) or SendgridAttachmentsLink
+	Client      *http.Client
+
+	// AttachmentClient fetches inline attachments. Their URLs come from API
+	// clients, so it defaults to one that only fetches https URLs from
+	// public addresses.
+	AttachmentClient *http.Client
+}
+
+var defaultAttachmentClient = netguard.Client(netguard.Options{Timeout: 15 * time.Second})
+
+type sendgridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendgridPersonalization struct {
+	To []sendgridAddress `json:"to"`
+}
+
+type sendgridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendgridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+}
+
+type sendgridMail struct {
+	Personalizations []sendgridPersonalization `json:"personalizations"`
+	From             sendgridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendgridContent         `json:"content"`
+	Attachments      []sendgridAttachment      `json:"attachments,omitempty"`
+	CustomArgs       map[string]string         `json:"custom_args,omitempty"`
+}
+
+type sendgridErrors struct {
+	Errors []struct {
+		Message string  `json:"message"`
+		Field   *string `json:"field"`
+	} `json:"errors"`
+}
+
+var (
+	htmlTagRe   = regexp.MustCompile(`(?i)<\s*/?\s*[a-z][a-z0-9]*(\s[^>]*)?>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/li|/h[1-6])\s*/?\s*>`)
+)
+
+// isHTML reports whether body looks like markup rather than plain text.
+func isHTML(body string) bool { return htmlTagRe.MatchString(body) }
+
+// htmlToText is a best-effort plain-text alternative for an HTML body.
+func htmlToText(body string) string {
+	s := htmlBreakRe.ReplaceAllString(body, "\n")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// subjectFrom takes the first non-empty line of text, shortened to fit a
+// subject header.
+func subjectFrom(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if r := []rune(line); len(r) > 78 {
+			line = string(r[:75]) + "..."
+		}
+		return line
+	}
+	return "(no subject)"
+}
 
 func (s SendgridProvider) Send(ctx context.Context, m domain.Message) (Response, error) {
-	// Simulate latency
-	time.Sleep(time.Duration(100+rand.Intn(200)) * time.Millisecond)
-
-	// Randomize outcome
-	outcomes := []domain.Status{
-		domain.StatusOK,
-		domain.StatusFailed,
-		domain.StatusRetry,
-	}
-	status := outcomes[rand.Intn(len(outcomes))]
-	// TODO: call SendGrid API; on success:
-	pmID := fmt.Sprintf("sendgrid-%s", uuid.NewString())
-	payload := "Twilio simulated status: " + string(status)
+	if s.APIKey == "" {
+		return Response{}, fmt.Errorf("sendgrid: api key is required")
+	}
+
+	text := m.Body
+	var content []sendgridContent
+	if isHTML(m.Body) {
+		text = htmlToText(m.Body)
+		// text/plain must precede text/html
+		content = []sendgridContent{{Type: "text/plain", Value: text}, {Type: "text/html", Value: m.Body}}
+	} else {
+		content = []sendgridContent{{Type: "text/plain", Value: m.Body}}
+	}
+
+	mail := sendgridMail{
+		Personalizations: []sendgridPersonalization{{To: []sendgridAddress{{Email: m.Target.Payload}}}},
+		From:             sendgridAddress{Email: m.Source.Payload},
+		Subject:          s.Subject,
+		Content:          content,
+		CustomArgs:       map[string]string{"message_id": strconv.FormatInt(m.ID, 10)},
+	}
+	if mail.Subject == "" {
+		mail.Subject = subjectFrom(text)
+	}
+
+	if len(m.Attachments) > 0 {
+		if s.Attachments == SendgridAttachmentsLink {
+			appendLinks(mail.Content, m.Attachments)
+		} else {
+			for _, a := range m.Attachments {
+				att, failed, err := s.fetchAttachment(ctx, string(a))
+				if err != nil {
+					return Response{}, err
+				}
+				if failed != nil {
+					return Response{ProviderID: domain.ProviderSendgrid.String(), Status: domain.StatusFailed, StatusPayload: failed}, nil
+				}
+				mail.Attachments = append(mail.Attachments, att)
+			}
+		}
+	}
+
+	b, err := json.Marshal(mail)
+	if err != nil {
+		return Response{}, fmt.Errorf("sendgrid: encode mail: %w", err)
+	}
+	base := s.BaseURL
+	if base == "" {
+		base = SendgridDefaultBaseURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+"/v3/mail/send", bytes.NewReader(b))
+	if err != nil {
+		return Response{}, fmt.Errorf("sendgrid: build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := httpClient(s.Client).Do(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("sendgrid: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	if err != nil {
+		return Response{}, fmt.Errorf("sendgrid: read response: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var se sendgridErrors
+		_ = json.Unmarshal(body, &se)
+		msgs := make([]string, 0, len(se.Errors))
+		for _, e := range se.Errors {
+			if e.Field != nil && *e.Field != "" {
+				msgs = append(msgs, *e.Field+": "+e.Message)
+			} else {
+				msgs = append(msgs, e.Message)
+			}
+		}
+		payload := fmt.Sprintf("sendgrid http %d", res.StatusCode)
+		if len(msgs) > 0 {
+			payload += ": " + strings.Join(msgs, "; ")
+		}
+		return Response{
+			ProviderID:    domain.ProviderSendgrid.String(),
+			Status:        statusForHTTP(res.StatusCode),
+			StatusPayload: &payload,
+		}, nil
+	}
+
+	// without the id, SendGrid's event callbacks can not be matched to the
+	// message; the send is retried rather than recorded as sent
+	id := res.Header.Get("X-Message-Id")
+	if id == "" {
+		slog.ErrorContext(ctx, "sendgrid accepted a message without X-Message-Id", "status_code", res.StatusCode)
+		return Response{ProviderID: domain.ProviderSendgrid.String()}, fmt.Errorf("sendgrid: http %d response has no X-Message-Id", res.StatusCode)
+	}
+	payload := fmt.Sprintf("sendgrid http %d: accepted", res.StatusCode)
 	return Response{
-		ProviderID:        "sendgrid",
-		ProviderMessageID: pmID,
-		Status:            status,
+		ProviderID:        domain.ProviderSendgrid.String(),
+		ProviderMessageID: id,
+		Status:            domain.StatusOK,
 		StatusPayload:     &payload,
 	}, nil
 }
+
+// appendLinks adds attachment URLs to the end of every content part.
+func appendLinks(content []sendgridContent, atts []domain.Attachment) {
+	for i := range content {
+		var b strings.Builder
+		b.WriteString(content[i].Value)
+		if content[i].Type == "text/html" {
+			b.WriteString("<p>Attachments:</p><ul>")
+			for _, a := range atts {
+				u := html.EscapeString(string(a))
+				fmt.Fprintf(&b, `<li><a href="%s">%s</a></li>`, u, u)
+			}
+			b.WriteString("</ul>")
+		} else {
+			b.WriteString("\n\nAttachments:\n")
+			for _, a := range atts {
+				b.WriteString(string(a) + "\n")
+			}
+		}
+		content[i].Value = b.String()
+	}
+}
+
+// fetchAttachment downloads rawURL for inline delivery. A non-nil failed
+// payload means the attachment can never be fetched; err is returned for
+// transient problems worth retrying.
+func (s SendgridProvider) fetchAttachment(ctx context.Context, rawURL string) (att sendgridAttachment, failed *string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		p := fmt.Sprintf("sendgrid: bad attachment url %q: %v", rawURL, err)
+		return att, &p, nil
+	}
+	client := s.AttachmentClient
+	if client == nil {
+		client = defaultAttachmentClient
+	}
+	res, err := client.Do(req)
+	if errors.Is(err, netguard.ErrForbidden) {
+		p := fmt.Sprintf("sendgrid: attachment %q refused: %v", rawURL, err)
+		return att, &p, nil
+	}
+	if err != nil {
+		return att, nil, fmt.Errorf("sendgrid: fetch attachment %q: %w", rawURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		if statusForHTTP(res.StatusCode) == domain.StatusRetry {
+			return att, nil, fmt.Errorf("sendgrid: fetch attachment %q: http %d", rawURL, res.StatusCode)
+		}
+		p := fmt.Sprintf("sendgrid: fetch attachment %q: http %d", rawURL, res.StatusCode)
+		return att, &p, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxAttachmentSize+1))
+	if err != nil {
+		return att, nil, fmt.Errorf("sendgrid: read attachment %q: %w", rawURL, err)
+	}
+	if len(data) > maxAttachmentSize {
+		p := fmt.Sprintf("sendgrid: attachment %q exceeds %d bytes", rawURL, maxAttachmentSize)
+		return att, &p, nil
+	}
+
+	name := path.Base(req.URL.Path)
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+	ctype := res.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ctype); err == nil {
+		ctype = mt
+	}
+	return sendgridAttachment{
+		Content:     base64.StdEncoding.EncodeToString(data),
+		Type:        ctype,
+		Filename:    name,
+		Disposition: "attachment",
+	}, nil, nil
+}
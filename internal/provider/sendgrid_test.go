@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func sendgridTestMessage(body string, attachments ...domain.Attachment) domain.Message {
+	return domain.Message{
+		ID:          42,
+		Source:      domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "from@example.com"},
+		Target:      domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "to@example.com"},
+		Direction:   domain.Outbound,
+		Body:        body,
+		Attachments: attachments,
+		Status:      domain.StatusOutbox,
+	}
+}
+
+func TestSendgridSendHTMLWithAttachment(t *testing.T) {
+	var got sendgridMail
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF-1.4"))
+		case "/v3/mail/send":
+			if r.Header.Get("Authorization") != "Bearer SG.key" {
+				t.Errorf("authorization = %q", r.Header.Get("Authorization"))
+			}
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Errorf("decode mail: %v", err)
+			}
+			w.Header().Set("X-Message-Id", "sg-123")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL, AttachmentClient: srv.Client()}
+	resp, err := p.Send(context.Background(), sendgridTestMessage("Hello <b>there</b>", domain.Attachment(srv.URL+"/files/doc.pdf")))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.Status != domain.StatusOK || resp.ProviderID != "sendgrid" || resp.ProviderMessageID != "sg-123" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if len(got.Personalizations) != 1 || got.Personalizations[0].To[0].Email != "to@example.com" || got.From.Email != "from@example.com" {
+		t.Fatalf("bad addressing: %+v", got)
+	}
+	if len(got.Content) != 2 || got.Content[0].Type != "text/plain" || got.Content[0].Value != "Hello there" || got.Content[1].Type != "text/html" {
+		t.Fatalf("bad content: %+v", got.Content)
+	}
+	if got.Subject != "Hello there" {
+		t.Fatalf("subject = %q", got.Subject)
+	}
+	if got.CustomArgs["message_id"] != "42" {
+		t.Fatalf("custom_args = %v", got.CustomArgs)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("attachments = %+v", got.Attachments)
+	}
+	att := got.Attachments[0]
+	data, _ := base64.StdEncoding.DecodeString(att.Content)
+	if att.Filename != "doc.pdf" || att.Type != "application/pdf" || string(data) != "%PDF-1.4" {
+		t.Fatalf("attachment = %+v", att)
+	}
+}
+
+func TestSendgridSendPlainTextWithLinks(t *testing.T) {
+	var got sendgridMail
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("X-Message-Id", "sg-124")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL, Subject: "Hi", Attachments: SendgridAttachmentsLink}
+	if _, err := p.Send(context.Background(), sendgridTestMessage("plain body", "https://example.com/a.pdf")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(got.Content) != 1 || got.Content[0].Type != "text/plain" {
+		t.Fatalf("content = %+v", got.Content)
+	}
+	if !strings.Contains(got.Content[0].Value, "https://example.com/a.pdf") || len(got.Attachments) != 0 {
+		t.Fatalf("expected link in body, got %+v", got)
+	}
+	if got.Subject != "Hi" {
+		t.Fatalf("subject = %q", got.Subject)
+	}
+}
+
+func TestSendgridSendWithoutMessageID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL}
+	resp, err := p.Send(context.Background(), sendgridTestMessage("hi"))
+	if err == nil || !strings.Contains(err.Error(), "X-Message-Id") {
+		t.Fatalf("want an error about the missing id, got %v", err)
+	}
+	if resp.Status == domain.StatusOK || resp.ProviderMessageID != "" {
+		t.Fatalf("recorded as sent: %+v", resp)
+	}
+}
+
+func TestSendgridSendClassifiesErrors(t *testing.T) {
+	cases := []struct {
+		code int
+		want domain.Status
+	}{
+		{http.StatusTooManyRequests, domain.StatusRetry},
+		{http.StatusServiceUnavailable, domain.StatusRetry},
+		{http.StatusBadRequest, domain.StatusFailed},
+	}
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.code)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"nope","field":"from.email"}]}`))
+		}))
+		p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL}
+		resp, err := p.Send(context.Background(), sendgridTestMessage("hi"))
+		srv.Close()
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if resp.Status != c.want {
+			t.Fatalf("http %d: status = %q, want %q", c.code, resp.Status, c.want)
+		}
+		if resp.StatusPayload == nil || !strings.Contains(*resp.StatusPayload, "from.email: nope") {
+			t.Fatalf("payload = %v", resp.StatusPayload)
+		}
+	}
+}
+
+func TestSendgridMissingAttachmentFails(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL, AttachmentClient: srv.Client()}
+	resp, err := p.Send(context.Background(), sendgridTestMessage("hi", domain.Attachment(srv.URL+"/missing.png")))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.Status != domain.StatusFailed {
+		t.Fatalf("status = %q, want failed", resp.Status)
+	}
+}
+
+func TestSendgridRefusesPrivateAttachment(t *testing.T) {
+	fetched := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/mail/send" {
+			fetched = true
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := SendgridProvider{APIKey: "SG.key", BaseURL: srv.URL}
+	for _, u := range []string{srv.URL + "/secret", "https://169.254.169.254/latest/meta-data/", "https://127.0.0.1:1/x"} {
+		resp, err := p.Send(context.Background(), sendgridTestMessage("hi", domain.Attachment(u)))
+		if err != nil {
+			t.Fatalf("%s: Send: %v", u, err)
+		}
+		if resp.Status != domain.StatusFailed || resp.StatusPayload == nil || !strings.Contains(*resp.StatusPayload, "refused") {
+			t.Fatalf("%s: response = %+v", u, resp)
+		}
+	}
+	if fetched {
+		t.Fatal("a private attachment url was fetched")
+	}
+}
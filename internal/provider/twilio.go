@@ -2,33 +2,134 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"math/rand"
-	"time"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
 
-	"github.com/google/uuid"
 	"github.com/rdavison/messaging-service/internal/domain"
 )
 
-type TwilioProvider struct{}
+const TwilioDefaultBaseURL = "https://api.twilio.com"
+
+// TwilioProvider sends SMS/MMS through the Twilio Messages REST API.
+type TwilioProvider struct {
+	AccountSID     string
+	AuthToken      string
+	BaseURL        string // defaults to TwilioDefaultBaseURL; overridden in tests
+	StatusCallback string // optional URL Twilio posts delivery updates to
+	Client         *http.Client
+}
+
+// twilioMessage is the subset of the Message resource we read back.
+type twilioMessage struct {
+	SID          string  `json:"sid"`
+	Status       string  `json:"status"`
+	ErrorCode    *int    `json:"error_code"`
+	ErrorMessage *string `json:"error_message"`
+}
+
+// twilioError is the body Twilio returns on a non-2xx response.
+type twilioError struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	MoreInfo string `json:"more_info"`
+	Status   int    `json:"status"`
+}
+
+// Twilio error codes that are worth retrying even though they come back as 4xx.
+var twilioRetryableCodes = map[int]bool{
+	20429: true, // too many requests
+	20500: true, // internal server error
+	20503: true, // service unavailable
+	30001: true, // queue overflow
+	30017: true, // carrier network congestion
+}
 
 func (t TwilioProvider) Send(ctx context.Context, m domain.Message) (Response, error) {
-	// Simulate latency
-	time.Sleep(time.Duration(100+rand.Intn(200)) * time.Millisecond)
-
-	// Randomize outcome
-	outcomes := []domain.Status{
-		domain.StatusOK,
-		domain.StatusFailed,
-		domain.StatusRetry,
-	}
-	status := outcomes[rand.Intn(len(outcomes))]
-	// TODO: call Twilio API; on success:
-	pmID := fmt.Sprintf("twilio-%s", uuid.NewString())
-	payload := "Twilio simulated status: " + string(status)
+	if t.AccountSID == "" || t.AuthToken == "" {
+		return Response{}, fmt.Errorf("twilio: account sid and auth token are required")
+	}
+
+	form := url.Values{}
+	form.Set("From", m.Source.Payload)
+	form.Set("To", m.Target.Payload)
+	form.Set("Body", m.Body)
+	if m.Channel() == domain.ChannelMMS {
+		for _, a := range m.Attachments {
+			form.Add("MediaUrl", string(a))
+		}
+	}
+	if t.StatusCallback != "" {
+		form.Set("StatusCallback", t.StatusCallback)
+	}
+
+	base := t.BaseURL
+	if base == "" {
+		base = TwilioDefaultBaseURL
+	}
+	endpoint := strings.TrimRight(base, "/") + "/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Response{}, fmt.Errorf("twilio: build request: %w", err)
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient(t.Client).Do(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("twilio: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	if err != nil {
+		return Response{}, fmt.Errorf("twilio: read response: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var te twilioError
+		_ = json.Unmarshal(body, &te)
+		status := statusForHTTP(res.StatusCode)
+		if twilioRetryableCodes[te.Code] {
+			status = domain.StatusRetry
+		}
+		payload := fmt.Sprintf("twilio http %d", res.StatusCode)
+		if te.Code != 0 || te.Message != "" {
+			payload = fmt.Sprintf("twilio http %d: error %d: %s", res.StatusCode, te.Code, te.Message)
+		}
+		return Response{
+			ProviderID:    domain.ProviderTwilio.String(),
+			Status:        status,
+			StatusPayload: &payload,
+		}, nil
+	}
+
+	var tm twilioMessage
+	if err := json.Unmarshal(body, &tm); err != nil {
+		return Response{}, fmt.Errorf("twilio: decode response: %w", err)
+	}
+	status := domain.StatusOK
+	payload := "twilio status: " + tm.Status
+	switch tm.Status {
+	case "failed", "undelivered", "canceled":
+		status = domain.StatusFailed
+		if tm.ErrorCode != nil && twilioRetryableCodes[*tm.ErrorCode] {
+			status = domain.StatusRetry
+		}
+	}
+	if tm.ErrorCode != nil {
+		payload += fmt.Sprintf(" (error %d", *tm.ErrorCode)
+		if tm.ErrorMessage != nil {
+			payload += ": " + *tm.ErrorMessage
+		}
+		payload += ")"
+	}
 	return Response{
-		ProviderID:        "twilio",
-		ProviderMessageID: pmID,
+		ProviderID:        domain.ProviderTwilio.String(),
+		ProviderMessageID: tm.SID,
 		Status:            status,
 		StatusPayload:     &payload,
 	}, nil
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func twilioTestMessage(ch domain.PhoneChannel, attachments ...domain.Attachment) domain.Message {
+	return domain.Message{
+		ID:          1,
+		Source:      domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: "+12016661234"},
+		Target:      domain.Endpoint{Kind: domain.EndpointKindPhone, Channel: &ch, Payload: "+18045551234"},
+		Direction:   domain.Outbound,
+		Body:        "hi",
+		Attachments: attachments,
+		Status:      domain.StatusOutbox,
+	}
+}
+
+func TestTwilioSendOK(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "AC123" || pass != "secret" {
+			t.Errorf("basic auth = %q %q %v", user, pass, ok)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("parse form: %v", err)
+		}
+		if got := r.PostForm.Get("To"); got != "+18045551234" {
+			t.Errorf("To = %q", got)
+		}
+		if got := r.PostForm["MediaUrl"]; len(got) != 1 || got[0] != "https://example.com/a.jpg" {
+			t.Errorf("MediaUrl = %v", got)
+		}
+		if got := r.PostForm.Get("StatusCallback"); got != "https://cb.example.com/status" {
+			t.Errorf("StatusCallback = %q", got)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"sid":"SM1","status":"queued","error_code":null,"error_message":null}`))
+	}))
+	defer srv.Close()
+
+	p := TwilioProvider{AccountSID: "AC123", AuthToken: "secret", BaseURL: srv.URL, StatusCallback: "https://cb.example.com/status"}
+	resp, err := p.Send(context.Background(), twilioTestMessage(domain.PhoneChannelMMS, "https://example.com/a.jpg"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.Status != domain.StatusOK || resp.ProviderID != "twilio" || resp.ProviderMessageID != "SM1" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestTwilioSendClassifiesErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		code int
+		body string
+		want domain.Status
+	}{
+		{"rate limited", http.StatusTooManyRequests, `{"code":20429,"message":"Too Many Requests"}`, domain.StatusRetry},
+		{"server error", http.StatusBadGateway, ``, domain.StatusRetry},
+		{"invalid number", http.StatusBadRequest, `{"code":21211,"message":"Invalid 'To' Phone Number"}`, domain.StatusFailed},
+		{"queue overflow", http.StatusBadRequest, `{"code":30001,"message":"Queue overflow"}`, domain.StatusRetry},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.code)
+				_, _ = w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			p := TwilioProvider{AccountSID: "AC123", AuthToken: "secret", BaseURL: srv.URL}
+			resp, err := p.Send(context.Background(), twilioTestMessage(domain.PhoneChannelSMS))
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if resp.Status != c.want {
+				t.Fatalf("status = %q, want %q", resp.Status, c.want)
+			}
+			if resp.StatusPayload == nil || !strings.Contains(*resp.StatusPayload, "twilio http") {
+				t.Fatalf("payload = %v", resp.StatusPayload)
+			}
+		})
+	}
+}
+
+func TestTwilioSendRequiresCredentials(t *testing.T) {
+	if _, err := (TwilioProvider{}).Send(context.Background(), twilioTestMessage(domain.PhoneChannelSMS)); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+}
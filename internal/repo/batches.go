@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+type BatchRepo struct {
+	Pool *pgxpool.Pool
+}
+
+func NewBatchRepo(pool *pgxpool.Pool) *BatchRepo {
+	return &BatchRepo{Pool: pool}
+}
+
+// BatchItem is one accepted recipient of a batch send.
+type BatchItem struct {
+	To   string
+	Body string
+}
+
+// Create records a batch of total recipients and inserts a message per item,
+// copying everything but the target and body from proto. Conversations are
+// found or created for all distinct targets in one statement and the
+// messages are inserted in another, in a single transaction. Returns the
+// batch id and the message ids in item order.
+func (r *BatchRepo) Create(ctx context.Context, proto domain.Message, items []BatchItem, total int) (int64, []int64, error) {
+	tenantID := tenantOrDefault(proto.TenantID)
+
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var batchID int64
+	const insBatch = `INSERT INTO batches (tenant_id, total, rejected) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRow(ctx, insBatch, tenantID, total, total-len(items)).Scan(&batchID); err != nil {
+		return 0, nil, fmt.Errorf("insert batch: %w", err)
+	}
+	if len(items) == 0 {
+		return batchID, nil, tx.Commit(ctx)
+	}
+
+	var phoneCh *string
+	if proto.Source.Channel != nil {
+		v := proto.Source.Channel.String()
+		phoneCh = &v
+	}
+
+	targets := make([]string, len(items))
+	bodies := make([]string, len(items))
+	for i, it := range items {
+		targets[i] = it.To
+		bodies[i] = it.Body
+	}
+
+	const convs = `
+WITH input AS (
+  SELECT DISTINCT t AS tgt FROM unnest($1::text[]) AS t
+),
+existing AS (
+  SELECT DISTINCT ON (i.tgt) i.tgt, c.id
+  FROM input i
+  JOIN conversations c ON
+    c.tenant_id = $2 AND
+    c.endpoint_kind = $3::endpoint_kind AND
+    c.phone_channel IS NOT DISTINCT FROM $4::phone_channel AND
+    LEAST(c.endpoint_source, c.endpoint_target) = LEAST($5::text, i.tgt) AND
+    GREATEST(c.endpoint_source, c.endpoint_target) = GREATEST($5::text, i.tgt)
+  ORDER BY i.tgt, c.id
+),
+inserted AS (
+  INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target, tenant_id)
+  SELECT $3::endpoint_kind, $4::phone_channel, $5::text, i.tgt, $2
+  FROM input i
+  WHERE NOT EXISTS (SELECT 1 FROM existing e WHERE e.tgt = i.tgt)
+  RETURNING endpoint_target AS tgt, id
+)
+SELECT tgt, id FROM existing
+UNION ALL
+SELECT tgt, id FROM inserted
+`
+	rows, err := tx.Query(ctx, convs, targets, tenantID, proto.Source.Kind.String(), phoneCh, proto.Source.Payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("get or create conversations: %w", err)
+	}
+	convByTarget := make(map[string]int64)
+	for rows.Next() {
+		var tgt string
+		var id int64
+		if err := rows.Scan(&tgt, &id); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		convByTarget[tgt] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("get or create conversations: %w", err)
+	}
+
+	// ids are taken up front so they can be returned in item order;
+	// INSERT ... RETURNING does not promise any order
+	ids := make([]int64, 0, len(items))
+	const seq = `SELECT nextval(pg_get_serial_sequence('messages', 'id')) FROM generate_series(1, $1)`
+	rows, err = tx.Query(ctx, seq, len(items))
+	if err != nil {
+		return 0, nil, fmt.Errorf("allocate message ids: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("allocate message ids: %w", err)
+	}
+
+	tmplID, tmplVersion := templateColumns(proto.TemplateRef)
+	convIDs := make([]int64, len(items))
+	for i, it := range items {
+		convIDs[i] = convByTarget[it.To]
+	}
+
+	const insMsgs = `
+INSERT INTO messages (
+  id, conversation_id, endpoint_source, endpoint_target,
+  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
+  body, attachments, status_tag, tenant_id, send_at, batch_id,
+  template_id, template_version, traceparent, status_actor
+)
+SELECT
+  u.id, u.conv, $1, u.tgt,
+  $2::inbound_or_outbound, $3, $4::endpoint_kind, $5::phone_channel,
+  u.body, $6::jsonb, $7::status, $8, $9, $10,
+  $11, $12, $13, 'api'
+FROM unnest($14::bigint[], $15::bigint[], $16::text[], $17::text[]) AS u(id, conv, tgt, body)
+`
+	_, err = tx.Exec(ctx, insMsgs,
+		proto.Source.Payload,
+		string(proto.Direction),
+		proto.SentAt,
+		proto.Source.Kind.String(),
+		phoneCh,
+		nullableJSON(encodeAttachments(proto.Attachments)),
+		string(proto.Status),
+		tenantID,
+		proto.SendAt,
+		batchID,
+		tmplID, tmplVersion,
+		traceparent(ctx, proto),
+		ids, convIDs, targets, bodies,
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("insert batch messages: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, fmt.Errorf("commit: %w", err)
+	}
+	return batchID, ids, nil
+}
+
+// Get returns a tenant's batch with the current status counts of its messages.
+func (r *BatchRepo) Get(ctx context.Context, tenantID, id int64) (domain.Batch, error) {
+	b := domain.Batch{ID: id, TenantID: tenantID, Statuses: make(map[domain.Status]int)}
+	const sel = `SELECT total, rejected, created_at FROM batches WHERE id = $1 AND tenant_id = $2`
+	err := r.Pool.QueryRow(ctx, sel, id, tenantID).Scan(&b.Total, &b.Rejected, &b.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Batch{}, ErrNotFound
+		}
+		return domain.Batch{}, fmt.Errorf("get batch: %w", err)
+	}
+
+	const counts = `SELECT status_tag::text, count(*) FROM messages WHERE batch_id = $1 GROUP BY status_tag`
+	rows, err := r.Pool.Query(ctx, counts, id)
+	if err != nil {
+		return domain.Batch{}, fmt.Errorf("count batch messages: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st string
+		var n int
+		if err := rows.Scan(&st, &n); err != nil {
+			return domain.Batch{}, err
+		}
+		b.Statuses[domain.Status(st)] = n
+	}
+	return b, rows.Err()
+}
@@ -0,0 +1,87 @@
+//go:build integration
+// +build integration
+
+package repo
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestBatchCreate(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewBatchRepo(pool)
+	ctx := context.Background()
+
+	from := "batch-" + uuid.NewString() + "@example.com"
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE endpoint_source = $1`, from)
+	})
+
+	proto := domain.Message{
+		Source:    domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: from},
+		Direction: domain.Outbound,
+		SentAt:    time.Now(),
+		Status:    domain.StatusOutbox,
+	}
+	items := []BatchItem{
+		{To: "b1@example.com", Body: "hi one"},
+		{To: "b2@example.com", Body: "hi two"},
+		{To: "b1@example.com", Body: "hi again"},
+	}
+	batchID, ids, err := r.Create(ctx, proto, items, 4)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM batches WHERE id = $1`, batchID)
+	})
+	if len(ids) != len(items) {
+		t.Fatalf("want %d ids, got %d", len(items), len(ids))
+	}
+
+	msgs := NewMessageRepo(pool)
+	for i, id := range ids {
+		m, err := msgs.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("get %d: %v", id, err)
+		}
+		if m.Target.Payload != items[i].To || m.Body != items[i].Body {
+			t.Fatalf("item %d: got %s %q", i, m.Target.Payload, m.Body)
+		}
+		if m.BatchID == nil || *m.BatchID != batchID {
+			t.Fatalf("item %d: batch_id %v", i, m.BatchID)
+		}
+	}
+
+	var convs int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM conversations WHERE endpoint_source = $1`, from).Scan(&convs); err != nil {
+		t.Fatalf("count conversations: %v", err)
+	}
+	if convs != 2 {
+		t.Fatalf("want 2 conversations, got %d", convs)
+	}
+
+	b, err := r.Get(ctx, domain.DefaultTenantID, batchID)
+	if err != nil {
+		t.Fatalf("get batch: %v", err)
+	}
+	if b.Total != 4 || b.Rejected != 1 || b.Accepted() != 3 || b.Statuses[domain.StatusOutbox] != 3 {
+		t.Fatalf("unexpected progress: %+v", b)
+	}
+}
@@ -20,11 +20,12 @@ func NewConversationRepo(pool *pgxpool.Pool) *ConversationRepo {
 	return &ConversationRepo{Pool: pool}
 }
 
-// Get a Conversation by matching against its endpoints. The endpoints
+// Get a tenant's Conversation by matching against its endpoints. The endpoints
 // can be passed in any order. If not found, a new record is created.
 // On success, returns the id of the Conversation.
 func (r *ConversationRepo) GetOrCreateByEndpoints(
 	ctx context.Context,
+	tenantID int64,
 	source domain.Endpoint,
 	target domain.Endpoint,
 ) (int64, error) {
@@ -46,10 +47,11 @@ WHERE
   endpoint_kind = $1 AND
   phone_channel IS NOT DISTINCT FROM $2 AND
   LEAST(endpoint_source, endpoint_target) = LEAST($3, $4) AND
-  GREATEST(endpoint_source, endpoint_target) = GREATEST($3, $4)
+  GREATEST(endpoint_source, endpoint_target) = GREATEST($3, $4) AND
+  tenant_id = $5
 `
 	var id int64
-	err := r.Pool.QueryRow(ctx, sel, kind.String(), phoneCh, source.Payload, target.Payload).Scan(&id)
+	err := r.Pool.QueryRow(ctx, sel, kind.String(), phoneCh, source.Payload, target.Payload, tenantID).Scan(&id)
 	if err == nil {
 		return id, nil
 	}
@@ -62,21 +64,66 @@ INSERT INTO conversations (
   endpoint_kind,
   phone_channel,
   endpoint_source,
-  endpoint_target
-) VALUES ($1, $2, $3, $4)
+  endpoint_target,
+  tenant_id
+) VALUES ($1, $2, $3, $4, $5)
 RETURNING id
 `
-	err = r.Pool.QueryRow(ctx, ins, kind.String(), phoneCh, source.Payload, target.Payload).Scan(&id)
+	err = r.Pool.QueryRow(ctx, ins, kind.String(), phoneCh, source.Payload, target.Payload, tenantID).Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("insert conversation: %w", err)
 	}
 	return id, nil
 }
 
-// Look up a Conversation by id.
-func (r *ConversationRepo) GetByID(ctx context.Context, id int64) (domain.Conversation, error) {
+// TenantForEndpoints returns the tenant owning the most recently active
+// Conversation between the endpoints, the one with the latest message, or
+// domain.DefaultTenantID if there is none. Inbound webhooks use it to
+// attribute a reply to whoever wrote last.
+func (r *ConversationRepo) TenantForEndpoints(
+	ctx context.Context,
+	source domain.Endpoint,
+	target domain.Endpoint,
+) (int64, error) {
+	var phoneCh *string
+	if source.Kind == domain.EndpointKindPhone && source.Channel != nil {
+		v := source.Channel.String()
+		phoneCh = &v
+	}
+	const q = `
+SELECT c.tenant_id
+FROM conversations c
+LEFT JOIN LATERAL (
+  SELECT m.created_at
+  FROM messages m
+  WHERE m.conversation_id = c.id
+  ORDER BY m.created_at DESC, m.id DESC
+  LIMIT 1
+) last ON true
+WHERE
+  c.endpoint_kind = $1 AND
+  c.phone_channel IS NOT DISTINCT FROM $2 AND
+  LEAST(c.endpoint_source, c.endpoint_target) = LEAST($3, $4) AND
+  GREATEST(c.endpoint_source, c.endpoint_target) = GREATEST($3, $4)
+ORDER BY COALESCE(last.created_at, c.created_at) DESC, c.id DESC
+LIMIT 1
+`
+	var tenantID int64
+	err := r.Pool.QueryRow(ctx, q, source.Kind.String(), phoneCh, source.Payload, target.Payload).Scan(&tenantID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.DefaultTenantID, nil
+		}
+		return 0, fmt.Errorf("tenant for endpoints: %w", err)
+	}
+	return tenantID, nil
+}
+
+// Look up a tenant's Conversation by id. Returns ErrNotFound if the tenant
+// has no such conversation.
+func (r *ConversationRepo) GetByID(ctx context.Context, tenantID, id int64) (domain.Conversation, error) {
 	const cols = `endpoint_kind, phone_channel, endpoint_source, endpoint_target, created_at, updated_at`
-	const q = `SELECT ` + cols + ` FROM conversations WHERE id = $1`
+	const q = `SELECT ` + cols + ` FROM conversations WHERE id = $1 AND tenant_id = $2`
 	var (
 		kindStr string
 		phoneCh *string // nullable
@@ -85,10 +132,10 @@ func (r *ConversationRepo) GetByID(ctx context.Context, id int64) (domain.Conver
 		created time.Time
 		updated time.Time
 	)
-	err := r.Pool.QueryRow(ctx, q, id).Scan(&kindStr, &phoneCh, &src, &tgt, &created, &updated)
+	err := r.Pool.QueryRow(ctx, q, id, tenantID).Scan(&kindStr, &phoneCh, &src, &tgt, &created, &updated)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return domain.Conversation{}, err
+			return domain.Conversation{}, ErrNotFound
 		}
 		return domain.Conversation{}, fmt.Errorf("get conversation by id: %w", err)
 	}
@@ -99,6 +146,7 @@ func (r *ConversationRepo) GetByID(ctx context.Context, id int64) (domain.Conver
 	}
 	return domain.Conversation{
 		ID:        id,
+		TenantID:  tenantID,
 		Source:    srcEp,
 		Target:    tgtEp,
 		CreatedAt: created,
@@ -106,15 +154,48 @@ func (r *ConversationRepo) GetByID(ctx context.Context, id int64) (domain.Conver
 	}, nil
 }
 
-// Returns all Conversations.
-func (r *ConversationRepo) ListAll(ctx context.Context) ([]domain.Conversation, error) {
-	const q = `
+// ConversationQuery selects a page of a tenant's conversations, ordered by id.
+// Zero-valued filters match everything.
+type ConversationQuery struct {
+	EndpointKind domain.EndpointKind
+	Channel      domain.Channel
+	Endpoint     string // either participant
+
+	AfterID int64 // continue after this id
+	Limit   int
+}
+
+// List returns the conversations matching q and, when there are more, the id
+// to pass as q.AfterID for the next page (0 otherwise).
+func (r *ConversationRepo) List(ctx context.Context, tenantID int64, q ConversationQuery) ([]domain.Conversation, int64, error) {
+	var w whereBuilder
+	w.add("tenant_id = ?", tenantID)
+	if q.EndpointKind != "" {
+		w.add("endpoint_kind = ?", q.EndpointKind.String())
+	}
+	switch q.Channel {
+	case "":
+	case domain.ChannelEmail:
+		w.add("endpoint_kind = ?", domain.EndpointKindEmail.String())
+	default:
+		w.add("phone_channel = ?", q.Channel.String())
+	}
+	if q.Endpoint != "" {
+		w.add("(endpoint_source = ? OR endpoint_target = ?)", q.Endpoint, q.Endpoint)
+	}
+	if q.AfterID != 0 {
+		w.add("id > ?", q.AfterID)
+	}
+	sql := `
 SELECT id, endpoint_kind, phone_channel, endpoint_source, endpoint_target, created_at, updated_at
 FROM conversations
-ORDER BY id ASC`
-	rows, err := r.Pool.Query(ctx, q)
+` + w.String() + `
+ORDER BY id ASC
+LIMIT ` + w.arg(q.Limit+1)
+
+	rows, err := r.Pool.Query(ctx, sql, w.args...)
 	if err != nil {
-		return nil, fmt.Errorf("list conversations: %w", err)
+		return nil, 0, fmt.Errorf("list conversations: %w", err)
 	}
 	defer rows.Close()
 
@@ -130,29 +211,37 @@ ORDER BY id ASC`
 			updated time.Time
 		)
 		if err := rows.Scan(&id, &kindStr, &phoneCh, &src, &tgt, &created, &updated); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		kind := domain.EndpointKind(kindStr)
 		srcEp, tgtEp, err := domain.DbRowToEndpoints(kind, phoneCh, src, tgt)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		out = append(out, domain.Conversation{
 			ID:        id,
+			TenantID:  tenantID,
 			Source:    srcEp,
 			Target:    tgtEp,
 			CreatedAt: created,
 			UpdatedAt: updated,
 		})
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(out) <= q.Limit {
+		return out, 0, nil
+	}
+	out = out[:q.Limit]
+	return out, out[len(out)-1].ID, nil
 }
 
-// Checks to see if a Conversation exists with a given id.
-func (r *ConversationRepo) Exists(ctx context.Context, id int64) (bool, error) {
-	const q = `SELECT id FROM conversations WHERE id = $1`
+// Checks to see if a tenant has a Conversation with a given id.
+func (r *ConversationRepo) Exists(ctx context.Context, tenantID, id int64) (bool, error) {
+	const q = `SELECT id FROM conversations WHERE id = $1 AND tenant_id = $2`
 	var got string
-	err := r.Pool.QueryRow(ctx, q, id).Scan(&got)
+	err := r.Pool.QueryRow(ctx, q, id, tenantID).Scan(&got)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return false, nil
@@ -0,0 +1,115 @@
+//go:build integration
+// +build integration
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestTenantForEndpoints(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewConversationRepo(pool)
+	msgs := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	// two tenants writing to the same addresses, which no other test uses
+	a := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "tenant-" + uuid.NewString() + "@example.com"}
+	b := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "customer-" + uuid.NewString() + "@example.com"}
+	if got, err := r.TenantForEndpoints(ctx, b, a); err != nil || got != domain.DefaultTenantID {
+		t.Fatalf("no conversation: %d, %v", got, err)
+	}
+
+	var tenants, convs [2]int64
+	for i := range tenants {
+		err := pool.QueryRow(ctx, `INSERT INTO tenants (name) VALUES ($1) RETURNING id`, "convs-"+uuid.NewString()).Scan(&tenants[i])
+		if err != nil {
+			t.Fatalf("insert tenant: %v", err)
+		}
+		tenantID := tenants[i]
+		t.Cleanup(func() {
+			_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE tenant_id = $1`, tenantID)
+			_, _ = pool.Exec(ctx, `DELETE FROM tenants WHERE id = $1`, tenantID)
+		})
+		// the first tenant's conversation is the older one
+		err = pool.QueryRow(ctx, `
+			INSERT INTO conversations (endpoint_kind, endpoint_source, endpoint_target, tenant_id, created_at, updated_at)
+			VALUES ('email', $1, $2, $3, now() - make_interval(hours => $4), now() - make_interval(hours => $4))
+			RETURNING id
+		`, a.Payload, b.Payload, tenantID, 2-i).Scan(&convs[i])
+		if err != nil {
+			t.Fatalf("insert conversation: %v", err)
+		}
+	}
+	if got, err := r.TenantForEndpoints(ctx, b, a); err != nil || got != tenants[1] {
+		t.Fatalf("without messages: %d, %v; want the newer conversation's tenant %d", got, err, tenants[1])
+	}
+
+	// the older conversation becomes the active one once it gets a message
+	_, err = msgs.Insert(ctx, domain.Message{
+		TenantID:       tenants[0],
+		ConversationID: convs[0],
+		Source:         a,
+		Target:         b,
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "hello again",
+		Status:         domain.StatusOK,
+	})
+	if err != nil {
+		t.Fatalf("insert message: %v", err)
+	}
+	if got, err := r.TenantForEndpoints(ctx, b, a); err != nil || got != tenants[0] {
+		t.Fatalf("after a message: %d, %v; want %d", got, err, tenants[0])
+	}
+}
+
+func TestConversationGetByIDNotFound(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewConversationRepo(pool)
+	ctx := context.Background()
+	if _, err := r.GetByID(ctx, domain.DefaultTenantID, -1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing conversation: want ErrNotFound, got %v", err)
+	}
+
+	// another tenant's conversation is not found either
+	a := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "tenant-" + uuid.NewString() + "@example.com"}
+	b := domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "customer-" + uuid.NewString() + "@example.com"}
+	id, err := r.GetOrCreateByEndpoints(ctx, domain.DefaultTenantID, a, b)
+	if err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	t.Cleanup(func() { _, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, id) })
+	if _, err := r.GetByID(ctx, domain.DefaultTenantID, id); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if _, err := r.GetByID(ctx, domain.DefaultTenantID+1_000_000, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other tenant: want ErrNotFound, got %v", err)
+	}
+}
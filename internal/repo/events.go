@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// Events returns the timeline of a tenant's message, oldest first. The
+// message_events rows are written by a trigger whenever a message is created,
+// changes status or records a delivery attempt. Returns ErrNotFound if the
+// tenant has no such message.
+func (r *MessageRepo) Events(ctx context.Context, tenantID, id int64) ([]domain.MessageEvent, error) {
+	const q = `
+SELECT e.id, e.message_id, e.from_status, e.to_status, e.provider_id, e.provider_message_id,
+       e.payload, e.attempt, e.actor, e.created_at
+FROM message_events e
+JOIN messages m ON m.id = e.message_id
+WHERE e.message_id = $1 AND m.tenant_id = $2
+ORDER BY e.id ASC
+`
+	rows, err := r.Pool.Query(ctx, q, id, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list message events: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]domain.MessageEvent, 0)
+	for rows.Next() {
+		e, err := scanMessageEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message event: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list message events: %w", err)
+	}
+	if len(out) == 0 {
+		// messages created before the history was kept have none
+		if _, err := r.GetByIDForTenant(ctx, tenantID, id); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ConversationEvents returns up to limit events of the messages in a tenant's
+// conversation with an id above afterID, oldest first.
+func (r *MessageRepo) ConversationEvents(ctx context.Context, tenantID, conversationID, afterID int64, limit int) ([]domain.MessageEvent, error) {
+	const q = `
+SELECT e.id, e.message_id, e.from_status, e.to_status, e.provider_id, e.provider_message_id,
+       e.payload, e.attempt, e.actor, e.created_at
+FROM message_events e
+JOIN messages m ON m.id = e.message_id
+WHERE m.conversation_id = $1 AND m.tenant_id = $2 AND e.id > $3
+ORDER BY e.id ASC
+LIMIT $4
+`
+	rows, err := r.Pool.Query(ctx, q, conversationID, tenantID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list conversation events: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]domain.MessageEvent, 0)
+	for rows.Next() {
+		e, err := scanMessageEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message event: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list conversation events: %w", err)
+	}
+	return out, nil
+}
+
+// LastConversationEventID returns the id of the latest event in a
+// conversation, or 0 if it has none.
+func (r *MessageRepo) LastConversationEventID(ctx context.Context, conversationID int64) (int64, error) {
+	const q = `
+SELECT COALESCE(max(e.id), 0)
+FROM message_events e
+JOIN messages m ON m.id = e.message_id
+WHERE m.conversation_id = $1
+`
+	var id int64
+	if err := r.Pool.QueryRow(ctx, q, conversationID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("last conversation event: %w", err)
+	}
+	return id, nil
+}
+
+// Event returns the message event with the given id, or ErrNotFound.
+func (r *MessageRepo) Event(ctx context.Context, id int64) (domain.MessageEvent, error) {
+	const q = `
+SELECT id, message_id, from_status, to_status, provider_id, provider_message_id,
+       payload, attempt, actor, created_at
+FROM message_events
+WHERE id = $1
+`
+	e, err := scanMessageEvent(r.Pool.QueryRow(ctx, q, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.MessageEvent{}, ErrNotFound
+		}
+		return domain.MessageEvent{}, fmt.Errorf("get message event: %w", err)
+	}
+	return e, nil
+}
+
+func scanMessageEvent(row pgx.Row) (domain.MessageEvent, error) {
+	var (
+		e                 domain.MessageEvent
+		from              *string
+		to, actor         string
+		provID, provMsgID *string
+	)
+	if err := row.Scan(&e.ID, &e.MessageID, &from, &to, &provID, &provMsgID, &e.Payload, &e.Attempt, &actor, &e.At); err != nil {
+		return domain.MessageEvent{}, err
+	}
+	if from != nil {
+		st := domain.Status(*from)
+		e.FromStatus = &st
+	}
+	e.ToStatus = domain.Status(to)
+	if provID != nil {
+		e.ProviderID = *provID
+	}
+	if provMsgID != nil {
+		e.ProviderMessageID = *provMsgID
+	}
+	e.Actor = domain.Actor(actor)
+	return e, nil
+}
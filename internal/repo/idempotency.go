@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+var (
+	// ErrIdempotencyMismatch is returned when an idempotency key is reused
+	// with a different request.
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyInFlight is returned when another request holding the same
+	// idempotency key has not finished yet.
+	ErrIdempotencyInFlight = errors.New("request with this idempotency key is in progress")
+)
+
+// IdempotencyKey is a client-supplied Idempotency-Key together with a
+// fingerprint of the request it came with.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string
+}
+
+// idempotencyLockTimeout bounds how long a request waits for a concurrent
+// request with the same key before giving up with ErrIdempotencyInFlight.
+const idempotencyLockTimeout = "2s"
+
+// InsertIdempotent inserts m unless the tenant already used key within
+// retention. In that case the original message id is returned with
+// replayed set, or ErrIdempotencyMismatch if the fingerprints differ. The key
+// and the message are written in one transaction, so concurrent requests
+// with the same key produce a single message.
+func (r *MessageRepo) InsertIdempotent(
+	ctx context.Context,
+	m domain.Message,
+	key IdempotencyKey,
+	retention time.Duration,
+) (id int64, replayed bool, err error) {
+	tenantID := tenantOrDefault(m.TenantID)
+
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SET LOCAL lock_timeout = '"+idempotencyLockTimeout+"'"); err != nil {
+		return 0, false, fmt.Errorf("set lock_timeout: %w", err)
+	}
+
+	// an expired key is free to be used again even if cleanup has not run yet
+	const del = `
+DELETE FROM idempotency_keys
+WHERE tenant_id = $1 AND key = $2 AND created_at < now() - make_interval(secs => $3)
+`
+	if _, err := tx.Exec(ctx, del, tenantID, key.Key, retention.Seconds()); err != nil {
+		return 0, false, idempotencyErr("expire idempotency key", err)
+	}
+
+	const ins = `
+INSERT INTO idempotency_keys (tenant_id, key, fingerprint)
+VALUES ($1, $2, $3)
+ON CONFLICT (tenant_id, key) DO NOTHING
+`
+	tag, err := tx.Exec(ctx, ins, tenantID, key.Key, key.Fingerprint)
+	if err != nil {
+		return 0, false, idempotencyErr("insert idempotency key", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		const sel = `SELECT fingerprint, message_id FROM idempotency_keys WHERE tenant_id = $1 AND key = $2`
+		// message_id is only NULL inside the transaction that created the key,
+		// and the lock above waited for that one to finish
+		var fingerprint string
+		var msgID int64
+		if err := tx.QueryRow(ctx, sel, tenantID, key.Key).Scan(&fingerprint, &msgID); err != nil {
+			return 0, false, fmt.Errorf("select idempotency key: %w", err)
+		}
+		if fingerprint != key.Fingerprint {
+			return 0, false, ErrIdempotencyMismatch
+		}
+		return msgID, true, nil
+	}
+
+	id, err = insertMessage(ctx, tx, m)
+	if err != nil {
+		return 0, false, err
+	}
+	const upd = `UPDATE idempotency_keys SET message_id = $3 WHERE tenant_id = $1 AND key = $2`
+	if _, err := tx.Exec(ctx, upd, tenantID, key.Key, id); err != nil {
+		return 0, false, fmt.Errorf("update idempotency key: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, fmt.Errorf("commit: %w", err)
+	}
+	return id, false, nil
+}
+
+// LookupIdempotent returns the message id stored under key if the tenant used
+// it within retention, or found unset if the key is new. It lets a replay skip
+// the work of building the message; InsertIdempotent still settles races
+// between concurrent first requests.
+func (r *MessageRepo) LookupIdempotent(
+	ctx context.Context,
+	tenantID int64,
+	key IdempotencyKey,
+	retention time.Duration,
+) (id int64, found bool, err error) {
+	// message_id is NULL while the first request is still in flight; that
+	// request's row is not visible here until it commits anyway
+	const q = `
+SELECT fingerprint, message_id FROM idempotency_keys
+WHERE tenant_id = $1 AND key = $2 AND message_id IS NOT NULL
+  AND created_at >= now() - make_interval(secs => $3)
+`
+	var fingerprint string
+	err = r.Pool.QueryRow(ctx, q, tenantOrDefault(tenantID), key.Key, retention.Seconds()).Scan(&fingerprint, &id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("lookup idempotency key: %w", err)
+	}
+	if fingerprint != key.Fingerprint {
+		return 0, false, ErrIdempotencyMismatch
+	}
+	return id, true, nil
+}
+
+// PurgeIdempotencyKeys deletes keys created before cutoff and returns how many
+// were removed.
+func (r *MessageRepo) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	const q = `DELETE FROM idempotency_keys WHERE created_at < $1`
+	tag, err := r.Pool.Exec(ctx, q, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// idempotencyErr maps a lock timeout while waiting on a concurrent request
+// holding the same key to ErrIdempotencyInFlight.
+func idempotencyErr(op string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "55P03" { // lock_not_available
+		return ErrIdempotencyInFlight
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
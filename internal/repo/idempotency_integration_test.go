@@ -0,0 +1,90 @@
+//go:build integration
+// +build integration
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestInsertIdempotent(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "idem-a@example.com", "idem-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	m := domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "idem-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "idem-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "hello",
+		Status:         domain.StatusOutbox,
+	}
+	key := IdempotencyKey{Key: uuid.NewString(), Fingerprint: "fp-1"}
+
+	id1, replayed, err := r.InsertIdempotent(ctx, m, key, time.Hour)
+	if err != nil || replayed {
+		t.Fatalf("first insert: id=%d replayed=%v err=%v", id1, replayed, err)
+	}
+	id2, replayed, err := r.InsertIdempotent(ctx, m, key, time.Hour)
+	if err != nil || !replayed || id2 != id1 {
+		t.Fatalf("replay: id=%d (want %d) replayed=%v err=%v", id2, id1, replayed, err)
+	}
+
+	id4, found, err := r.LookupIdempotent(ctx, 0, key, time.Hour)
+	if err != nil || !found || id4 != id1 {
+		t.Fatalf("lookup: id=%d (want %d) found=%v err=%v", id4, id1, found, err)
+	}
+	if _, found, err := r.LookupIdempotent(ctx, 0, IdempotencyKey{Key: uuid.NewString()}, time.Hour); err != nil || found {
+		t.Fatalf("lookup new key: found=%v err=%v", found, err)
+	}
+
+	other := key
+	other.Fingerprint = "fp-2"
+	if _, _, err := r.LookupIdempotent(ctx, 0, other, time.Hour); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("lookup: want ErrIdempotencyMismatch, got %v", err)
+	}
+	if _, _, err := r.InsertIdempotent(ctx, m, other, time.Hour); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("want ErrIdempotencyMismatch, got %v", err)
+	}
+
+	// once expired the key starts over
+	if _, err := pool.Exec(ctx, `UPDATE idempotency_keys SET created_at = now() - interval '2 hours' WHERE key = $1`, key.Key); err != nil {
+		t.Fatalf("age key: %v", err)
+	}
+	id3, replayed, err := r.InsertIdempotent(ctx, m, other, time.Hour)
+	if err != nil || replayed || id3 == id1 {
+		t.Fatalf("after expiry: id=%d replayed=%v err=%v", id3, replayed, err)
+	}
+}
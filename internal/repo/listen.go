@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// outboxChannel is notified by the messages_notify_outbox trigger whenever an
+// outbound message is inserted into the outbox.
+const outboxChannel = "messages_outbox"
+
+// conversationChannel is notified by the message_events_notify_conversation
+// trigger for every message event, with "<conversation_id>:<event_id>".
+const conversationChannel = "conversation_events"
+
+// listen takes a connection out of the pool and subscribes it to channel.
+func listen(ctx context.Context, pool *pgxpool.Pool, channel string) (*pgxpool.Conn, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire listen connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("listen %s: %w", channel, err)
+	}
+	return conn, nil
+}
+
+// unlisten closes conn rather than returning it to the pool still subscribed.
+func unlisten(conn *pgxpool.Conn) {
+	_ = conn.Conn().Close(context.Background())
+	conn.Release()
+}
+
+// OutboxListener waits for new outbox messages on a connection of its own.
+type OutboxListener struct {
+	conn *pgxpool.Conn
+}
+
+// ListenOutbox takes a connection out of the pool and subscribes it to outbox
+// notifications. Close returns it.
+func (r *MessageRepo) ListenOutbox(ctx context.Context) (*OutboxListener, error) {
+	conn, err := listen(ctx, r.Pool, outboxChannel)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxListener{conn: conn}, nil
+}
+
+// Wait blocks until a message is inserted into the outbox or ctx is done.
+func (l *OutboxListener) Wait(ctx context.Context) error {
+	if _, err := l.conn.Conn().WaitForNotification(ctx); err != nil {
+		return fmt.Errorf("wait for %s: %w", outboxChannel, err)
+	}
+	return nil
+}
+
+// Close closes the connection rather than returning it to the pool still
+// subscribed.
+func (l *OutboxListener) Close() { unlisten(l.conn) }
+
+// ConversationNotification says that a message event was committed in a
+// conversation.
+type ConversationNotification struct {
+	ConversationID int64
+	EventID        int64
+}
+
+// ConversationListener receives the message events of all conversations on a
+// connection of its own.
+type ConversationListener struct {
+	conn *pgxpool.Conn
+}
+
+// ListenConversations takes a connection out of the pool and subscribes it to
+// message event notifications. Close returns it.
+func (r *MessageRepo) ListenConversations(ctx context.Context) (*ConversationListener, error) {
+	conn, err := listen(ctx, r.Pool, conversationChannel)
+	if err != nil {
+		return nil, err
+	}
+	return &ConversationListener{conn: conn}, nil
+}
+
+// Wait blocks until a message event is committed or ctx is done.
+func (l *ConversationListener) Wait(ctx context.Context) (ConversationNotification, error) {
+	for {
+		n, err := l.conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return ConversationNotification{}, fmt.Errorf("wait for %s: %w", conversationChannel, err)
+		}
+		if cn, ok := parseConversationNotification(n.Payload); ok {
+			return cn, nil
+		}
+	}
+}
+
+// Close closes the connection rather than returning it to the pool still
+// subscribed.
+func (l *ConversationListener) Close() { unlisten(l.conn) }
+
+func parseConversationNotification(payload string) (ConversationNotification, bool) {
+	conv, event, ok := strings.Cut(payload, ":")
+	if !ok {
+		return ConversationNotification{}, false
+	}
+	convID, err1 := strconv.ParseInt(conv, 10, 64)
+	eventID, err2 := strconv.ParseInt(event, 10, 64)
+	if err1 != nil || err2 != nil {
+		return ConversationNotification{}, false
+	}
+	return ConversationNotification{ConversationID: convID, EventID: eventID}, true
+}
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/rdavison/messaging-service/internal/domain"
+	"github.com/rdavison/messaging-service/internal/tracing"
 )
 
 type MessageRepo struct {
@@ -23,6 +25,15 @@ func NewMessageRepo(pool *pgxpool.Pool) *MessageRepo {
 
 // Insert inserts a message row and returns the new id.
 func (r *MessageRepo) Insert(ctx context.Context, m domain.Message) (int64, error) {
+	return insertMessage(ctx, r.Pool, m)
+}
+
+// queryRower is satisfied by both *pgxpool.Pool and pgx.Tx.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func insertMessage(ctx context.Context, db queryRower, m domain.Message) (int64, error) {
 	const q = `
 INSERT INTO messages (
   conversation_id,
@@ -37,9 +48,15 @@ INSERT INTO messages (
   body,
   attachments,
   status_tag,
-  status_payload
+  status_payload,
+  tenant_id,
+  send_at,
+  template_id,
+  template_version,
+  traceparent,
+  status_actor
 ) VALUES (
-  $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13
+  $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19
 ) ON CONFLICT (provider_id, provider_message_id) DO
   UPDATE SET updated_at = EXCLUDED.updated_at
   RETURNING id
@@ -61,9 +78,10 @@ INSERT INTO messages (
 	}
 
 	attJSON := encodeAttachments(m.Attachments)
+	tmplID, tmplVersion := templateColumns(m.TemplateRef)
 
 	var id int64
-	err := r.Pool.QueryRow(ctx, q,
+	err := db.QueryRow(ctx, q,
 		m.ConversationID,
 		m.Source.Payload,
 		m.Target.Payload,
@@ -77,6 +95,12 @@ INSERT INTO messages (
 		nullableJSON(attJSON),
 		string(m.Status),
 		m.StatusPayload,
+		tenantOrDefault(m.TenantID),
+		m.SendAt,
+		tmplID,
+		tmplVersion,
+		traceparent(ctx, m),
+		string(creator(m)),
 	).Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("insert message: %w", err)
@@ -84,27 +108,53 @@ INSERT INTO messages (
 	return id, nil
 }
 
-// Updates the status of a message with a given id. Optionally updates providerID and
-// providerMessageID if they are passed in as well.
+// ErrLeaseLost is returned when a message or webhook delivery is no longer
+// leased to the caller: its lease expired and another worker claimed it, or it
+// left the outbox (e.g. it was canceled) in the meantime. Nothing is changed.
+var ErrLeaseLost = errors.New("lease lost")
+
+// Records the outcome of a delivery attempt for the message with a given id,
+// as long as owner still holds its lease and it is still waiting to be sent;
+// otherwise ErrLeaseLost. Optionally updates providerID and providerMessageID
+// if they are passed in as well. The attempt is appended to attempt_history,
+// next_attempt_at is set (nil clears it) and the lease is released so a retry
+// can be claimed again.
 func (r *MessageRepo) UpdateStatus(
 	ctx context.Context,
 	id int64,
+	owner string,
 	newStatus domain.Status,
 	providerID *string,
 	providerMessageID *string,
 	statusPayload *string,
+	nextAttemptAt *time.Time,
 ) error {
 	if providerID == nil || providerMessageID == nil {
 		const q = `
 UPDATE messages
 SET status_tag = $1,
-    status_payload = $2
-WHERE id = $3;
+    status_payload = $2,
+    attempt_count = attempt_count + 1,
+    attempt_history = attempt_history || jsonb_build_array(jsonb_build_object(
+      'number',   attempt_count + 1,
+      'at',       now(),
+      'status',   $1::text,
+      'provider', $3::text,
+      'payload',  $2::text
+    )),
+    next_attempt_at = $4,
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    status_actor = 'processor'
+WHERE id = $5 AND lease_owner = $6 AND status_tag IN ('outbox','retry');
 `
-		_, err := r.Pool.Exec(ctx, q, string(newStatus), statusPayload, id)
+		tag, err := r.Pool.Exec(ctx, q, string(newStatus), statusPayload, providerID, nextAttemptAt, id, owner)
 		if err != nil {
 			return fmt.Errorf("update message status: %w", err)
 		}
+		if tag.RowsAffected() == 0 {
+			return ErrLeaseLost
+		}
 		return nil
 	}
 
@@ -115,6 +165,18 @@ UPDATE messages
 SET
   status_tag     = $1,
   status_payload = $2,
+  attempt_count  = attempt_count + 1,
+  attempt_history = attempt_history || jsonb_build_array(jsonb_build_object(
+    'number',   attempt_count + 1,
+    'at',       now(),
+    'status',   $1::text,
+    'provider', $3::text,
+    'payload',  $2::text
+  )),
+  next_attempt_at  = $6,
+  lease_owner      = NULL,
+  lease_expires_at = NULL,
+  status_actor     = 'processor',
   provider_id = CASE
     WHEN EXISTS (
       SELECT 1 FROM messages m2
@@ -133,186 +195,258 @@ SET
     ) THEN provider_message_id
     ELSE $4
   END
-WHERE id = $5;
+WHERE id = $5 AND lease_owner = $7 AND status_tag IN ('outbox','retry');
 `
-	_, err := r.Pool.Exec(ctx, q, string(newStatus), statusPayload, providerID, providerMessageID, id)
+	tag, err := r.Pool.Exec(ctx, q, string(newStatus), statusPayload, providerID, providerMessageID, id, nextAttemptAt, owner)
 	if err != nil {
 		return fmt.Errorf("update message status (CASE-guard): %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
 	return nil
 }
 
-// PollOutboxOrRetry returns messages with status outbox/retry, oldest first.
-func (r *MessageRepo) PollOutboxOrRetry(ctx context.Context, limit int) ([]domain.Message, error) {
+// ErrStaleStatus is returned by UpdateDeliveryStatus when the message exists
+// but is already at or past the reported status.
+var ErrStaleStatus = errors.New("stale status")
+
+// UpdateDeliveryStatus applies a provider delivery callback to the outbound
+// message identified by (providerID, providerMessageID). The status only moves
+// forward (see domain.CanAdvance). Returns the message id, ErrNotFound if no
+// such message exists, or ErrStaleStatus if it was not advanced.
+func (r *MessageRepo) UpdateDeliveryStatus(
+	ctx context.Context,
+	providerID string,
+	providerMessageID string,
+	newStatus domain.Status,
+	statusPayload *string,
+) (int64, error) {
+	from := domain.AdvanceableFrom(newStatus)
+	fromStrs := make([]string, len(from))
+	for i, s := range from {
+		fromStrs[i] = string(s)
+	}
+
 	const q = `
-SELECT
-  id, conversation_id, endpoint_source, endpoint_target,
-  provider_id, provider_message_id,
-  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
-  body, attachments, status_tag, status_payload,
-  created_at, updated_at
-FROM messages
-WHERE status_tag IN ('outbox','retry')
-ORDER BY sent_at ASC
-LIMIT $1
+UPDATE messages
+SET status_tag = $3,
+    status_payload = COALESCE($4, status_payload),
+    status_actor = 'provider'
+WHERE provider_id = $1
+  AND provider_message_id = $2
+  AND inbound_or_outbound = 'outbound'
+  AND status_tag::text = ANY($5::text[])
+RETURNING id
+`
+	var id int64
+	err := r.Pool.QueryRow(ctx, q, providerID, providerMessageID, string(newStatus), statusPayload, fromStrs).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("update delivery status: %w", err)
+	}
+
+	const exists = `
+SELECT id FROM messages
+WHERE provider_id = $1 AND provider_message_id = $2 AND inbound_or_outbound = 'outbound'
 `
-	rows, err := r.Pool.Query(ctx, q, limit)
+	err = r.Pool.QueryRow(ctx, exists, providerID, providerMessageID).Scan(&id)
 	if err != nil {
-		return nil, fmt.Errorf("poll messages: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("update delivery status: %w", err)
+	}
+	return id, ErrStaleStatus
+}
+
+// ClaimOutboxOrRetry leases up to limit messages with status outbox/retry to
+// owner, oldest first. Retries whose next_attempt_at is still in the future
+// are left alone. Rows already leased by another worker are skipped
+// unless their lease has expired, so several processors can poll the same
+// table without sending a message twice.
+func (r *MessageRepo) ClaimOutboxOrRetry(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.Message, error) {
+	const q = `
+WITH claimable AS (
+  SELECT id
+  FROM messages
+  WHERE (
+      status_tag IN ('outbox','retry')
+      AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+    OR
+      status_tag = 'scheduled' AND send_at <= now()
+  )
+    AND (lease_expires_at IS NULL OR lease_expires_at < now())
+  ORDER BY sent_at ASC
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+UPDATE messages
+SET lease_owner = $1,
+    lease_expires_at = now() + make_interval(secs => $3),
+    -- a due scheduled message is dispatched like any other outbox message,
+    -- and can no longer be changed through the API
+    status_tag = CASE WHEN messages.status_tag = 'scheduled' THEN 'outbox' ELSE messages.status_tag END,
+    status_actor = CASE WHEN messages.status_tag = 'scheduled' THEN 'processor' ELSE messages.status_actor END
+FROM claimable
+WHERE messages.id = claimable.id
+RETURNING ` + messageColumnsQualified + `
+`
+	rows, err := r.Pool.Query(ctx, q, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim messages: %w", err)
 	}
 	defer rows.Close()
 
 	var out []domain.Message
 	for rows.Next() {
-		var (
-			id, convID                int64
-			source, target            string
-			providerID, providerMsgID *string
-			dirStr, kindStr           string
-			phoneCh                   *string
-			body                      string
-			attJSON                   *string
-			statusStr                 string
-			statusPayload             *string
-			createdAt, updatedAt      time.Time
-			sentAt                    time.Time
-		)
-		if err := rows.Scan(
-			&id, &convID, &source, &target,
-			&providerID, &providerMsgID,
-			&dirStr, &sentAt, &kindStr, &phoneCh,
-			&body, &attJSON, &statusStr, &statusPayload,
-			&createdAt, &updatedAt,
-		); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// UPDATE ... RETURNING does not preserve the CTE ordering
+	sort.Slice(out, func(i, j int) bool { return out[i].SentAt.Before(out[j].SentAt) })
+	return out, nil
+}
 
-		var ch *domain.PhoneChannel
-		if phoneCh != nil {
-			c := domain.PhoneChannel(*phoneCh)
-			ch = &c
-		}
-		src := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: source, Channel: ch}
-		trg := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: target, Channel: ch}
-
-		var prov *domain.ProviderRef
-		if providerID != nil || providerMsgID != nil {
-			p := domain.ProviderRef{}
-			if providerID != nil {
-				p.ID = *providerID
-			}
-			if providerMsgID != nil {
-				p.MessageID = *providerMsgID
-			}
-			prov = &p
-		}
+// Defer releases owner's lease on a claimed outbox or retry message without
+// recording an attempt, so it is claimed again once until has passed. Returns
+// ErrLeaseLost if owner no longer holds it.
+func (r *MessageRepo) Defer(ctx context.Context, id int64, owner string, until time.Time) error {
+	const q = `
+UPDATE messages
+SET next_attempt_at = $2,
+    lease_owner = NULL,
+    lease_expires_at = NULL
+WHERE id = $1 AND lease_owner = $3 AND status_tag IN ('outbox','retry')
+`
+	tag, err := r.Pool.Exec(ctx, q, id, until, owner)
+	if err != nil {
+		return fmt.Errorf("defer message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
 
-		if err := rows.Err(); err != nil {
-			return nil, err
-		}
+// RenewLease extends owner's lease on a claimed message to lease from now.
+// Returns ErrLeaseLost if another worker has claimed it since, or it is no
+// longer waiting to be sent. The processor renews right before each send, so
+// a message whose lease ran out while it waited in a batch is never sent by
+// two workers.
+func (r *MessageRepo) RenewLease(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	const q = `
+UPDATE messages
+SET lease_expires_at = now() + make_interval(secs => $3)
+WHERE id = $1 AND lease_owner = $2 AND status_tag IN ('outbox','retry')
+`
+	tag, err := r.Pool.Exec(ctx, q, id, owner, lease.Seconds())
+	if err != nil {
+		return fmt.Errorf("renew lease: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
 
-		m := domain.Message{
-			ID:             id,
-			ConversationID: convID,
-			Source:         src,
-			Target:         trg,
-			Direction:      domain.InboundOrOutbound(dirStr),
-			SentAt:         sentAt,
-			Body:           body,
-			Attachments:    decodeAttachments(attJSON),
-			Status:         domain.Status(statusStr),
-			StatusPayload:  statusPayload,
-			Provider:       prov,
-			CreatedAt:      createdAt,
-			UpdatedAt:      updatedAt,
-		}
-		out = append(out, m)
+// ReleaseLeases gives up owner's leases on messages it claimed but will not
+// send, e.g. when shutting down, so other workers can claim them right away.
+func (r *MessageRepo) ReleaseLeases(ctx context.Context, owner string, ids []int64) error {
+	const q = `
+UPDATE messages
+SET lease_owner = NULL,
+    lease_expires_at = NULL
+WHERE id = ANY($1) AND lease_owner = $2
+`
+	if _, err := r.Pool.Exec(ctx, q, ids, owner); err != nil {
+		return fmt.Errorf("release leases: %w", err)
 	}
-	return out, rows.Err()
+	return nil
+}
+
+// Backlog describes the messages waiting to be claimed.
+type Backlog struct {
+	Due       int        `json:"due"`                  // outbox, retry and scheduled messages that are due now
+	OldestDue *time.Time `json:"oldest_due,omitempty"` // when the longest waiting of them became due
 }
 
-func (r *MessageRepo) GetByConversation(ctx context.Context, convID int64, limit, offset int) ([]domain.Message, error) {
+// Backlog counts the messages ClaimOutboxOrRetry would pick up now, whether
+// or not they are leased.
+func (r *MessageRepo) Backlog(ctx context.Context) (Backlog, error) {
 	const q = `
-SELECT
-  id, conversation_id, endpoint_source, endpoint_target,
-  provider_id, provider_message_id,
-  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
-  body, attachments, status_tag, status_payload,
-  created_at, updated_at
+SELECT count(*), min(COALESCE(next_attempt_at, send_at, created_at))
 FROM messages
-WHERE conversation_id = $1
-ORDER BY sent_at ASC, id ASC
-LIMIT $2 OFFSET $3
+WHERE status_tag IN ('outbox','retry')
+    AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+  OR
+    status_tag = 'scheduled' AND send_at <= now()
 `
-	rows, err := r.Pool.Query(ctx, q, convID, limit, offset)
+	var b Backlog
+	if err := r.Pool.QueryRow(ctx, q).Scan(&b.Due, &b.OldestDue); err != nil {
+		return Backlog{}, fmt.Errorf("count backlog: %w", err)
+	}
+	return b, nil
+}
+
+// OutboxDepth is the number of pending messages with one status and channel.
+type OutboxDepth struct {
+	Status  domain.Status
+	Channel domain.Channel
+	Count   int
+}
+
+// OutboxDepth counts the outbound messages not yet handed to a provider:
+// outbox, retry and scheduled, by status and channel.
+func (r *MessageRepo) OutboxDepth(ctx context.Context) ([]OutboxDepth, error) {
+	const q = `
+SELECT status_tag::text, COALESCE(phone_channel::text, endpoint_kind::text), count(*)
+FROM messages
+WHERE status_tag IN ('outbox','retry','scheduled')
+GROUP BY 1, 2
+ORDER BY 1, 2
+`
+	rows, err := r.Pool.Query(ctx, q)
 	if err != nil {
-		return nil, fmt.Errorf("get messages by conversation: %w", err)
+		return nil, fmt.Errorf("count outbox: %w", err)
 	}
 	defer rows.Close()
 
-	var out []domain.Message
+	var out []OutboxDepth
 	for rows.Next() {
-		var (
-			id, convIDRow             int64
-			source, target            string
-			providerID, providerMsgID *string
-			dirStr, kindStr           string
-			phoneCh                   *string
-			body                      string
-			attJSON                   *string
-			statusStr                 string
-			statusPayload             *string
-			sentAt                    time.Time
-			createdAt, updatedAt      time.Time
-		)
-		if err := rows.Scan(
-			&id, &convIDRow, &source, &target,
-			&providerID, &providerMsgID,
-			&dirStr, &sentAt, &kindStr, &phoneCh,
-			&body, &attJSON, &statusStr, &statusPayload,
-			&createdAt, &updatedAt,
-		); err != nil {
+		var status, channel string
+		var n int
+		if err := rows.Scan(&status, &channel, &n); err != nil {
 			return nil, err
 		}
+		out = append(out, OutboxDepth{Status: domain.Status(status), Channel: domain.Channel(channel), Count: n})
+	}
+	return out, rows.Err()
+}
 
-		var ch *domain.PhoneChannel
-		if phoneCh != nil {
-			c := domain.PhoneChannel(*phoneCh)
-			ch = &c
-		}
-		src := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: source, Channel: ch}
-		trg := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: target, Channel: ch}
-
-		var prov *domain.ProviderRef
-		if providerID != nil || providerMsgID != nil {
-			p := domain.ProviderRef{}
-			if providerID != nil {
-				p.ID = *providerID
-			}
-			if providerMsgID != nil {
-				p.MessageID = *providerMsgID
-			}
-			prov = &p
-		}
+// creator is the actor recorded for the creation of m: the client for an
+// outbound message, the provider for an inbound one.
+func creator(m domain.Message) domain.Actor {
+	if m.Direction == domain.Inbound {
+		return domain.ActorProvider
+	}
+	return domain.ActorAPI
+}
 
-		m := domain.Message{
-			ID:             id,
-			ConversationID: convIDRow,
-			Source:         src,
-			Target:         trg,
-			Direction:      domain.InboundOrOutbound(dirStr),
-			SentAt:         sentAt,
-			Body:           body,
-			Attachments:    decodeAttachments(attJSON),
-			Status:         domain.Status(statusStr),
-			StatusPayload:  statusPayload,
-			Provider:       prov,
-			CreatedAt:      createdAt,
-			UpdatedAt:      updatedAt,
-		}
-		out = append(out, m)
+// tenantOrDefault maps the zero tenant id to domain.DefaultTenantID.
+func tenantOrDefault(id int64) int64 {
+	if id == 0 {
+		return domain.DefaultTenantID
 	}
-	return out, rows.Err()
+	return id
 }
 
 func nullableJSON(b []byte) any {
@@ -338,13 +472,16 @@ INSERT INTO messages (
   body,
   attachments,
   status_tag,
-  status_payload
+  status_payload,
+  tenant_id,
+  status_actor
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,'provider')
 ON CONFLICT (provider_id, provider_message_id)
 DO UPDATE SET
   status_tag     = EXCLUDED.status_tag,
   status_payload = EXCLUDED.status_payload,
+  status_actor   = EXCLUDED.status_actor,
   updated_at     = now()
 RETURNING id;
 `
@@ -379,6 +516,7 @@ RETURNING id;
 		nullableJSON(attJSON),
 		string(m.Status),
 		m.StatusPayload,
+		tenantOrDefault(m.TenantID),
 	).Scan(&id); err != nil {
 		return 0, fmt.Errorf("upsert messages by provider pair: %w", err)
 	}
@@ -389,17 +527,62 @@ var ErrNotFound = errors.New("not found")
 
 func (r *MessageRepo) GetByID(ctx context.Context, id int64) (domain.Message, error) {
 	const q = `
-SELECT
-  id, conversation_id, endpoint_source, endpoint_target,
-  provider_id, provider_message_id,
-  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
-  body, attachments, status_tag, status_payload,
-  created_at, updated_at
+SELECT ` + messageColumns + `
 FROM messages
 WHERE id = $1
 `
+	m, err := scanMessage(r.Pool.QueryRow(ctx, q, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Message{}, ErrNotFound
+		}
+		return domain.Message{}, fmt.Errorf("get message by id: %w", err)
+	}
+	return m, nil
+}
+
+// GetByIDForTenant is GetByID restricted to a tenant's messages.
+func (r *MessageRepo) GetByIDForTenant(ctx context.Context, tenantID, id int64) (domain.Message, error) {
+	const q = `
+SELECT ` + messageColumns + `
+FROM messages
+WHERE id = $1 AND tenant_id = $2
+`
+	m, err := scanMessage(r.Pool.QueryRow(ctx, q, id, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Message{}, ErrNotFound
+		}
+		return domain.Message{}, fmt.Errorf("get message by id: %w", err)
+	}
+	return m, nil
+}
+
+// messageColumns is the column list read by scanMessage, in scan order.
+const messageColumns = `
+  id, tenant_id, conversation_id, endpoint_source, endpoint_target,
+  provider_id, provider_message_id,
+  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
+  body, attachments, status_tag, status_payload,
+  attempt_count, next_attempt_at, attempt_history, send_at, batch_id,
+  template_id, template_version, traceparent,
+  created_at, updated_at`
+
+// messageColumnsQualified is messageColumns prefixed with the table name, for
+// statements (e.g. UPDATE ... FROM) where the bare names would be ambiguous.
+const messageColumnsQualified = `
+  messages.id, messages.tenant_id, messages.conversation_id, messages.endpoint_source, messages.endpoint_target,
+  messages.provider_id, messages.provider_message_id,
+  messages.inbound_or_outbound, messages.sent_at, messages.endpoint_kind, messages.phone_channel,
+  messages.body, messages.attachments, messages.status_tag, messages.status_payload,
+  messages.attempt_count, messages.next_attempt_at, messages.attempt_history, messages.send_at, messages.batch_id,
+  messages.template_id, messages.template_version, messages.traceparent,
+  messages.created_at, messages.updated_at`
+
+// scanMessage reads a single row selected with messageColumns.
+func scanMessage(row pgx.Row) (domain.Message, error) {
 	var (
-		convID                    int64
+		id, tenantID, convID      int64
 		source, target            string
 		providerID, providerMsgID *string
 		dirStr, kindStr           string
@@ -408,21 +591,27 @@ WHERE id = $1
 		attJSON                   *string
 		statusStr                 string
 		statusPayload             *string
+		attemptCount              int
+		nextAttemptAt             *time.Time
+		historyJSON               *string
+		sendAt                    *time.Time
+		batchID                   *int64
+		templateID                *int64
+		templateVersion           *int
+		traceparentCol            *string
 		sentAt                    time.Time
 		createdAt, updatedAt      time.Time
 	)
-	err := r.Pool.QueryRow(ctx, q, id).Scan(
-		&id, &convID, &source, &target,
+	if err := row.Scan(
+		&id, &tenantID, &convID, &source, &target,
 		&providerID, &providerMsgID,
 		&dirStr, &sentAt, &kindStr, &phoneCh,
 		&body, &attJSON, &statusStr, &statusPayload,
+		&attemptCount, &nextAttemptAt, &historyJSON, &sendAt, &batchID,
+		&templateID, &templateVersion, &traceparentCol,
 		&createdAt, &updatedAt,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return domain.Message{}, ErrNotFound
-		}
-		return domain.Message{}, fmt.Errorf("get message by id: %w", err)
+	); err != nil {
+		return domain.Message{}, err
 	}
 
 	var ch *domain.PhoneChannel
@@ -445,8 +634,19 @@ WHERE id = $1
 		prov = &p
 	}
 
-	m := domain.Message{
+	var tp string
+	if traceparentCol != nil {
+		tp = *traceparentCol
+	}
+
+	var tmpl *domain.TemplateRef
+	if templateID != nil && templateVersion != nil {
+		tmpl = &domain.TemplateRef{ID: *templateID, Version: *templateVersion}
+	}
+
+	return domain.Message{
 		ID:             id,
+		TenantID:       tenantID,
 		ConversationID: convID,
 		Source:         src,
 		Target:         trg,
@@ -457,93 +657,37 @@ WHERE id = $1
 		Status:         domain.Status(statusStr),
 		StatusPayload:  statusPayload,
 		Provider:       prov,
+		AttemptCount:   attemptCount,
+		NextAttemptAt:  nextAttemptAt,
+		Attempts:       decodeAttempts(historyJSON),
+		SendAt:         sendAt,
+		BatchID:        batchID,
+		TemplateRef:    tmpl,
+		Traceparent:    tp,
 		CreatedAt:      createdAt,
 		UpdatedAt:      updatedAt,
-	}
-	return m, nil
+	}, nil
 }
 
-func (r *MessageRepo) All(ctx context.Context, limit, offset int) ([]domain.Message, error) {
-	const q = `
-SELECT
-  id, conversation_id, endpoint_source, endpoint_target,
-  provider_id, provider_message_id,
-  inbound_or_outbound, sent_at, endpoint_kind, phone_channel,
-  body, attachments, status_tag, status_payload,
-  created_at, updated_at
-FROM messages
-ORDER BY sent_at DESC, id DESC
-LIMIT $1 OFFSET $2
-`
-	rows, err := r.Pool.Query(ctx, q, limit, offset)
-	if err != nil {
-		return nil, fmt.Errorf("list messages: %w", err)
+// templateColumns splits a template reference into its nullable columns.
+func templateColumns(t *domain.TemplateRef) (*int64, *int) {
+	if t == nil {
+		return nil, nil
 	}
-	defer rows.Close()
-
-	out := make([]domain.Message, 0)
-	for rows.Next() {
-		var (
-			id, convID                int64
-			source, target            string
-			providerID, providerMsgID *string
-			dirStr, kindStr           string
-			phoneCh                   *string
-			body                      string
-			attJSON                   *string
-			statusStr                 string
-			statusPayload             *string
-			sentAt                    time.Time
-			createdAt, updatedAt      time.Time
-		)
-		if err := rows.Scan(
-			&id, &convID, &source, &target,
-			&providerID, &providerMsgID,
-			&dirStr, &sentAt, &kindStr, &phoneCh,
-			&body, &attJSON, &statusStr, &statusPayload,
-			&createdAt, &updatedAt,
-		); err != nil {
-			return nil, err
-		}
-
-		var ch *domain.PhoneChannel
-		if phoneCh != nil {
-			c := domain.PhoneChannel(*phoneCh)
-			ch = &c
-		}
-		src := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: source, Channel: ch}
-		trg := domain.Endpoint{Kind: domain.EndpointKind(kindStr), Payload: target, Channel: ch}
-
-		var prov *domain.ProviderRef
-		if providerID != nil || providerMsgID != nil {
-			p := domain.ProviderRef{}
-			if providerID != nil {
-				p.ID = *providerID
-			}
-			if providerMsgID != nil {
-				p.MessageID = *providerMsgID
-			}
-			prov = &p
-		}
+	return &t.ID, &t.Version
+}
 
-		m := domain.Message{
-			ID:             id,
-			ConversationID: convID,
-			Source:         src,
-			Target:         trg,
-			Direction:      domain.InboundOrOutbound(dirStr),
-			SentAt:         sentAt,
-			Body:           body,
-			Attachments:    decodeAttachments(attJSON),
-			Status:         domain.Status(statusStr),
-			StatusPayload:  statusPayload,
-			Provider:       prov,
-			CreatedAt:      createdAt,
-			UpdatedAt:      updatedAt,
-		}
-		out = append(out, m)
+// traceparent is the trace context to store with m: its own, or that of the
+// span in ctx. Nil when there is neither.
+func traceparent(ctx context.Context, m domain.Message) *string {
+	tp := m.Traceparent
+	if tp == "" {
+		tp = tracing.Traceparent(ctx)
 	}
-	return out, rows.Err()
+	if tp == "" {
+		return nil
+	}
+	return &tp
 }
 
 // encodeAttachments converts []domain.Attachment (alias string) into JSON bytes.
@@ -574,3 +718,264 @@ func decodeAttachments(attJSON *string) []domain.Attachment {
 	}
 	return out
 }
+
+// decodeAttempts converts a nullable attempt_history JSON string into []domain.Attempt.
+func decodeAttempts(historyJSON *string) []domain.Attempt {
+	if historyJSON == nil || *historyJSON == "" {
+		return nil
+	}
+	var out []domain.Attempt
+	if err := json.Unmarshal([]byte(*historyJSON), &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// MessageQuery selects a page of a tenant's messages. Zero-valued filters
+// match everything.
+type MessageQuery struct {
+	ConversationID int64
+	BatchID        int64
+	Statuses       []domain.Status
+	Direction      domain.InboundOrOutbound
+	EndpointKind   domain.EndpointKind
+	Channel        domain.Channel
+	ProviderID     string
+	From           string    // endpoint_source
+	To             string    // endpoint_target
+	SentFrom       time.Time // inclusive
+	SentUntil      time.Time // exclusive
+
+	Ascending bool           // oldest first; newest first otherwise
+	After     *MessageCursor // continue after this position
+	Limit     int
+}
+
+// MessageCursor is a position in a (sent_at, id) ordered listing.
+type MessageCursor struct {
+	SentAt time.Time
+	ID     int64
+}
+
+// List returns the messages matching q and, when there are more, the cursor
+// to pass as q.After for the next page.
+func (r *MessageRepo) List(ctx context.Context, tenantID int64, q MessageQuery) ([]domain.Message, *MessageCursor, error) {
+	var w whereBuilder
+	q.filter(&w, tenantID)
+
+	cmp, dir := "<", "DESC"
+	if q.Ascending {
+		cmp, dir = ">", "ASC"
+	}
+	if q.After != nil {
+		w.add("(sent_at, id) "+cmp+" (?, ?)", q.After.SentAt, q.After.ID)
+	}
+
+	// one extra row tells whether there is a next page
+	sql := `
+SELECT ` + messageColumns + `
+FROM messages
+` + w.String() + `
+ORDER BY sent_at ` + dir + `, id ` + dir + `
+LIMIT ` + w.arg(q.Limit+1)
+
+	rows, err := r.Pool.Query(ctx, sql, w.args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list messages: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]domain.Message, 0)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(out) <= q.Limit {
+		return out, nil, nil
+	}
+	out = out[:q.Limit]
+	last := out[len(out)-1]
+	return out, &MessageCursor{SentAt: last.SentAt, ID: last.ID}, nil
+}
+
+// Filtered reports whether q narrows the tenant's messages at all.
+func (q MessageQuery) Filtered() bool {
+	return q.ConversationID != 0 || q.BatchID != 0 || len(q.Statuses) > 0 || q.Direction != "" ||
+		q.EndpointKind != "" || q.Channel != "" || q.ProviderID != "" ||
+		q.From != "" || q.To != "" || !q.SentFrom.IsZero() || !q.SentUntil.IsZero()
+}
+
+// filter adds the tenant and q's filters (but not its cursor) to w.
+func (q MessageQuery) filter(w *whereBuilder, tenantID int64) {
+	w.add("tenant_id = ?", tenantID)
+	if q.ConversationID != 0 {
+		w.add("conversation_id = ?", q.ConversationID)
+	}
+	if q.BatchID != 0 {
+		w.add("batch_id = ?", q.BatchID)
+	}
+	if len(q.Statuses) > 0 {
+		statuses := make([]string, len(q.Statuses))
+		for i, st := range q.Statuses {
+			statuses[i] = string(st)
+		}
+		w.add("status_tag::text = ANY(?::text[])", statuses)
+	}
+	if q.Direction != "" {
+		w.add("inbound_or_outbound = ?", string(q.Direction))
+	}
+	if q.EndpointKind != "" {
+		w.add("endpoint_kind = ?", q.EndpointKind.String())
+	}
+	switch q.Channel {
+	case "":
+	case domain.ChannelEmail:
+		w.add("endpoint_kind = ?", domain.EndpointKindEmail.String())
+	default:
+		w.add("phone_channel = ?", q.Channel.String())
+	}
+	if q.ProviderID != "" {
+		w.add("provider_id = ?", q.ProviderID)
+	}
+	if q.From != "" {
+		w.add("endpoint_source = ?", q.From)
+	}
+	if q.To != "" {
+		w.add("endpoint_target = ?", q.To)
+	}
+	if !q.SentFrom.IsZero() {
+		w.add("sent_at >= ?", q.SentFrom)
+	}
+	if !q.SentUntil.IsZero() {
+		w.add("sent_at < ?", q.SentUntil)
+	}
+}
+
+// ErrNotScheduled is returned when changing a message that is no longer (or
+// never was) waiting in status scheduled, e.g. because it was dispatched.
+var ErrNotScheduled = errors.New("message is not scheduled")
+
+// Reschedule changes the send_at and, if body is non-nil, the body of a
+// tenant's scheduled message. A replaced body no longer comes from a
+// template, so the template reference is cleared. Returns ErrNotFound or
+// ErrNotScheduled.
+func (r *MessageRepo) Reschedule(ctx context.Context, tenantID, id int64, sendAt time.Time, body *string) (domain.Message, error) {
+	const q = `
+UPDATE messages
+SET send_at = $3,
+    body = COALESCE($4, body),
+    template_id = CASE WHEN $4::text IS NULL THEN template_id END,
+    template_version = CASE WHEN $4::text IS NULL THEN template_version END,
+    updated_at = now()
+WHERE id = $1 AND tenant_id = $2 AND status_tag = 'scheduled'
+RETURNING ` + messageColumns + `
+`
+	m, err := scanMessage(r.Pool.QueryRow(ctx, q, id, tenantID, sendAt, body))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Message{}, r.missReason(ctx, tenantID, id, ErrNotScheduled)
+		}
+		return domain.Message{}, fmt.Errorf("reschedule message: %w", err)
+	}
+	return m, nil
+}
+
+// ErrNotCancelable is returned when canceling a message that a processor has
+// already claimed or that has reached a terminal status.
+var ErrNotCancelable = errors.New("message was already dispatched")
+
+// cancelable matches outbound messages no processor is working on, i.e. those
+// waiting in scheduled, outbox or retry without a live lease. The processor
+// claims rows with FOR UPDATE SKIP LOCKED, so a cancel and a claim of the
+// same row are serialized and exactly one of them wins. A processor whose
+// lease expired may still be working on a row that gets canceled; the
+// cancel clears the lease, and since UpdateStatus, Defer and RenewLease only
+// touch rows in outbox or retry, that processor gets ErrLeaseLost and the
+// row stays canceled.
+const cancelable = `
+  status_tag IN ('scheduled','outbox','retry')
+  AND (lease_expires_at IS NULL OR lease_expires_at < now())`
+
+// Cancel moves a tenant's pending message to status canceled. Canceling an
+// already canceled message returns it unchanged. Returns ErrNotFound or
+// ErrNotCancelable.
+func (r *MessageRepo) Cancel(ctx context.Context, tenantID, id int64, reason string) (domain.Message, error) {
+	const q = `
+UPDATE messages
+SET status_tag = 'canceled',
+    status_payload = $3,
+    next_attempt_at = NULL,
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    status_actor = 'api',
+    updated_at = now()
+WHERE id = $1 AND tenant_id = $2 AND` + cancelable + `
+RETURNING ` + messageColumns + `
+`
+	m, err := scanMessage(r.Pool.QueryRow(ctx, q, id, tenantID, reason))
+	if err == nil {
+		return m, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return domain.Message{}, fmt.Errorf("cancel message: %w", err)
+	}
+	m, err = r.GetByIDForTenant(ctx, tenantID, id)
+	if err != nil {
+		return domain.Message{}, err
+	}
+	if m.Status == domain.StatusCanceled {
+		return m, nil
+	}
+	return domain.Message{}, ErrNotCancelable
+}
+
+// CancelMatching cancels every pending message of the tenant that matches q's
+// filters and returns the ids of those canceled. Messages already dispatched
+// are left alone.
+func (r *MessageRepo) CancelMatching(ctx context.Context, tenantID int64, q MessageQuery, reason string) ([]int64, error) {
+	var w whereBuilder
+	q.filter(&w, tenantID)
+	w.add(cancelable)
+	sql := `
+UPDATE messages
+SET status_tag = 'canceled',
+    status_payload = ` + w.arg(reason) + `,
+    next_attempt_at = NULL,
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    status_actor = 'api',
+    updated_at = now()
+` + w.String() + `
+RETURNING id
+`
+	rows, err := r.Pool.Query(ctx, sql, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("cancel messages: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// missReason tells apart why a change to a tenant's message matched no row:
+// ErrNotFound if there is no such message, err otherwise.
+func (r *MessageRepo) missReason(ctx context.Context, tenantID, id int64, err error) error {
+	if _, getErr := r.GetByIDForTenant(ctx, tenantID, id); getErr != nil {
+		return getErr
+	}
+	return err
+}
@@ -5,7 +5,9 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -75,4 +77,574 @@ func TestInsertOrUpdateByProviderPair_Idempotent(t *testing.T) {
 	}
 }
 
+func TestClaimOutboxOrRetry_SkipsLeased(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "claim-a@example.com", "claim-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	id, err := r.Insert(ctx, domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "claim-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "claim-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now().Add(-24 * time.Hour),
+		Body:           "hello",
+		Status:         domain.StatusOutbox,
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	claimedBy := func(owner string, lease time.Duration) bool {
+		msgs, err := r.ClaimOutboxOrRetry(ctx, owner, 1000, lease)
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		for _, m := range msgs {
+			if m.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !claimedBy("worker-a", time.Minute) {
+		t.Fatalf("worker-a did not claim message %d", id)
+	}
+	if claimedBy("worker-b", time.Minute) {
+		t.Fatalf("worker-b claimed message %d while leased", id)
+	}
+
+	// an expired lease is reclaimable
+	if _, err := pool.Exec(ctx, `UPDATE messages SET lease_expires_at = now() - interval '1 second' WHERE id = $1`, id); err != nil {
+		t.Fatalf("expire lease: %v", err)
+	}
+	if !claimedBy("worker-b", time.Minute) {
+		t.Fatalf("worker-b did not reclaim expired message %d", id)
+	}
+
+	// worker-a may no longer send or record it
+	if err := r.RenewLease(ctx, id, "worker-a", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("renew by worker-a: want ErrLeaseLost, got %v", err)
+	}
+	if err := r.UpdateStatus(ctx, id, "worker-a", domain.StatusOK, nil, nil, nil, nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("update by worker-a: want ErrLeaseLost, got %v", err)
+	}
+	if err := r.Defer(ctx, id, "worker-a", time.Now()); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("defer by worker-a: want ErrLeaseLost, got %v", err)
+	}
+	if err := r.RenewLease(ctx, id, "worker-b", time.Minute); err != nil {
+		t.Fatalf("renew by worker-b: %v", err)
+	}
+	if err := r.ReleaseLeases(ctx, "worker-b", []int64{id}); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if !claimedBy("worker-c", time.Minute) {
+		t.Fatalf("worker-c did not claim released message %d", id)
+	}
+	if err := r.UpdateStatus(ctx, id, "worker-c", domain.StatusOK, nil, nil, nil, nil); err != nil {
+		t.Fatalf("update by worker-c: %v", err)
+	}
+	// a recorded outcome releases the lease, so it cannot be recorded twice
+	if err := r.UpdateStatus(ctx, id, "worker-c", domain.StatusOK, nil, nil, nil, nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("second update: want ErrLeaseLost, got %v", err)
+	}
+}
+
 func strPtr(s string) *string { return &s }
+
+// leaseTo leases message id to owner, as a claim would.
+func leaseTo(t *testing.T, pool *pgxpool.Pool, id int64, owner string) {
+	t.Helper()
+	_, err := pool.Exec(context.Background(),
+		`UPDATE messages SET lease_owner = $2, lease_expires_at = now() + interval '1 minute' WHERE id = $1`, id, owner)
+	if err != nil {
+		t.Fatalf("lease message %d: %v", id, err)
+	}
+}
+
+func TestList_KeysetPages(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "page-a@example.com", "page-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	// five messages, two sharing a sent_at so the id tie-breaker matters
+	base := time.Now().UTC().Truncate(time.Second)
+	for i, off := range []int{0, 1, 1, 2, 3} {
+		_, err := r.Insert(ctx, domain.Message{
+			ConversationID: convID,
+			Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "page-a@example.com"},
+			Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "page-b@example.com"},
+			Direction:      domain.Outbound,
+			SentAt:         base.Add(time.Duration(off) * time.Second),
+			Body:           "page " + string(rune('0'+i)),
+			Status:         domain.StatusOutbox,
+		})
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	q := MessageQuery{ConversationID: convID, Ascending: true, Limit: 2}
+	var seen []int64
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("too many pages")
+		}
+		msgs, next, err := r.List(ctx, domain.DefaultTenantID, q)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, m := range msgs {
+			seen = append(seen, m.ID)
+		}
+		if next == nil {
+			break
+		}
+		q.After = next
+	}
+	if len(seen) != 5 {
+		t.Fatalf("saw %d messages, want 5: %v", len(seen), seen)
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i] <= seen[i-1] {
+			t.Fatalf("out of order or repeated: %v", seen)
+		}
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "sched-a@example.com", "sched-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	later := time.Now().Add(time.Hour)
+	id, err := r.Insert(ctx, domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "sched-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "sched-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "later",
+		Status:         domain.StatusScheduled,
+		SendAt:         &later,
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	claimed := func() bool {
+		msgs, err := r.ClaimOutboxOrRetry(ctx, "test-"+t.Name(), 1000, time.Minute)
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		for _, m := range msgs {
+			if m.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	if claimed() {
+		t.Fatal("claimed a message scheduled for later")
+	}
+
+	// due now: the next poll dispatches it and it can no longer be changed
+	if _, err := r.Reschedule(ctx, domain.DefaultTenantID, id, time.Now().Add(-time.Second), nil); err != nil {
+		t.Fatalf("reschedule: %v", err)
+	}
+	if !claimed() {
+		t.Fatal("due message not claimed")
+	}
+	if _, err := r.Reschedule(ctx, domain.DefaultTenantID, id, later, nil); !errors.Is(err, ErrNotScheduled) {
+		t.Fatalf("want ErrNotScheduled, got %v", err)
+	}
+	if _, err := r.Reschedule(ctx, domain.DefaultTenantID, -1, later, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "cancel-a@example.com", "cancel-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	insert := func(status domain.Status) int64 {
+		id, err := r.Insert(ctx, domain.Message{
+			ConversationID: convID,
+			Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "cancel-a@example.com"},
+			Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "cancel-b@example.com"},
+			Direction:      domain.Outbound,
+			SentAt:         time.Now(),
+			Body:           "cancel me",
+			Status:         status,
+		})
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		return id
+	}
+
+	pending := insert(domain.StatusOutbox)
+	m, err := r.Cancel(ctx, domain.DefaultTenantID, pending, "test")
+	if err != nil || m.Status != domain.StatusCanceled {
+		t.Fatalf("cancel: %v, %v", m.Status, err)
+	}
+	if _, err := r.Cancel(ctx, domain.DefaultTenantID, pending, "test"); err != nil {
+		t.Fatalf("second cancel: %v", err)
+	}
+
+	sent := insert(domain.StatusOK)
+	if _, err := r.Cancel(ctx, domain.DefaultTenantID, sent, "test"); !errors.Is(err, ErrNotCancelable) {
+		t.Fatalf("want ErrNotCancelable, got %v", err)
+	}
+
+	// a row leased by a processor is being sent and cannot be canceled
+	leased := insert(domain.StatusOutbox)
+	if _, err := pool.Exec(ctx, `UPDATE messages SET lease_owner = 'p', lease_expires_at = now() + interval '1 minute' WHERE id = $1`, leased); err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if _, err := r.Cancel(ctx, domain.DefaultTenantID, leased, "test"); !errors.Is(err, ErrNotCancelable) {
+		t.Fatalf("want ErrNotCancelable, got %v", err)
+	}
+
+	// a processor whose lease expired loses the race with a cancel, and
+	// whatever it tries to record afterwards leaves the row canceled
+	expired := insert(domain.StatusOutbox)
+	if _, err := pool.Exec(ctx, `UPDATE messages SET lease_owner = 'slow', lease_expires_at = now() - interval '1 second' WHERE id = $1`, expired); err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if m, err := r.Cancel(ctx, domain.DefaultTenantID, expired, "test"); err != nil || m.Status != domain.StatusCanceled {
+		t.Fatalf("cancel expired lease: %v, %v", m.Status, err)
+	}
+	if err := r.RenewLease(ctx, expired, "slow", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("renew after cancel: want ErrLeaseLost, got %v", err)
+	}
+	pid, pmid := "sendgrid", "late-"+strconv.FormatInt(expired, 10)
+	if err := r.UpdateStatus(ctx, expired, "slow", domain.StatusOK, &pid, &pmid, nil, nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("update after cancel: want ErrLeaseLost, got %v", err)
+	}
+	if err := r.UpdateStatus(ctx, expired, "slow", domain.StatusRetry, nil, nil, nil, nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("retry after cancel: want ErrLeaseLost, got %v", err)
+	}
+	if err := r.Defer(ctx, expired, "slow", time.Now().Add(time.Minute)); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("defer after cancel: want ErrLeaseLost, got %v", err)
+	}
+	m, err = r.GetByIDForTenant(ctx, domain.DefaultTenantID, expired)
+	if err != nil || m.Status != domain.StatusCanceled || m.Provider != nil {
+		t.Fatalf("after the late update: %+v, %v", m, err)
+	}
+	var owner *string
+	if err := pool.QueryRow(ctx, `SELECT lease_owner FROM messages WHERE id = $1`, expired).Scan(&owner); err != nil || owner != nil {
+		t.Fatalf("lease owner after cancel: %v, %v", owner, err)
+	}
+
+	retry := insert(domain.StatusRetry)
+	ids, err := r.CancelMatching(ctx, domain.DefaultTenantID, MessageQuery{ConversationID: convID}, "bulk")
+	if err != nil {
+		t.Fatalf("cancel matching: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != retry {
+		t.Fatalf("canceled %v, want [%d]", ids, retry)
+	}
+}
+
+func TestListenOutbox(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	l, err := r.ListenOutbox(ctx)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "notify-a@example.com", "notify-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	if _, err := r.Insert(ctx, domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "notify-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "notify-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "hello",
+		Status:         domain.StatusOutbox,
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := l.Wait(waitCtx); err != nil {
+		t.Fatalf("no notification for outbox insert: %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "events-a@example.com", "events-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+
+	id, err := r.Insert(ctx, domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "events-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "events-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "track me",
+		Status:         domain.StatusOutbox,
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	leaseTo(t, pool, id, "events")
+	if err := r.UpdateStatus(ctx, id, "events", domain.StatusRetry, strPtr("sendgrid"), nil, strPtr("timeout"), nil); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	leaseTo(t, pool, id, "events")
+	if err := r.UpdateStatus(ctx, id, "events", domain.StatusOK, strPtr("sendgrid"), strPtr("events-1"), strPtr("accepted"), nil); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	if _, err := r.UpdateDeliveryStatus(ctx, "sendgrid", "events-1", domain.StatusDelivered, nil); err != nil {
+		t.Fatalf("update delivery status: %v", err)
+	}
+
+	events, err := r.Events(ctx, domain.DefaultTenantID, id)
+	if err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	want := []struct {
+		from, to domain.Status
+		attempt  int
+		actor    domain.Actor
+	}{
+		{"", domain.StatusOutbox, 0, domain.ActorAPI},
+		{domain.StatusOutbox, domain.StatusRetry, 1, domain.ActorProcessor},
+		{domain.StatusRetry, domain.StatusOK, 2, domain.ActorProcessor},
+		{domain.StatusOK, domain.StatusDelivered, 2, domain.ActorProvider},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		var from domain.Status
+		if e.FromStatus != nil {
+			from = *e.FromStatus
+		}
+		if from != w.from || e.ToStatus != w.to || e.Attempt != w.attempt || e.Actor != w.actor {
+			t.Errorf("event %d = %s -> %s attempt %d by %s, want %s -> %s attempt %d by %s",
+				i, from, e.ToStatus, e.Attempt, e.Actor, w.from, w.to, w.attempt, w.actor)
+		}
+	}
+	if events[1].ProviderID != "sendgrid" || events[1].Payload == nil || *events[1].Payload != "timeout" {
+		t.Errorf("failed attempt = %+v", events[1])
+	}
+
+	if _, err := r.Events(ctx, domain.DefaultTenantID+1, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other tenant: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestConversationNotifications(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewMessageRepo(pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, "email", nil, "stream-a@example.com", "stream-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `DELETE FROM conversations WHERE id = $1`, convID)
+	})
+	if last, err := r.LastConversationEventID(ctx, convID); err != nil || last != 0 {
+		t.Fatalf("last event of an empty conversation: %d, %v", last, err)
+	}
+
+	l, err := r.ListenConversations(ctx)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	id, err := r.Insert(ctx, domain.Message{
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "stream-b@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "stream-a@example.com"},
+		Direction:      domain.Inbound,
+		SentAt:         time.Now(),
+		Body:           "live",
+		Status:         domain.StatusOK,
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// other tests may commit events at the same time
+	var n ConversationNotification
+	for n.ConversationID != convID {
+		if n, err = l.Wait(ctx); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	events, err := r.ConversationEvents(ctx, domain.DefaultTenantID, convID, 0, 10)
+	if err != nil || len(events) != 1 || events[0].ID != n.EventID || events[0].MessageID != id {
+		t.Fatalf("events: %+v, %v; notified %+v", events, err, n)
+	}
+	if last, err := r.LastConversationEventID(ctx, convID); err != nil || last != n.EventID {
+		t.Fatalf("last event: %d, %v", last, err)
+	}
+	if after, err := r.ConversationEvents(ctx, domain.DefaultTenantID, convID, n.EventID, 10); err != nil || len(after) != 0 {
+		t.Fatalf("events after the last: %+v, %v", after, err)
+	}
+	if other, err := r.ConversationEvents(ctx, domain.DefaultTenantID+1, convID, 0, 10); err != nil || len(other) != 0 {
+		t.Fatalf("other tenant: %+v, %v", other, err)
+	}
+}
@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// whereBuilder collects AND-ed conditions and their positional arguments for
+// queries whose filters are optional.
+type whereBuilder struct {
+	conds []string
+	args  []any
+}
+
+// add appends cond, in which every "?" is replaced by the placeholder of the
+// next argument in vals.
+func (b *whereBuilder) add(cond string, vals ...any) {
+	for _, v := range vals {
+		b.args = append(b.args, v)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(b.args)), 1)
+	}
+	b.conds = append(b.conds, cond)
+}
+
+// arg registers v without a condition and returns its placeholder.
+func (b *whereBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *whereBuilder) String() string {
+	if len(b.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conds, " AND ")
+}
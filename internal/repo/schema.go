@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SchemaVersion is the last migration this build depends on. Bump it with
+// every new migration.
+const SchemaVersion = "017_conversation_activity"
+
+var ErrSchemaOutdated = errors.New("schema outdated")
+
+type SchemaRepo struct {
+	Pool *pgxpool.Pool
+}
+
+func NewSchemaRepo(pool *pgxpool.Pool) *SchemaRepo {
+	return &SchemaRepo{Pool: pool}
+}
+
+// Check returns ErrSchemaOutdated unless SchemaVersion has been applied.
+func (r *SchemaRepo) Check(ctx context.Context) error {
+	var ok bool
+	err := r.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, SchemaVersion).Scan(&ok)
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: migration %s not applied", ErrSchemaOutdated, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// SubscriptionSecretPrefix starts every generated signing secret.
+const SubscriptionSecretPrefix = "whsec_"
+
+type SubscriptionRepo struct {
+	Pool *pgxpool.Pool
+}
+
+func NewSubscriptionRepo(pool *pgxpool.Pool) *SubscriptionRepo {
+	return &SubscriptionRepo{Pool: pool}
+}
+
+// SubscriptionUpdate changes the fields of a subscription that are set.
+type SubscriptionUpdate struct {
+	URL     *string
+	Events  []domain.EventType // unchanged if nil
+	Enabled *bool              // enabling also resets the failure count
+}
+
+// subscriptionColumns is the column list read by scanSubscription.
+const subscriptionColumns = `
+  id, tenant_id, url, event_types, consecutive_failures, disabled_at, disabled_reason,
+  created_at, updated_at`
+
+// Create adds a subscription for the tenant. A random secret is generated if
+// secret is empty; the returned subscription carries it.
+func (r *SubscriptionRepo) Create(ctx context.Context, tenantID int64, url string, events []domain.EventType, secret string) (domain.Subscription, error) {
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return domain.Subscription{}, fmt.Errorf("generate subscription secret: %w", err)
+		}
+		secret = SubscriptionSecretPrefix + hex.EncodeToString(buf)
+	}
+	const q = `
+INSERT INTO subscriptions (tenant_id, url, secret, event_types)
+VALUES ($1, $2, $3, $4)
+RETURNING ` + subscriptionColumns
+	s, err := scanSubscription(r.Pool.QueryRow(ctx, q, tenantOrDefault(tenantID), url, secret, eventTypeStrings(events)))
+	if err != nil {
+		return domain.Subscription{}, fmt.Errorf("insert subscription: %w", err)
+	}
+	s.Secret = secret
+	return s, nil
+}
+
+// Get returns a tenant's subscription without its secret, or ErrNotFound.
+func (r *SubscriptionRepo) Get(ctx context.Context, tenantID, id int64) (domain.Subscription, error) {
+	const q = `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE id = $1 AND tenant_id = $2`
+	s, err := scanSubscription(r.Pool.QueryRow(ctx, q, id, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Subscription{}, ErrNotFound
+		}
+		return domain.Subscription{}, fmt.Errorf("get subscription: %w", err)
+	}
+	return s, nil
+}
+
+// List returns every subscription of the tenant, oldest first.
+func (r *SubscriptionRepo) List(ctx context.Context, tenantID int64) ([]domain.Subscription, error) {
+	const q = `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE tenant_id = $1 ORDER BY id ASC`
+	rows, err := r.Pool.Query(ctx, q, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list subscriptions: %w", err)
+	}
+	defer rows.Close()
+	out := make([]domain.Subscription, 0)
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan subscription: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Update applies u to a tenant's subscription and returns it, or ErrNotFound.
+func (r *SubscriptionRepo) Update(ctx context.Context, tenantID, id int64, u SubscriptionUpdate) (domain.Subscription, error) {
+	var events []string
+	if u.Events != nil {
+		events = eventTypeStrings(u.Events)
+	}
+	const q = `
+UPDATE subscriptions
+SET url = COALESCE($3, url),
+    event_types = COALESCE($4, event_types),
+    consecutive_failures = CASE WHEN $5::boolean THEN 0 ELSE consecutive_failures END,
+    disabled_at = CASE
+      WHEN $5::boolean THEN NULL
+      WHEN NOT $5::boolean THEN COALESCE(disabled_at, now())
+      ELSE disabled_at
+    END,
+    disabled_reason = CASE
+      WHEN $5::boolean THEN NULL
+      WHEN NOT $5::boolean AND disabled_at IS NULL THEN 'disabled by client'
+      ELSE disabled_reason
+    END,
+    updated_at = now()
+WHERE id = $1 AND tenant_id = $2
+RETURNING ` + subscriptionColumns
+	s, err := scanSubscription(r.Pool.QueryRow(ctx, q, id, tenantID, u.URL, events, u.Enabled))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Subscription{}, ErrNotFound
+		}
+		return domain.Subscription{}, fmt.Errorf("update subscription: %w", err)
+	}
+	return s, nil
+}
+
+// Delete removes a tenant's subscription together with its deliveries.
+func (r *SubscriptionRepo) Delete(ctx context.Context, tenantID, id int64) error {
+	tag, err := r.Pool.Exec(ctx, `DELETE FROM subscriptions WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deliveryColumns is the column list read by scanDelivery.
+const deliveryColumns = `
+  d.id, d.subscription_id, d.event_type, d.message_id, d.message_event_id, d.conversation_id,
+  d.status, d.attempt_count, d.next_attempt_at, d.attempt_history, d.created_at, d.updated_at`
+
+// Deliveries returns the latest deliveries of a tenant's subscription, newest
+// first. Returns ErrNotFound if the tenant has no such subscription.
+func (r *SubscriptionRepo) Deliveries(ctx context.Context, tenantID, id int64, limit int) ([]domain.Delivery, error) {
+	if _, err := r.Get(ctx, tenantID, id); err != nil {
+		return nil, err
+	}
+	const q = `
+SELECT ` + deliveryColumns + `
+FROM webhook_deliveries d
+WHERE d.subscription_id = $1
+ORDER BY d.id DESC
+LIMIT $2
+`
+	rows, err := r.Pool.Query(ctx, q, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list deliveries: %w", err)
+	}
+	defer rows.Close()
+	out := make([]domain.Delivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// PendingDelivery is a claimed delivery with the endpoint to POST it to.
+type PendingDelivery struct {
+	domain.Delivery
+	TenantID int64
+	URL      string
+	Secret   string
+}
+
+// ClaimDeliveries leases up to limit due deliveries of enabled subscriptions
+// to owner, the longest waiting first. Like ClaimOutboxOrRetry, rows leased by
+// another worker are skipped until their lease expires.
+func (r *SubscriptionRepo) ClaimDeliveries(ctx context.Context, owner string, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	const q = `
+WITH claimable AS (
+  SELECT d.id
+  FROM webhook_deliveries d
+  JOIN subscriptions s ON s.id = d.subscription_id
+  WHERE d.status = 'pending'
+    AND d.next_attempt_at <= now()
+    AND (d.lease_expires_at IS NULL OR d.lease_expires_at < now())
+    AND s.disabled_at IS NULL
+  ORDER BY d.next_attempt_at ASC
+  LIMIT $2
+  FOR UPDATE OF d SKIP LOCKED
+)
+UPDATE webhook_deliveries d
+SET lease_owner = $1,
+    lease_expires_at = now() + make_interval(secs => $3)
+FROM claimable, subscriptions s
+WHERE d.id = claimable.id AND s.id = d.subscription_id
+RETURNING ` + deliveryColumns + `, s.tenant_id, s.url, s.secret
+`
+	rows, err := r.Pool.Query(ctx, q, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim deliveries: %w", err)
+	}
+	defer rows.Close()
+	var out []PendingDelivery
+	for rows.Next() {
+		var p PendingDelivery
+		if p.Delivery, err = scanDelivery(rows, &p.TenantID, &p.URL, &p.Secret); err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// RenewDeliveryLease extends owner's lease on a claimed delivery to lease from
+// now. Returns ErrLeaseLost if another worker has claimed it since, or it is
+// no longer pending. The delivery worker renews right before each POST, so a
+// delivery whose lease ran out while it waited for a free worker is never
+// posted twice.
+func (r *SubscriptionRepo) RenewDeliveryLease(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	const q = `
+UPDATE webhook_deliveries
+SET lease_expires_at = now() + make_interval(secs => $3)
+WHERE id = $1 AND lease_owner = $2 AND status = 'pending'
+`
+	tag, err := r.Pool.Exec(ctx, q, id, owner, lease.Seconds())
+	if err != nil {
+		return fmt.Errorf("renew delivery lease: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// RecordAttempt appends attempt to a delivery and releases owner's lease on
+// it. A successful attempt delivers it and resets the subscription's failure
+// count. A failed one schedules the next attempt at next, or fails the
+// delivery if next is nil, and disables the subscription once disableAfter
+// attempts in a row have failed (0 never disables it). disabled reports
+// whether this attempt disabled it. If owner no longer holds the lease nothing
+// is changed and ErrLeaseLost is returned.
+func (r *SubscriptionRepo) RecordAttempt(
+	ctx context.Context,
+	d PendingDelivery,
+	owner string,
+	attempt domain.DeliveryAttempt,
+	next *time.Time,
+	disableAfter int,
+) (disabled bool, err error) {
+	status := domain.DeliveryPending
+	switch {
+	case attempt.OK():
+		status, next = domain.DeliveryDelivered, nil
+	case next == nil:
+		status = domain.DeliveryFailed
+	}
+	entry, err := json.Marshal(attempt)
+	if err != nil {
+		return false, fmt.Errorf("encode delivery attempt: %w", err)
+	}
+
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const upd = `
+UPDATE webhook_deliveries
+SET status = $2,
+    attempt_count = attempt_count + 1,
+    attempt_history = attempt_history || jsonb_build_array($3::jsonb),
+    next_attempt_at = COALESCE($4, next_attempt_at),
+    lease_owner = NULL,
+    lease_expires_at = NULL,
+    updated_at = now()
+WHERE id = $1 AND lease_owner = $5 AND status = 'pending'
+`
+	tag, err := tx.Exec(ctx, upd, d.ID, string(status), string(entry), next, owner)
+	if err != nil {
+		return false, fmt.Errorf("update delivery: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, ErrLeaseLost
+	}
+
+	const sub = `
+WITH prev AS (
+  SELECT id, disabled_at FROM subscriptions WHERE id = $1 FOR UPDATE
+)
+UPDATE subscriptions s
+SET consecutive_failures = CASE WHEN $2::boolean THEN 0 ELSE s.consecutive_failures + 1 END,
+    disabled_at = CASE
+      WHEN NOT $2::boolean AND $3::int > 0 AND s.consecutive_failures + 1 >= $3::int THEN COALESCE(s.disabled_at, now())
+      ELSE s.disabled_at
+    END,
+    disabled_reason = CASE
+      WHEN NOT $2::boolean AND $3::int > 0 AND s.consecutive_failures + 1 >= $3::int AND s.disabled_at IS NULL
+        THEN format('%s deliveries in a row failed', s.consecutive_failures + 1)
+      ELSE s.disabled_reason
+    END,
+    updated_at = now()
+FROM prev
+WHERE s.id = prev.id
+RETURNING prev.disabled_at IS NULL AND s.disabled_at IS NOT NULL
+`
+	if err := tx.QueryRow(ctx, sub, d.SubscriptionID, attempt.OK(), disableAfter).Scan(&disabled); err != nil {
+		return false, fmt.Errorf("update subscription: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return disabled, nil
+}
+
+func scanSubscription(row pgx.Row) (domain.Subscription, error) {
+	var (
+		s      domain.Subscription
+		events []string
+	)
+	if err := row.Scan(&s.ID, &s.TenantID, &s.URL, &events, &s.ConsecutiveFailures, &s.DisabledAt, &s.DisabledReason,
+		&s.CreatedAt, &s.UpdatedAt); err != nil {
+		return domain.Subscription{}, err
+	}
+	s.Events = make([]domain.EventType, len(events))
+	for i, e := range events {
+		s.Events[i] = domain.EventType(e)
+	}
+	s.Enabled = s.DisabledAt == nil
+	return s, nil
+}
+
+// scanDelivery reads a row selected with deliveryColumns followed by extra.
+func scanDelivery(row pgx.Row, extra ...any) (domain.Delivery, error) {
+	var (
+		d           domain.Delivery
+		eventType   string
+		status      string
+		historyJSON []byte
+	)
+	dest := []any{&d.ID, &d.SubscriptionID, &eventType, &d.MessageID, &d.MessageEventID, &d.ConversationID,
+		&status, &d.AttemptCount, &d.NextAttemptAt, &historyJSON, &d.CreatedAt, &d.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return domain.Delivery{}, err
+	}
+	d.EventType = domain.EventType(eventType)
+	d.Status = domain.DeliveryStatus(status)
+	if d.Status != domain.DeliveryPending {
+		d.NextAttemptAt = nil
+	}
+	d.Attempts = make([]domain.DeliveryAttempt, 0)
+	if err := json.Unmarshal(historyJSON, &d.Attempts); err != nil {
+		return domain.Delivery{}, fmt.Errorf("decode attempt_history: %w", err)
+	}
+	return d, nil
+}
+
+func eventTypeStrings(events []domain.EventType) []string {
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = string(e)
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+//go:build integration
+// +build integration
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestSubscriptionDeliveries(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewSubscriptionRepo(pool)
+	msgs := NewMessageRepo(pool)
+	ctx := context.Background()
+
+	// a tenant of its own, so other tests' messages queue nothing here
+	var tenantID int64
+	err = pool.QueryRow(ctx, `INSERT INTO tenants (name) VALUES ($1) RETURNING id`, "subs-"+uuid.NewString()).Scan(&tenantID)
+	if err != nil {
+		t.Fatalf("insert tenant: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM messages WHERE tenant_id = $1`, tenantID)
+		_, _ = pool.Exec(ctx, `DELETE FROM conversations WHERE tenant_id = $1`, tenantID)
+		_, _ = pool.Exec(ctx, `DELETE FROM tenants WHERE id = $1`, tenantID)
+	})
+
+	s, err := r.Create(ctx, tenantID, "https://example.com/hook",
+		[]domain.EventType{domain.EventMessageReceived, domain.EventConversationCreated}, "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !strings.HasPrefix(s.Secret, SubscriptionSecretPrefix) || !s.Enabled {
+		t.Fatalf("unexpected subscription: %+v", s)
+	}
+	if got, err := r.Get(ctx, tenantID, s.ID); err != nil || got.Secret != "" {
+		t.Fatalf("get: %+v, %v", got, err)
+	}
+	if _, err := r.Get(ctx, domain.DefaultTenantID, s.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound for another tenant, got %v", err)
+	}
+
+	var convID int64
+	err = pool.QueryRow(ctx, `
+		INSERT INTO conversations (tenant_id, endpoint_kind, phone_channel, endpoint_source, endpoint_target)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, tenantID, "email", nil, "subs-a@example.com", "subs-b@example.com").Scan(&convID)
+	if err != nil {
+		t.Fatalf("insert conversation: %v", err)
+	}
+	if _, err := msgs.Insert(ctx, domain.Message{
+		TenantID:       tenantID,
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "subs-b@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "subs-a@example.com"},
+		Direction:      domain.Inbound,
+		SentAt:         time.Now(),
+		Body:           "hello",
+		Status:         domain.StatusOK,
+	}); err != nil {
+		t.Fatalf("insert inbound: %v", err)
+	}
+	// not subscribed to message.sent
+	if _, err := msgs.Insert(ctx, domain.Message{
+		TenantID:       tenantID,
+		ConversationID: convID,
+		Source:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "subs-a@example.com"},
+		Target:         domain.Endpoint{Kind: domain.EndpointKindEmail, Payload: "subs-b@example.com"},
+		Direction:      domain.Outbound,
+		SentAt:         time.Now(),
+		Body:           "reply",
+		Status:         domain.StatusOK,
+	}); err != nil {
+		t.Fatalf("insert outbound: %v", err)
+	}
+
+	ds, err := r.Deliveries(ctx, tenantID, s.ID, 10)
+	if err != nil {
+		t.Fatalf("deliveries: %v", err)
+	}
+	if len(ds) != 2 || ds[0].EventType != domain.EventMessageReceived || ds[1].EventType != domain.EventConversationCreated {
+		t.Fatalf("unexpected deliveries: %+v", ds)
+	}
+
+	claimed := claimFor(t, r, s.ID)
+	if len(claimed) != 2 || claimed[0].URL != s.URL || claimed[0].Secret != s.Secret || claimed[0].TenantID != tenantID {
+		t.Fatalf("unexpected claim: %+v", claimed)
+	}
+	if again := claimFor(t, r, s.ID); len(again) != 0 {
+		t.Fatalf("leased deliveries claimed again: %+v", again)
+	}
+
+	ok := domain.DeliveryAttempt{Number: 1, At: time.Now(), StatusCode: 200}
+	if err := r.RenewDeliveryLease(ctx, claimed[0].ID, testOwner, time.Minute); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	// a worker whose lease was taken over changes nothing
+	if err := r.RenewDeliveryLease(ctx, claimed[0].ID, "someone-else", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("renew by another owner: want ErrLeaseLost, got %v", err)
+	}
+	if _, err := r.RecordAttempt(ctx, claimed[0], "someone-else", ok, nil, 2); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("record by another owner: want ErrLeaseLost, got %v", err)
+	}
+	if disabled, err := r.RecordAttempt(ctx, claimed[0], testOwner, ok, nil, 2); err != nil || disabled {
+		t.Fatalf("record ok: %v, %v", disabled, err)
+	}
+	failed := domain.DeliveryAttempt{Number: 1, At: time.Now(), StatusCode: 500, Error: "unexpected status 500"}
+	next := time.Now().Add(-time.Second)
+	if disabled, err := r.RecordAttempt(ctx, claimed[1], testOwner, failed, &next, 2); err != nil || disabled {
+		t.Fatalf("record failure: %v, %v", disabled, err)
+	}
+	retry := claimFor(t, r, s.ID)
+	if len(retry) != 1 || retry[0].ID != claimed[1].ID || retry[0].AttemptCount != 1 {
+		t.Fatalf("unexpected retry claim: %+v", retry)
+	}
+	failed.Number = 2
+	if disabled, err := r.RecordAttempt(ctx, retry[0], testOwner, failed, nil, 2); err != nil || !disabled {
+		t.Fatalf("second failure should disable: %v, %v", disabled, err)
+	}
+
+	got, err := r.Get(ctx, tenantID, s.ID)
+	if err != nil || got.Enabled || got.ConsecutiveFailures != 2 || got.DisabledReason == nil {
+		t.Fatalf("want disabled subscription, got %+v, %v", got, err)
+	}
+	ds, err = r.Deliveries(ctx, tenantID, s.ID, 10)
+	if err != nil || ds[0].Status != domain.DeliveryFailed || len(ds[0].Attempts) != 2 || ds[1].Status != domain.DeliveryDelivered {
+		t.Fatalf("unexpected deliveries: %+v, %v", ds, err)
+	}
+
+	enabled := true
+	got, err = r.Update(ctx, tenantID, s.ID, SubscriptionUpdate{Enabled: &enabled})
+	if err != nil || !got.Enabled || got.ConsecutiveFailures != 0 || got.DisabledAt != nil {
+		t.Fatalf("re-enable: %+v, %v", got, err)
+	}
+
+	if err := r.Delete(ctx, tenantID, s.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := r.Delete(ctx, tenantID, s.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound after delete, got %v", err)
+	}
+}
+
+// testOwner holds the leases of deliveries claimed by claimFor.
+var testOwner = "test-" + uuid.NewString()
+
+// claimFor claims due deliveries and keeps those of subscription id,
+// oldest first; deliveries of other subscriptions are left to their lease.
+func claimFor(t *testing.T, r *SubscriptionRepo, id int64) []PendingDelivery {
+	t.Helper()
+	ds, err := r.ClaimDeliveries(context.Background(), testOwner, 1000, time.Minute)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	var out []PendingDelivery
+	for _, d := range ds {
+		if d.SubscriptionID == id {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
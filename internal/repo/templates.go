@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+// ErrTemplateExists is returned by TemplateRepo.Create when the tenant already
+// has a template with that name on that channel.
+var ErrTemplateExists = errors.New("template already exists")
+
+type TemplateRepo struct {
+	Pool *pgxpool.Pool
+}
+
+func NewTemplateRepo(pool *pgxpool.Pool) *TemplateRepo {
+	return &TemplateRepo{Pool: pool}
+}
+
+// TemplateQuery selects a page of templates; see TemplateRepo.List.
+type TemplateQuery struct {
+	Name    string
+	Channel domain.Channel
+	AfterID int64
+	Limit   int
+}
+
+// templateSelect reads a template joined with one of its versions, in the
+// order scanned by scanTemplate.
+const templateSelect = `
+SELECT t.id, t.tenant_id, t.name, t.channel::text, v.version, v.body, t.created_at, v.created_at
+FROM templates t
+JOIN template_versions v ON v.template_id = t.id
+`
+
+// Create stores version 1 of a new template.
+func (r *TemplateRepo) Create(ctx context.Context, tenantID int64, name string, ch domain.Channel, body string) (domain.Template, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return domain.Template{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const ins = `
+INSERT INTO templates (tenant_id, name, channel) VALUES ($1, $2, $3::channel)
+ON CONFLICT (tenant_id, name, channel) DO NOTHING
+RETURNING id
+`
+	var id int64
+	if err := tx.QueryRow(ctx, ins, tenantOrDefault(tenantID), name, ch.String()).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Template{}, ErrTemplateExists
+		}
+		return domain.Template{}, fmt.Errorf("insert template: %w", err)
+	}
+	t, err := insertTemplateVersion(ctx, tx, id, 1, body)
+	if err != nil {
+		return domain.Template{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Template{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+// AddVersion stores body as the next version of a tenant's template and
+// returns it. Concurrent edits are serialized by the row lock on templates.
+func (r *TemplateRepo) AddVersion(ctx context.Context, tenantID, id int64, body string) (domain.Template, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return domain.Template{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const bump = `
+UPDATE templates SET latest_version = latest_version + 1, updated_at = now()
+WHERE id = $1 AND tenant_id = $2
+RETURNING latest_version
+`
+	var version int
+	if err := tx.QueryRow(ctx, bump, id, tenantID).Scan(&version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Template{}, ErrNotFound
+		}
+		return domain.Template{}, fmt.Errorf("bump template version: %w", err)
+	}
+	t, err := insertTemplateVersion(ctx, tx, id, version, body)
+	if err != nil {
+		return domain.Template{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Template{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+func insertTemplateVersion(ctx context.Context, tx pgx.Tx, id int64, version int, body string) (domain.Template, error) {
+	const ins = `INSERT INTO template_versions (template_id, version, body) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, ins, id, version, body); err != nil {
+		return domain.Template{}, fmt.Errorf("insert template version: %w", err)
+	}
+	const sel = templateSelect + `WHERE t.id = $1 AND v.version = $2`
+	t, err := scanTemplate(tx.QueryRow(ctx, sel, id, version))
+	if err != nil {
+		return domain.Template{}, fmt.Errorf("read template: %w", err)
+	}
+	return t, nil
+}
+
+// Get returns one version of a tenant's template, or the latest when version
+// is 0. Returns ErrNotFound if either does not exist.
+func (r *TemplateRepo) Get(ctx context.Context, tenantID, id int64, version int) (domain.Template, error) {
+	sel := templateSelect + `WHERE t.id = $1 AND t.tenant_id = $2 AND v.version = t.latest_version`
+	args := []any{id, tenantID}
+	if version != 0 {
+		sel = templateSelect + `WHERE t.id = $1 AND t.tenant_id = $2 AND v.version = $3`
+		args = append(args, version)
+	}
+	t, err := scanTemplate(r.Pool.QueryRow(ctx, sel, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Template{}, ErrNotFound
+		}
+		return domain.Template{}, fmt.Errorf("get template: %w", err)
+	}
+	return t, nil
+}
+
+// List returns a page of a tenant's templates at their latest version, in id
+// order, and the id to continue after (0 on the last page).
+func (r *TemplateRepo) List(ctx context.Context, tenantID int64, q TemplateQuery) ([]domain.Template, int64, error) {
+	var w whereBuilder
+	w.add("t.tenant_id = ?", tenantID)
+	w.add("v.version = t.latest_version")
+	if q.Name != "" {
+		w.add("t.name = ?", q.Name)
+	}
+	if q.Channel != "" {
+		w.add("t.channel = ?::channel", q.Channel.String())
+	}
+	if q.AfterID != 0 {
+		w.add("t.id > ?", q.AfterID)
+	}
+	sql := templateSelect + w.String() + `
+ORDER BY t.id ASC
+LIMIT ` + w.arg(q.Limit+1)
+
+	out, err := r.query(ctx, sql, w.args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list templates: %w", err)
+	}
+	var next int64
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+		next = out[len(out)-1].ID
+	}
+	return out, next, nil
+}
+
+// Versions returns every version of a tenant's template, oldest first.
+func (r *TemplateRepo) Versions(ctx context.Context, tenantID, id int64) ([]domain.Template, error) {
+	const sel = templateSelect + `WHERE t.id = $1 AND t.tenant_id = $2 ORDER BY v.version ASC`
+	out, err := r.query(ctx, sel, id, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list template versions: %w", err)
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+// Delete removes a tenant's template with all its versions. Messages rendered
+// from it keep their body but lose the reference.
+func (r *TemplateRepo) Delete(ctx context.Context, tenantID, id int64) error {
+	tag, err := r.Pool.Exec(ctx, `DELETE FROM templates WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("delete template: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *TemplateRepo) query(ctx context.Context, sql string, args ...any) ([]domain.Template, error) {
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]domain.Template, 0)
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func scanTemplate(row pgx.Row) (domain.Template, error) {
+	var t domain.Template
+	var ch string
+	if err := row.Scan(&t.ID, &t.TenantID, &t.Name, &ch, &t.Version, &t.Body, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return domain.Template{}, err
+	}
+	t.Channel = domain.Channel(ch)
+	// bodies are validated before they are stored
+	t.Variables, _ = domain.ParsePlaceholders(t.Body)
+	return t, nil
+}
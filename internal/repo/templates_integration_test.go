@@ -0,0 +1,84 @@
+//go:build integration
+// +build integration
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rdavison/messaging-service/internal/domain"
+)
+
+func TestTemplateVersions(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+
+	r := NewTemplateRepo(pool)
+	ctx := context.Background()
+	tenantID := domain.DefaultTenantID
+	name := "tmpl-" + uuid.NewString()
+
+	v1, err := r.Create(ctx, tenantID, name, domain.ChannelSMS, "Hi {{name}}")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Delete(ctx, tenantID, v1.ID) })
+	if v1.Version != 1 || len(v1.Variables) != 1 || v1.Variables[0] != "name" {
+		t.Fatalf("unexpected v1: %+v", v1)
+	}
+	if _, err := r.Create(ctx, tenantID, name, domain.ChannelSMS, "dup"); !errors.Is(err, ErrTemplateExists) {
+		t.Fatalf("want ErrTemplateExists, got %v", err)
+	}
+
+	v2, err := r.AddVersion(ctx, tenantID, v1.ID, "Hello {{name}}, code {{code}}")
+	if err != nil {
+		t.Fatalf("add version: %v", err)
+	}
+	if v2.ID != v1.ID || v2.Version != 2 {
+		t.Fatalf("unexpected v2: %+v", v2)
+	}
+
+	latest, err := r.Get(ctx, tenantID, v1.ID, 0)
+	if err != nil || latest.Version != 2 {
+		t.Fatalf("get latest: %+v, %v", latest, err)
+	}
+	old, err := r.Get(ctx, tenantID, v1.ID, 1)
+	if err != nil || old.Body != "Hi {{name}}" {
+		t.Fatalf("get v1: %+v, %v", old, err)
+	}
+	if _, err := r.Get(ctx, tenantID, v1.ID, 3); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound for v3, got %v", err)
+	}
+	if _, err := r.Get(ctx, tenantID+1, v1.ID, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound for another tenant, got %v", err)
+	}
+
+	versions, err := r.Versions(ctx, tenantID, v1.ID)
+	if err != nil || len(versions) != 2 || versions[0].Version != 1 {
+		t.Fatalf("versions: %+v, %v", versions, err)
+	}
+
+	list, _, err := r.List(ctx, tenantID, TemplateQuery{Name: name, Limit: 10})
+	if err != nil || len(list) != 1 || list[0].Version != 2 {
+		t.Fatalf("list: %+v, %v", list, err)
+	}
+
+	if err := r.Delete(ctx, tenantID, v1.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := r.Versions(ctx, tenantID, v1.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound after delete, got %v", err)
+	}
+}
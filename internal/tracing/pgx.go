@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatement bounds the SQL recorded on query spans.
+const maxStatement = 2000
+
+// QueryTracer records a client span for every query run through a pgx
+// connection, as a child of the span in the query's context. Queries outside
+// a sampled trace are not recorded.
+type QueryTracer struct{}
+
+var _ pgx.QueryTracer = QueryTracer{}
+
+type querySpanKey struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx
+	}
+	sql := strings.TrimSpace(data.SQL)
+	ctx, span := Start(ctx, "db "+operation(sql), trace.SpanKindClient)
+	if len(sql) > maxStatement {
+		sql = sql[:maxStatement]
+	}
+	span.SetAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", sql),
+	)
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	RecordError(span, data.Err)
+	span.End()
+}
+
+// operation is the first keyword of a statement, e.g. SELECT or WITH.
+func operation(sql string) string {
+	if i := strings.IndexFunc(sql, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' || r == '(' }); i > 0 {
+		sql = sql[:i]
+	}
+	return strings.ToUpper(sql)
+}
@@ -0,0 +1,106 @@
+// Package tracing sets up OpenTelemetry: spans are exported over OTLP/HTTP and
+// propagated with the W3C trace context.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of the service's own spans.
+const instrumentation = "github.com/rdavison/messaging-service"
+
+// propagator reads and writes W3C traceparent and baggage headers.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Options configures Init.
+type Options struct {
+	// ServiceName is the service.name of the exported spans unless
+	// OTEL_SERVICE_NAME or OTEL_RESOURCE_ATTRIBUTES set one.
+	ServiceName string
+	Logger      *slog.Logger
+}
+
+// Init installs the global tracer provider and the W3C trace context
+// propagator. Spans are exported when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set; the exporter reads its other
+// OTEL_EXPORTER_OTLP_* settings, and the sampler OTEL_TRACES_SAMPLER, from
+// the environment itself. Without an endpoint spans still get ids, so that
+// traceparents are passed on and logs carry a trace_id, but none is sampled.
+// The returned function flushes the spans still queued and stops exporting.
+func Init(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	otel.SetTextMapPropagator(propagator)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		opts.Logger.Warn("tracing: export failed", "error", err)
+	}))
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", opts.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf("tracing: resource: %w", err)
+	}
+	tpOpts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: exporter: %w", err)
+		}
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(exp))
+	} else {
+		tpOpts = append(tpOpts, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.NeverSample())))
+	}
+	tp := sdktrace.NewTracerProvider(tpOpts...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start begins a span as a child of the span, or remote parent, in ctx.
+func Start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// RecordError records err on span and marks the span as failed; nil errors
+// are ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// ContextWithRemoteParent makes the span described by traceparent, started in
+// another process, the parent of spans started from the returned context.
+// Invalid values are ignored.
+func ContextWithRemoteParent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Traceparent is the W3C traceparent of the current span, or "" if there is
+// none.
+func Traceparent(ctx context.Context) string {
+	c := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, c)
+	return c.Get("traceparent")
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const remoteParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// record installs a tracer provider that keeps every span in memory.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestStartContinuesRemoteParent(t *testing.T) {
+	record(t)
+	ctx, parent := Start(ContextWithRemoteParent(context.Background(), remoteParent), "process", trace.SpanKindConsumer)
+	_, child := Start(ctx, "send", trace.SpanKindClient)
+
+	remote := trace.SpanContextFromContext(ContextWithRemoteParent(context.Background(), remoteParent))
+	if !remote.IsValid() || !remote.IsSampled() {
+		t.Fatalf("traceparent not parsed: %+v", remote)
+	}
+	ps, cs := parent.(sdktrace.ReadOnlySpan), child.(sdktrace.ReadOnlySpan)
+	if ps.SpanContext().TraceID() != remote.TraceID() || ps.Parent().SpanID() != remote.SpanID() || !ps.SpanContext().IsSampled() {
+		t.Fatalf("parent span does not continue the remote trace: %+v", ps.SpanContext())
+	}
+	if cs.SpanContext().TraceID() != remote.TraceID() || cs.Parent().SpanID() != ps.SpanContext().SpanID() {
+		t.Fatalf("child span is not under its parent")
+	}
+	if got, want := Traceparent(ctx), "00-"+remote.TraceID().String()+"-"+ps.SpanContext().SpanID().String()+"-01"; got != want {
+		t.Fatalf("context traceparent %q, want %q", got, want)
+	}
+	if tp := Traceparent(ContextWithRemoteParent(context.Background(), "garbage")); tp != "" {
+		t.Fatalf("invalid traceparent accepted: %q", tp)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := record(t)
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/messages/7", nil)
+	req.Header.Set("traceparent", remoteParent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /api/messages/{id}" || s.SpanKind() != trace.SpanKindServer || s.Status().Code != codes.Error {
+		t.Fatalf("got span %q kind %v status %v", s.Name(), s.SpanKind(), s.Status())
+	}
+	if s.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace not continued: %s", s.SpanContext().TraceID())
+	}
+	if got, want := w.Header().Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-"+s.SpanContext().SpanID().String()+"-01"; got != want {
+		t.Fatalf("response traceparent %q, want %q", got, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	got := make(chan *collectortrace.ExportTraceServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer t" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := new(collectortrace.ExportTraceServiceRequest)
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got <- req
+	}))
+	defer srv.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20t")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	shutdown, err := Init(context.Background(), Options{ServiceName: "test"})
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	_, span := Start(context.Background(), "GET /api/messages", trace.SpanKindServer)
+	RecordError(span, errors.New("boom"))
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	req := <-got
+	rs := req.ResourceSpans[0]
+	var service string
+	for _, a := range rs.Resource.Attributes {
+		if a.Key == "service.name" {
+			service = a.Value.GetStringValue()
+		}
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if service != "test" || len(spans) != 1 {
+		t.Fatalf("service %q, %d spans", service, len(spans))
+	}
+	if s := spans[0]; s.Name != "GET /api/messages" || s.Status.GetCode() != 2 || len(s.TraceId) != 16 {
+		t.Fatalf("got %+v", s)
+	}
+}
@@ -0,0 +1,15 @@
+-- 002_outbox_leases.sql
+-- Lease columns so several processors can claim outbox rows without
+-- double-sending. A lease that is past lease_expires_at is reclaimable.
+
+BEGIN;
+
+ALTER TABLE messages
+  ADD COLUMN lease_owner TEXT,
+  ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS ix_messages_lease_expires_at ON messages(lease_expires_at) WHERE status_tag = 'outbox' OR status_tag = 'retry';
+
+INSERT INTO schema_migrations (version) VALUES ('002_outbox_leases');
+
+COMMIT;
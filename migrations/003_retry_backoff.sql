@@ -0,0 +1,17 @@
+-- 003_retry_backoff.sql
+-- Attempt bookkeeping for outbound delivery. next_attempt_at holds a retry
+-- back until its backoff has elapsed; attempt_history keeps one JSON object
+-- per send attempt.
+
+BEGIN;
+
+ALTER TABLE messages
+  ADD COLUMN attempt_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN next_attempt_at TIMESTAMPTZ,
+  ADD COLUMN attempt_history JSONB NOT NULL DEFAULT '[]'::jsonb;
+
+CREATE INDEX IF NOT EXISTS ix_messages_next_attempt_at ON messages(next_attempt_at) WHERE status_tag = 'retry';
+
+INSERT INTO schema_migrations (version) VALUES ('003_retry_backoff');
+
+COMMIT;
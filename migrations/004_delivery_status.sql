@@ -0,0 +1,14 @@
+-- 004_delivery_status.sql
+-- Statuses reported by provider delivery callbacks after a message has been
+-- accepted (status 'ok').
+
+BEGIN;
+
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'sent';
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'delivered';
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'undelivered';
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'bounced';
+
+INSERT INTO schema_migrations (version) VALUES ('004_delivery_status');
+
+COMMIT;
@@ -0,0 +1,40 @@
+-- 005_tenants_api_keys.sql
+-- Tenants, their API keys (only a SHA-256 hash is stored) and tenant
+-- ownership of conversations and messages. Rows that predate tenancy belong
+-- to the 'default' tenant.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS tenants (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO tenants (id, name) VALUES (1, 'default');
+SELECT setval('tenants_id_seq', (SELECT max(id) FROM tenants));
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  name TEXT NOT NULL DEFAULT '',
+  key_prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS ix_api_keys_tenant_id ON api_keys(tenant_id);
+CREATE INDEX IF NOT EXISTS ix_api_keys_key_prefix ON api_keys(key_prefix);
+
+ALTER TABLE conversations
+  ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants(id);
+ALTER TABLE messages
+  ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants(id);
+
+CREATE INDEX IF NOT EXISTS ix_conversations_tenant_id ON conversations(tenant_id);
+CREATE INDEX IF NOT EXISTS ix_messages_tenant_sent_at ON messages(tenant_id, sent_at DESC, id DESC);
+
+INSERT INTO schema_migrations (version) VALUES ('005_tenants_api_keys');
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- 006_idempotency_keys.sql
+-- Idempotency-Key headers seen on outbound sends, per tenant, with a
+-- fingerprint of the request they were first used with.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  tenant_id BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  fingerprint TEXT NOT NULL,
+  message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (tenant_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS ix_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+INSERT INTO schema_migrations (version) VALUES ('006_idempotency_keys');
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- 007_pagination_indexes.sql
+-- Indexes for keyset pagination: messages by (sent_at, id) within a
+-- conversation, conversations by id within a tenant.
+
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS ix_messages_conversation_sent_at ON messages(conversation_id, sent_at, id);
+CREATE INDEX IF NOT EXISTS ix_conversations_tenant_id_id ON conversations(tenant_id, id);
+DROP INDEX IF EXISTS ix_conversations_tenant_id;
+
+INSERT INTO schema_migrations (version) VALUES ('007_pagination_indexes');
+
+COMMIT;
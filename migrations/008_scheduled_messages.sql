@@ -0,0 +1,17 @@
+-- 008_scheduled_messages.sql
+-- Outbound messages can be scheduled for later: they wait in status
+-- 'scheduled' until send_at, then the processor dispatches them.
+
+BEGIN;
+
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'scheduled';
+
+ALTER TABLE messages ADD COLUMN send_at TIMESTAMPTZ;
+
+-- the new enum value cannot be used before this transaction commits, so the
+-- partial index is keyed on send_at instead of status_tag = 'scheduled'
+CREATE INDEX IF NOT EXISTS ix_messages_send_at ON messages(send_at) WHERE send_at IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES ('008_scheduled_messages');
+
+COMMIT;
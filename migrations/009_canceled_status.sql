@@ -0,0 +1,10 @@
+-- 009_canceled_status.sql
+-- Terminal status for outbound messages withdrawn before dispatch.
+
+BEGIN;
+
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'canceled';
+
+INSERT INTO schema_migrations (version) VALUES ('009_canceled_status');
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- 010_batches.sql
+-- Batch sends: one row per POST /api/messages/batch request, referenced by
+-- the messages it created.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS batches (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  total INT NOT NULL,
+  rejected INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ix_batches_tenant_id ON batches(tenant_id);
+
+ALTER TABLE messages ADD COLUMN batch_id BIGINT REFERENCES batches(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS ix_messages_batch_id ON messages(batch_id) WHERE batch_id IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES ('010_batches');
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- 011_templates.sql
+-- Named, versioned message templates per tenant and channel. Every edit adds
+-- a row to template_versions; messages rendered from a template record which
+-- version they used.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS templates (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  channel channel NOT NULL,
+  latest_version INT NOT NULL DEFAULT 1,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (tenant_id, name, channel)
+);
+
+CREATE TABLE IF NOT EXISTS template_versions (
+  template_id BIGINT NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+  version INT NOT NULL,
+  body TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (template_id, version)
+);
+
+ALTER TABLE messages
+  ADD COLUMN template_id BIGINT REFERENCES templates(id) ON DELETE SET NULL,
+  ADD COLUMN template_version INT;
+
+INSERT INTO schema_migrations (version) VALUES ('011_templates');
+
+COMMIT;
@@ -0,0 +1,25 @@
+-- 012_outbox_notify.sql
+-- Notify listening processors whenever an outbound message enters the outbox,
+-- so they can send it without waiting for their next poll. Notifications with
+-- the same payload are sent once per transaction, so a batch insert wakes a
+-- processor only once.
+
+BEGIN;
+
+CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('messages_outbox', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS messages_notify_outbox ON messages;
+CREATE TRIGGER messages_notify_outbox
+  AFTER INSERT ON messages
+  FOR EACH ROW
+  WHEN (NEW.status_tag = 'outbox')
+  EXECUTE FUNCTION notify_outbox();
+
+INSERT INTO schema_migrations (version) VALUES ('012_outbox_notify');
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- 013_message_traceparent.sql
+-- The W3C traceparent of the request that created a message, so the
+-- processor that sends it can continue the same trace.
+
+BEGIN;
+
+ALTER TABLE messages ADD COLUMN traceparent TEXT;
+
+INSERT INTO schema_migrations (version) VALUES ('013_message_traceparent');
+
+COMMIT;
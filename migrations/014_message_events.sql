@@ -0,0 +1,65 @@
+-- 014_message_events.sql
+-- Append-only history of every status change and delivery attempt of a
+-- message. A trigger writes it in the transaction that changes the message;
+-- status_actor names who made the latest change.
+
+BEGIN;
+
+ALTER TABLE messages ADD COLUMN status_actor TEXT;
+
+CREATE TABLE IF NOT EXISTS message_events (
+  id BIGSERIAL PRIMARY KEY,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  from_status status, -- NULL when the message was created
+  to_status status NOT NULL,
+  provider_id TEXT,
+  provider_message_id TEXT,
+  payload TEXT, -- status payload, e.g. the provider's response or error
+  attempt INTEGER NOT NULL DEFAULT 0,
+  actor TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ix_message_events_message_id ON message_events(message_id, id);
+
+CREATE OR REPLACE FUNCTION record_message_event() RETURNS trigger AS $$
+DECLARE
+  from_status status;
+  attempt_provider TEXT;
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    IF NEW.status_tag = OLD.status_tag AND NEW.attempt_count = OLD.attempt_count THEN
+      RETURN NULL;
+    END IF;
+    from_status := OLD.status_tag;
+    IF NEW.attempt_count <> OLD.attempt_count THEN
+      attempt_provider := NEW.attempt_history -> -1 ->> 'provider';
+    END IF;
+  END IF;
+  INSERT INTO message_events (
+    message_id, from_status, to_status, provider_id, provider_message_id,
+    payload, attempt, actor
+  ) VALUES (
+    NEW.id, from_status, NEW.status_tag, COALESCE(NEW.provider_id, attempt_provider), NEW.provider_message_id,
+    NEW.status_payload, NEW.attempt_count, COALESCE(NEW.status_actor, 'system')
+  );
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS messages_record_event ON messages;
+CREATE TRIGGER messages_record_event
+  AFTER INSERT OR UPDATE OF status_tag, attempt_count ON messages
+  FOR EACH ROW
+  EXECUTE FUNCTION record_message_event();
+
+-- earlier attempts survive in attempt_history; their previous status is not
+-- known
+INSERT INTO message_events (message_id, to_status, provider_id, payload, attempt, actor, created_at)
+SELECT m.id, (a ->> 'status')::status, a ->> 'provider', a ->> 'payload', (a ->> 'number')::int, 'processor', (a ->> 'at')::timestamptz
+FROM messages m, jsonb_array_elements(m.attempt_history) AS a
+ORDER BY m.id, (a ->> 'number')::int;
+
+INSERT INTO schema_migrations (version) VALUES ('014_message_events');
+
+COMMIT;
@@ -0,0 +1,100 @@
+-- 015_subscriptions.sql
+-- Customer webhook subscriptions. Triggers on message_events and
+-- conversations queue one delivery per matching subscription in the
+-- transaction that produced the event; the processor POSTs them.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL, -- HMAC-SHA256 key of the X-Webhook-Signature header
+  event_types TEXT[] NOT NULL,
+  consecutive_failures INTEGER NOT NULL DEFAULT 0,
+  disabled_at TIMESTAMPTZ,
+  disabled_reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ix_subscriptions_tenant_id ON subscriptions(tenant_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  subscription_id BIGINT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+  event_type TEXT NOT NULL,
+  message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+  message_event_id BIGINT REFERENCES message_events(id) ON DELETE CASCADE,
+  conversation_id BIGINT REFERENCES conversations(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | failed
+  attempt_count INTEGER NOT NULL DEFAULT 0,
+  attempt_history JSONB NOT NULL DEFAULT '[]'::jsonb,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  lease_owner TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id, id);
+
+-- message.received: an inbound message was stored
+-- message.sent:     a provider accepted an outbound message
+-- message.failed:   an outbound message failed or was reported undelivered
+CREATE OR REPLACE FUNCTION enqueue_message_webhooks() RETURNS trigger AS $$
+DECLARE
+  m_tenant_id BIGINT;
+  m_direction inbound_or_outbound;
+  m_conversation_id BIGINT;
+  evt TEXT;
+BEGIN
+  SELECT tenant_id, inbound_or_outbound, conversation_id
+  INTO m_tenant_id, m_direction, m_conversation_id
+  FROM messages WHERE id = NEW.message_id;
+
+  IF m_direction = 'inbound' AND NEW.from_status IS NULL THEN
+    evt := 'message.received';
+  ELSIF m_direction = 'outbound' AND NEW.to_status = 'ok' AND NEW.from_status IS DISTINCT FROM 'ok' THEN
+    evt := 'message.sent';
+  ELSIF m_direction = 'outbound' AND NEW.to_status IN ('failed', 'undelivered', 'bounced')
+      AND NEW.from_status IS DISTINCT FROM NEW.to_status THEN
+    evt := 'message.failed';
+  ELSE
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO webhook_deliveries (subscription_id, event_type, message_id, message_event_id, conversation_id)
+  SELECT s.id, evt, NEW.message_id, NEW.id, m_conversation_id
+  FROM subscriptions s
+  WHERE s.tenant_id = m_tenant_id AND s.disabled_at IS NULL AND evt = ANY(s.event_types);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS message_events_enqueue_webhooks ON message_events;
+CREATE TRIGGER message_events_enqueue_webhooks
+  AFTER INSERT ON message_events
+  FOR EACH ROW
+  EXECUTE FUNCTION enqueue_message_webhooks();
+
+CREATE OR REPLACE FUNCTION enqueue_conversation_webhooks() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO webhook_deliveries (subscription_id, event_type, conversation_id)
+  SELECT s.id, 'conversation.created', NEW.id
+  FROM subscriptions s
+  WHERE s.tenant_id = NEW.tenant_id AND s.disabled_at IS NULL AND 'conversation.created' = ANY(s.event_types);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS conversations_enqueue_webhooks ON conversations;
+CREATE TRIGGER conversations_enqueue_webhooks
+  AFTER INSERT ON conversations
+  FOR EACH ROW
+  EXECUTE FUNCTION enqueue_conversation_webhooks();
+
+INSERT INTO schema_migrations (version) VALUES ('015_subscriptions');
+
+COMMIT;
@@ -0,0 +1,27 @@
+-- 016_conversation_notify.sql
+-- Notify listening API servers of every message event, so conversation
+-- streams can push new messages and status changes as they are committed.
+-- The payload is "<conversation_id>:<message_event_id>"; listeners load the
+-- event itself, since NOTIFY payloads are limited to 8000 bytes.
+
+BEGIN;
+
+CREATE OR REPLACE FUNCTION notify_conversation_event() RETURNS trigger AS $$
+DECLARE
+  m_conversation_id BIGINT;
+BEGIN
+  SELECT conversation_id INTO m_conversation_id FROM messages WHERE id = NEW.message_id;
+  PERFORM pg_notify('conversation_events', m_conversation_id || ':' || NEW.id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS message_events_notify_conversation ON message_events;
+CREATE TRIGGER message_events_notify_conversation
+  AFTER INSERT ON message_events
+  FOR EACH ROW
+  EXECUTE FUNCTION notify_conversation_event();
+
+INSERT INTO schema_migrations (version) VALUES ('016_conversation_notify');
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- 017_conversation_activity.sql
+-- Index to find a conversation's latest message, which is how recently
+-- active conversations are told apart: conversations.updated_at only moves
+-- when the conversation row itself changes.
+
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS ix_messages_conversation_created_at ON messages(conversation_id, created_at DESC, id DESC);
+
+INSERT INTO schema_migrations (version) VALUES ('017_conversation_activity');
+
+COMMIT;